@@ -1,9 +1,11 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"github.com/krilor/gossh"
 	"github.com/krilor/gossh/rules/x/apt"
@@ -23,19 +25,27 @@ func main() {
 	defer f.Close()
 	log.SetOutput(f)
 
-	// Add a host to the inventory
-	// As of now, it's hardcoded to a docker container on localhost
-	m, err := gossh.NewRemoteHost("localhost:2222", "gossh", "gosshpwd", ssh.InsecureIgnoreHostKey(), ssh.Password("gosshpwd"))
+	// All events are written as JSON lines to the log file, in addition to the tree on stdout
+	reporter := gossh.MultiReporter(gossh.NewTreeReporter(os.Stdout), gossh.NewJSONReporter(f))
+
+	// Load the inventory from file
+	// As of now, it's a docker container on localhost
+	inventory, err := gossh.NewInventoryFromFile("./inventory.yml", ssh.InsecureIgnoreHostKey())
 	if err != nil {
-		fmt.Printf("could not get new host %v: %v\n", m, err)
+		fmt.Printf("could not load inventory: %v\n", err)
 		return
 	}
 
-	inventory := gossh.Inventory{}
-	inventory.Add(m)
+	// Only bootstrap the hosts in the bootstrap group
+	inventory, err = inventory.Limit("bootstrap")
+	if err != nil {
+		fmt.Printf("could not limit inventory: %v\n", err)
+		return
+	}
 
-	// TODO - add inventory from files, e.g.:
-	// gossh.NewInventoryFromFile("./inventory.json")
+	for _, m := range inventory.Hosts() {
+		m.Reporter = reporter
+	}
 
 	bootstrap := base.Multi{}
 
@@ -43,9 +53,13 @@ func main() {
 	bootstrap.Add(file.Exists{Path: "/tmp/hello.nothing2"})
 
 	// apt.Package installs/uninstalls a apt package
-	bootstrap.Add(apt.Package{
-		Name:   "libccdasdas98h9h",
-		Status: apt.StatusInstalled,
+	// base.Timeout aborts the install if it hangs
+	bootstrap.Add(base.Timeout{
+		Rule: apt.Package{
+			Name:   "libccdasdas98h9h",
+			Status: apt.StatusInstalled,
+		},
+		Timeout: 5 * time.Minute,
 	})
 
 	// This rule does nothing useful, but just shows off the use of a simple cmd based rule
@@ -60,13 +74,19 @@ func main() {
 	// This is where it starts to get hairy. The Meta rule is used to create a custom rule on the fly.
 	// The example is quite simple and not very useful, but shows how to use commands directly on m,
 	//  as well as reusing the Ensure command of another Rule
+	// The directory is the variable tmpdir of the host, set for the bootstrap group in the inventory
 	filename := "somefile.txt"
 
 	bootstrap.Add(base.Meta{
-		EnsureFunc: func(h *gossh.Host) (gossh.Status, error) {
+		EnsureFunc: func(ctx context.Context, h *gossh.Host) (gossh.Status, error) {
 
-			cmd := fmt.Sprintf("ls -1 /tmp | grep %s", filename)
-			r, err := h.RunCheck(cmd, "", "")
+			dir, err := h.VarString("tmpdir")
+			if err != nil {
+				return gossh.StatusFailed, err
+			}
+
+			cmd := fmt.Sprintf("ls -1 %s | grep %s", dir, filename)
+			r, err := h.RunCheck(ctx, cmd, "", "")
 			if err != nil {
 				return gossh.StatusFailed, errors.Wrap(err, "could not check for somefile")
 			}
@@ -74,16 +94,19 @@ func main() {
 				return gossh.StatusSatisfied, nil
 			}
 
-			return m.Apply("file exists", file.Exists{Path: "/tmp/" + filename})
+			return h.Apply(ctx, "file exists", file.Exists{Path: dir + "/" + filename})
 		},
 	})
 
-	for _, m := range inventory {
-		log.Println("doing host", m)
-		_, err = m.Apply("bootstrap", bootstrap)
-		if err != nil {
-			fmt.Println("apply of bootstrap gone wrong", err)
-		}
+	// The whole run is aborted if it takes more than 10 minutes
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	// Apply bootstrap on all hosts in the inventory, up to 10 at a time
+	res := inventory.Apply(ctx, "bootstrap", bootstrap, gossh.ApplyOptions{Forks: 10})
+
+	for _, hr := range res.Failed() {
+		fmt.Println("apply of bootstrap gone wrong on", hr.Host, hr.Err)
 	}
 
 }
@@ -1,8 +1,10 @@
 package gossh
 
 import (
-	"fmt"
+	"context"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -17,71 +19,253 @@ const (
 	OS Fact = iota
 	// OSFamily is the family of operating systems
 	OSFamily
-	// OSVersion is the os version
+	// OSVersion is the major os version
 	OSVersion
+	// OSFullVersion is the full os version
+	OSFullVersion
+	// OSCodename is the codename of the os release
+	OSCodename
+	// PrettyName is the pretty name of the os
+	PrettyName
+	// Hostname is the short hostname
+	Hostname
+	// FQDN is the fully qualified domain name
+	FQDN
+	// KernelRelease is the kernel release, e.g. 5.4.0-42-generic
+	KernelRelease
+	// Architecture is the machine hardware name, e.g. x86_64 or aarch64
+	Architecture
+	// CPUCount is the number of available CPUs
+	CPUCount
+	// MemTotal is the total memory in bytes
+	MemTotal
+	// DefaultInterface is the interface of the default IPv4 route
+	DefaultInterface
+	// DefaultGateway is the gateway of the default IPv4 route
+	DefaultGateway
+	// DefaultIPv4 is the first IPv4 address of the default interface
+	DefaultIPv4
+	// InitSystem is the init system, e.g. systemd, openrc or sysvinit
+	InitSystem
+	// PkgManager is the package manager, e.g. apt, dnf, yum, zypper, apk or pacman
+	PkgManager
+	// Virtualization is the detected virtualization or container technology, e.g. kvm or docker. none if not detected.
+	Virtualization
 )
 
 // Facts are gathered from the machine
+//
+// Facts returned from Host.Facts are shared, and must not be gathered again. Use Host.RefreshFacts instead.
 type Facts struct {
-	kv       map[Fact]string
-	gathered bool
+	kv         map[Fact]string
+	release    map[string]string
+	interfaces []Interface
+	mounts     []Mount
+	custom     map[string]string
+	errs       map[string]error
+	gatheredAt time.Time
 }
 
-// Gather gathers facts about the machine
-func (f *Facts) Gather(m *Host) error {
+// Gather gathers facts about the machine, including the custom facts of all fact providers.
+func (f *Facts) Gather(ctx context.Context, m *Host) error {
 
 	if f.kv == nil {
 		f.kv = map[Fact]string{}
 	}
+	if f.custom == nil {
+		f.custom = map[string]string{}
+	}
+	if f.errs == nil {
+		f.errs = map[string]error{}
+	}
 
-	r, err := m.RunCheck(`cat /etc/*release | tr '[:upper:]' '[:lower:]'`, "", "")
+	err := f.gatherRelease(ctx, m)
+	if err != nil {
+		return err
+	}
 
+	err = f.gatherSystem(ctx, m)
 	if err != nil {
-		return errors.Wrap(err, "getting release info errored")
+		return err
 	}
 
-	if !r.Success() {
-		return fmt.Errorf("getting release info failed: %s", r.Stderr)
+	f.gatherCustom(ctx, m)
+
+	f.gatheredAt = time.Now()
+
+	return nil
+
+}
+
+// Get returns the value of fact. Ok is false if the fact was not found.
+func (f *Facts) Get(fact Fact) (value string, ok bool) {
+	value, ok = f.kv[fact]
+	return value, ok
+}
+
+// Gathered reports if the facts has been gathered
+func (f *Facts) Gathered() bool {
+	return !f.gatheredAt.IsZero()
+}
+
+// GatheredAt returns the time the facts was gathered
+func (f *Facts) GatheredAt() time.Time {
+	return f.gatheredAt
+}
+
+// OS returns the operating system, e.g. ubuntu or centos. Empty if not found.
+func (f *Facts) OS() string {
+	return f.kv[OS]
+}
+
+// OSFamily returns the family of the operating system, e.g. debian or rhel. Empty if not found.
+func (f *Facts) OSFamily() string {
+	return f.kv[OSFamily]
+}
+
+// OSVersion returns the major version of the operating system, e.g. 18 or 7. Empty if not found.
+func (f *Facts) OSVersion() string {
+	return f.kv[OSVersion]
+}
+
+// hostFacts holds the facts of a host, gathered on first use
+type hostFacts struct {
+	mu    sync.Mutex
+	facts *Facts
+	// gathering is the gathering in progress, if any
+	gathering *factGathering
+}
+
+// factGathering is a gathering of facts that concurrent callers can wait for.
+// Done is closed when the gathering is finished, and f or err is set.
+type factGathering struct {
+	done chan struct{}
+	f    *Facts
+	err  error
+	// aborted is true if the gathering failed because the ctx of the caller that gathered was done
+	aborted bool
+}
+
+// Facts returns the facts of h.
+//
+// The facts are gathered on first use, and then cached. Concurrent callers wait for the same gathering, or until their ctx is done.
+// If gathering fails, the error is returned and facts will be gathered again on next call.
+//
+// Fact providers, and rules they apply, get the facts gathered so far when calling Facts on the host they are given.
+//
+// If h.FactCache is set, facts found there that has not expired are used instead of gathering them.
+func (h *Host) Facts(ctx context.Context) (*Facts, error) {
+	return h.loadFacts(ctx, false)
+}
+
+// RefreshFacts gathers the facts of h again, e.g. after changes that affects them, and returns them.
+// h.FactCache is not consulted, but is updated with the new facts.
+// If a gathering is already in progress, its result is returned instead.
+//
+// Facts previously returned from Facts are not modified.
+func (h *Host) RefreshFacts(ctx context.Context) (*Facts, error) {
+	return h.loadFacts(ctx, true)
+}
+
+// loadFacts returns the cached facts of h, unless refresh is true, or waits for a gathering of them.
+// The lock is only held to look up and store facts, never while gathering.
+func (h *Host) loadFacts(ctx context.Context, refresh bool) (*Facts, error) {
+	if h.gathering != nil {
+		return h.gathering, nil
 	}
 
-	kv := parseINI(r.Stdout)
-
-	for _, item := range []struct {
-		key  string
-		fact Fact
-	}{
-		{"id", OS},
-		{"id_like", OSFamily},
-		{"version_id", OSVersion},
-	} {
-		value, ok := kv[item.key]
-		if ok {
-			f.kv[item.fact] = value
+	for {
+		h.facts.mu.Lock()
+		if !refresh && h.facts.facts != nil {
+			f := h.facts.facts
+			h.facts.mu.Unlock()
+			return f, nil
+		}
+
+		g := h.facts.gathering
+		if g == nil {
+			g = &factGathering{done: make(chan struct{})}
+			h.facts.gathering = g
+			h.facts.mu.Unlock()
+
+			h.gatherFacts(ctx, g, !refresh)
+			return g.f, g.err
 		}
+		h.facts.mu.Unlock()
+
+		select {
+		case <-g.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		// the gathering was aborted by another caller, so it is retried
+		if g.aborted && ctx.Err() == nil {
+			continue
+		}
+
+		return g.f, g.err
 	}
+}
 
-	// Ensure that debian also set os family debian
-	if v, ok := f.kv[OS]; ok && v == "debian" {
-		f.kv[OSFamily] = "debian"
+// gatherFacts does the gathering g, and caches the facts on h.
+// If useCache is true, facts found in h.FactCache are used instead of gathering them.
+func (h *Host) gatherFacts(ctx context.Context, g *factGathering, useCache bool) {
+	defer func() {
+		h.facts.mu.Lock()
+		h.facts.gathering = nil
+		if g.err == nil {
+			h.facts.facts = g.f
+		}
+		h.facts.mu.Unlock()
+		close(g.done)
+	}()
+
+	if useCache && h.FactCache != nil {
+		f, err := h.FactCache.Load(h.String())
+		if err != nil {
+			h.Log("could not load cached facts", "error", err.Error())
+		}
+		if f != nil {
+			g.f = f
+			return
+		}
 	}
 
-	// Ensure that fedora also set os family rhel
-	if v, ok := f.kv[OS]; ok && v == "fedora" {
-		f.kv[OSFamily] = "rhel"
+	// the facts are gathered on a view of h, where Facts returns the facts gathered so far
+	f := &Facts{}
+	view := *h
+	view.gathering = f
+
+	err := f.Gather(ctx, &view)
+	if err != nil {
+		g.err = errors.Wrap(err, "could not gather facts")
+		g.aborted = ctx.Err() != nil
+		return
 	}
 
-	// Set rhel consistently when fedora
-	if v, ok := f.kv[OSFamily]; ok && strings.Contains(v, "fedora") {
-		f.kv[OSFamily] = "rhel"
+	g.f = f
+
+	if h.FactCache != nil {
+		err = h.FactCache.Save(h.String(), f)
+		if err != nil {
+			h.Log("could not cache facts", "error", err.Error())
+		}
 	}
+}
 
-	// Only return majorversion
-	f.kv[OSVersion] = majorVersion(f.kv[OSVersion])
+// InvalidateFacts removes the facts of h from memory and from h.FactCache, so that they are gathered again on next use.
+func (h *Host) InvalidateFacts() error {
+	h.facts.mu.Lock()
+	defer h.facts.mu.Unlock()
 
-	f.gathered = true
+	h.facts.facts = nil
 
-	return nil
+	if h.FactCache != nil {
+		return h.FactCache.Invalidate(h.String())
+	}
 
+	return nil
 }
 
 // parseINI is used to parse single-level ini-type files
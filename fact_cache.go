@@ -0,0 +1,237 @@
+package gossh
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// FactCache is an on-disk cache of facts, stored as one JSON file per host in Dir.
+//
+// It is safe to share a FactCache between hosts.
+type FactCache struct {
+	// Dir is the directory that holds the cache files. It is created if it does not exist.
+	Dir string
+	// TTL is how long cached facts are valid. Zero means that they never expire.
+	TTL time.Duration
+}
+
+// NewFactCache returns a FactCache that stores facts in dir for ttl
+func NewFactCache(dir string, ttl time.Duration) *FactCache {
+	return &FactCache{
+		Dir: dir,
+		TTL: ttl,
+	}
+}
+
+// path returns the path of the cache file for host
+func (c *FactCache) path(host string) string {
+	return filepath.Join(c.Dir, url.PathEscape(host)+".json")
+}
+
+// Load returns the cached facts of host, as given by Host.String().
+// Facts is nil if no facts are cached, or if they have expired.
+func (c *FactCache) Load(host string) (*Facts, error) {
+	f, err := c.read(c.path(host))
+	if os.IsNotExist(errors.Cause(err)) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if c.TTL > 0 && time.Since(f.gatheredAt) > c.TTL {
+		return nil, nil
+	}
+
+	return f, nil
+}
+
+// read reads the cached facts in filename
+func (c *FactCache) read(filename string) (*Facts, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read cached facts")
+	}
+
+	f := &Facts{}
+	err = json.Unmarshal(data, f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not parse cached facts in %s", filename)
+	}
+
+	return f, nil
+}
+
+// Save stores the facts of host, as given by Host.String()
+func (c *FactCache) Save(host string, f *Facts) error {
+	err := os.MkdirAll(c.Dir, 0700)
+	if err != nil {
+		return errors.Wrap(err, "could not create fact cache dir")
+	}
+
+	data, err := json.MarshalIndent(jsonFactsFrom(host, f), "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "could not encode facts")
+	}
+
+	return errors.Wrap(writeFileAtomic(c.path(host), data), "could not write facts")
+}
+
+// Invalidate removes the cached facts of host, as given by Host.String()
+func (c *FactCache) Invalidate(host string) error {
+	err := os.Remove(c.path(host))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "could not remove cached facts")
+	}
+	return nil
+}
+
+// All returns the cached facts of all hosts, keyed by host, including the ones that have expired.
+// Use Facts.GatheredAt to check their age.
+func (c *FactCache) All() (map[string]*Facts, error) {
+	all := map[string]*Facts{}
+
+	files, err := ioutil.ReadDir(c.Dir)
+	if os.IsNotExist(err) {
+		return all, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read fact cache dir")
+	}
+
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+
+		f, err := c.read(filepath.Join(c.Dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		host, err := url.PathUnescape(strings.TrimSuffix(file.Name(), ".json"))
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid fact cache file name %s", file.Name())
+		}
+
+		all[host] = f
+	}
+
+	return all, nil
+}
+
+// jsonFacts is the JSON representation of Facts
+type jsonFacts struct {
+	Host       string            `json:"host,omitempty"`
+	GatheredAt time.Time         `json:"gathered_at"`
+	Facts      map[string]string `json:"facts"`
+	OSRelease  map[string]string `json:"os_release,omitempty"`
+	Interfaces []Interface       `json:"interfaces,omitempty"`
+	Mounts     []Mount           `json:"mounts,omitempty"`
+	Custom     map[string]string `json:"custom,omitempty"`
+	Errors     map[string]string `json:"errors,omitempty"`
+}
+
+// jsonFactsFrom returns the JSON representation of the facts of host
+func jsonFactsFrom(host string, f *Facts) jsonFacts {
+	j := jsonFacts{
+		Host:       host,
+		GatheredAt: f.gatheredAt,
+		Facts:      map[string]string{},
+		OSRelease:  f.release,
+		Interfaces: f.interfaces,
+		Mounts:     f.mounts,
+		Custom:     f.custom,
+		Errors:     map[string]string{},
+	}
+
+	for fact, value := range f.kv {
+		j.Facts[fact.String()] = value
+	}
+
+	for name, err := range f.errs {
+		j.Errors[name] = err.Error()
+	}
+
+	return j
+}
+
+// MarshalJSON implements json.Marshaler for Facts
+func (f *Facts) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonFactsFrom("", f))
+}
+
+// UnmarshalJSON implements json.Unmarshaler for Facts
+func (f *Facts) UnmarshalJSON(data []byte) error {
+	j := jsonFacts{}
+	err := json.Unmarshal(data, &j)
+	if err != nil {
+		return err
+	}
+
+	f.kv = map[Fact]string{}
+	for name, value := range j.Facts {
+		fact, ok := factByName(name)
+		if !ok {
+			// facts from other versions of gossh are ignored
+			continue
+		}
+		f.kv[fact] = value
+	}
+
+	f.errs = map[string]error{}
+	for name, msg := range j.Errors {
+		f.errs[name] = errors.New(msg)
+	}
+
+	f.custom = j.Custom
+	if f.custom == nil {
+		f.custom = map[string]string{}
+	}
+
+	f.release = j.OSRelease
+	f.interfaces = j.Interfaces
+	f.mounts = j.Mounts
+	f.gatheredAt = j.GatheredAt
+
+	return nil
+}
+
+// factByName returns the Fact with name, as given by Fact.String()
+func factByName(name string) (Fact, bool) {
+	for fact := Fact(0); fact < Fact(len(_Fact_index)-1); fact++ {
+		if fact.String() == name {
+			return fact, true
+		}
+	}
+	return 0, false
+}
+
+// writeFileAtomic writes data to filename through a temporary file in the same directory, so that readers never see a partial file
+func writeFileAtomic(filename string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename))
+	if err != nil {
+		return errors.Wrap(err, "could not create temporary file")
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filename)
+}
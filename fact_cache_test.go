@@ -0,0 +1,179 @@
+package gossh
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/krilor/gossh/testing/faketarget"
+)
+
+func TestFactCache(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gossh-facts")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := NewFactCache(dir, time.Hour)
+
+	f, err := c.Load("gossh@missing")
+	if f != nil || err != nil {
+		t.Errorf("missing host: got %v %v - expect nil nil", f, err)
+	}
+
+	in := &Facts{
+		kv:         map[Fact]string{OS: "debian", OSCodename: "buster", MemTotal: "1024"},
+		release:    map[string]string{"ID": "debian"},
+		interfaces: []Interface{{Name: "eth0", IPv4: []string{"10.0.0.1/24"}}},
+		mounts:     []Mount{{Device: "/dev/sda1", FSType: "ext4", Path: "/", Size: 2048, Free: 1024}},
+		custom:     map[string]string{"datacenter": "dc1"},
+		errs:       map[string]error{},
+		gatheredAt: time.Now().Round(0),
+	}
+
+	err = c.Save("gossh@host/1:22", in)
+	if err != nil {
+		t.Fatalf("save errored: %v", err)
+	}
+
+	out, err := c.Load("gossh@host/1:22")
+	if err != nil {
+		t.Fatalf("load errored: %v", err)
+	}
+
+	if !reflect.DeepEqual(out.kv, in.kv) || !reflect.DeepEqual(out.release, in.release) ||
+		!reflect.DeepEqual(out.interfaces, in.interfaces) || !reflect.DeepEqual(out.mounts, in.mounts) ||
+		!reflect.DeepEqual(out.custom, in.custom) || !out.gatheredAt.Equal(in.gatheredAt) {
+		t.Errorf("notequal:\n got %+v\n expect %+v", out, in)
+	}
+
+	all, err := c.All()
+	if err != nil {
+		t.Fatalf("all errored: %v", err)
+	}
+	if len(all) != 1 || all["gossh@host/1:22"] == nil {
+		t.Errorf("wrong hosts in all: %v", all)
+	}
+
+	// expired facts are not loaded, but are still listed
+	in.gatheredAt = time.Now().Add(-2 * time.Hour)
+	err = c.Save("gossh@old", in)
+	if err != nil {
+		t.Fatalf("save errored: %v", err)
+	}
+
+	if f, _ := c.Load("gossh@old"); f != nil {
+		t.Errorf("expired facts loaded")
+	}
+
+	if all, _ := c.All(); len(all) != 2 {
+		t.Errorf("got %d hosts in all - expect 2", len(all))
+	}
+
+	err = c.Invalidate("gossh@host/1:22")
+	if err != nil {
+		t.Fatalf("invalidate errored: %v", err)
+	}
+
+	if f, _ := c.Load("gossh@host/1:22"); f != nil {
+		t.Errorf("invalidated facts loaded")
+	}
+
+	if err := c.Invalidate("gossh@missing"); err != nil {
+		t.Errorf("invalidate of missing host errored: %v", err)
+	}
+}
+
+func TestHostFactCache(t *testing.T) {
+
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "gossh-facts")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	newHost := func() (*Host, *faketarget.Target) {
+		ft := faketarget.New(t, "gossh")
+		expectFacts(ft, "==gossh:os-release\nID=debian\nVERSION_ID=10\n")
+		h := New(ft)
+		h.Reporter = NopReporter{}
+		h.FactCache = NewFactCache(dir, 0)
+		return h, ft
+	}
+
+	// first host gathers and saves
+	h, ft := newHost()
+	_, err = h.Facts(ctx)
+	if err != nil {
+		t.Fatalf("facts errored: %v", err)
+	}
+	if len(ft.Cmds()) == 0 {
+		t.Errorf("facts not gathered")
+	}
+
+	// second host loads from cache
+	h, ft = newHost()
+	f, err := h.Facts(ctx)
+	if err != nil {
+		t.Fatalf("facts errored: %v", err)
+	}
+	if len(ft.Cmds()) != 0 {
+		t.Errorf("facts gathered - expect cached: %v", ft.Cmds())
+	}
+	if f.OS() != "debian" || f.OSVersion() != "10" {
+		t.Errorf("wrong cached facts: %s %s", f.OS(), f.OSVersion())
+	}
+
+	// invalidated facts are gathered again
+	err = h.InvalidateFacts()
+	if err != nil {
+		t.Fatalf("invalidate errored: %v", err)
+	}
+	_, err = h.Facts(ctx)
+	if err != nil {
+		t.Fatalf("facts errored: %v", err)
+	}
+	if len(ft.Cmds()) == 0 {
+		t.Errorf("facts not gathered after invalidate")
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gossh-atomic")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "file.json")
+	for _, data := range []string{"first", "second"} {
+		err = writeFileAtomic(filename, []byte(data))
+		if err != nil {
+			t.Fatalf("write errored: %v", err)
+		}
+
+		got, err := ioutil.ReadFile(filename)
+		if err != nil || string(got) != data {
+			t.Errorf("got %q %v - expect %q", got, err, data)
+		}
+	}
+
+	// the temporary files are removed
+	files, err := ioutil.ReadDir(dir)
+	if err != nil || len(files) != 1 {
+		t.Errorf("got %d files %v - expect 1", len(files), err)
+	}
+
+	err = writeFileAtomic(filepath.Join(dir, "missing", "file.json"), []byte("data"))
+	if err == nil {
+		t.Errorf("expect error when dir does not exist")
+	}
+}
@@ -0,0 +1,158 @@
+package gossh
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// FactProvider provides custom facts, e.g. the deployed version of an app or the datacenter of a host.
+//
+// Providers are run when facts are gathered, and should use h.RunCheck to get their facts.
+// The facts are stored under string keys, and are available using Facts.Custom.
+type FactProvider interface {
+	Provide(ctx context.Context, h *Host) (facts map[string]string, err error)
+}
+
+// FactProviderFunc is a function that implements FactProvider
+type FactProviderFunc func(ctx context.Context, h *Host) (map[string]string, error)
+
+// Provide implements FactProvider
+func (f FactProviderFunc) Provide(ctx context.Context, h *Host) (map[string]string, error) {
+	return f(ctx, h)
+}
+
+// INIFacts is a FactProvider that parses the output of Cmd as single-level key=value pairs.
+// Each key is prefixed with Prefix.
+type INIFacts struct {
+	Cmd    string
+	Prefix string
+}
+
+// INIFile returns a FactProvider that reads key=value facts from the file at path.
+// Each key is prefixed with prefix.
+func INIFile(path string, prefix string) INIFacts {
+	return INIFacts{
+		Cmd:    fmt.Sprintf("cat %s", path),
+		Prefix: prefix,
+	}
+}
+
+// Provide implements FactProvider
+func (p INIFacts) Provide(ctx context.Context, h *Host) (map[string]string, error) {
+	r, err := h.RunCheck(ctx, p.Cmd, "", "")
+	if err != nil {
+		return nil, errors.Wrapf(err, "running \"%s\" errored", p.Cmd)
+	}
+
+	if !r.Success() {
+		return nil, fmt.Errorf("running \"%s\" failed: %s", p.Cmd, r.Stderr)
+	}
+
+	facts := map[string]string{}
+	for k, v := range parseINI(r.Stdout) {
+		facts[p.Prefix+k] = v
+	}
+
+	return facts, nil
+}
+
+// factProviders is a set of named fact providers
+type factProviders struct {
+	mu        sync.Mutex
+	order     []string
+	providers map[string]FactProvider
+}
+
+// newFactProviders returns an empty set of fact providers
+func newFactProviders() *factProviders {
+	return &factProviders{
+		providers: map[string]FactProvider{},
+	}
+}
+
+// add adds p named name. If a provider with the same name exists, it is replaced.
+func (fp *factProviders) add(name string, p FactProvider) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	if _, ok := fp.providers[name]; !ok {
+		fp.order = append(fp.order, name)
+	}
+	fp.providers[name] = p
+}
+
+// namedFactProvider is a FactProvider and its name
+type namedFactProvider struct {
+	name     string
+	provider FactProvider
+}
+
+// list returns the providers in the order they were added
+func (fp *factProviders) list() []namedFactProvider {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	list := []namedFactProvider{}
+	for _, name := range fp.order {
+		list = append(list, namedFactProvider{name, fp.providers[name]})
+	}
+
+	return list
+}
+
+// globalFactProviders are run when gathering facts on all hosts
+var globalFactProviders = newFactProviders()
+
+// RegisterFactProvider registers p, named name, to be run when gathering facts on all hosts.
+// If a provider with the same name is registered, it is replaced.
+//
+// Global providers are run before the providers added to a host.
+func RegisterFactProvider(name string, p FactProvider) {
+	globalFactProviders.add(name, p)
+}
+
+// AddFactProvider adds p, named name, to be run when gathering facts on h.
+// If a provider with the same name exists on h, it is replaced.
+//
+// Facts already gathered does not include the facts of p. Use RefreshFacts to gather them again.
+func (h *Host) AddFactProvider(name string, p FactProvider) {
+	h.providers.add(name, p)
+}
+
+// gatherCustom runs all global providers and all providers on m, and stores their facts.
+// If a provider fails, the error is recorded and logged, and the remaining providers are run.
+// If several providers return the same key, the last one wins.
+func (f *Facts) gatherCustom(ctx context.Context, m *Host) {
+
+	providers := globalFactProviders.list()
+	if m.providers != nil {
+		providers = append(providers, m.providers.list()...)
+	}
+
+	for _, p := range providers {
+		facts, err := p.provider.Provide(ctx, m)
+		if err != nil {
+			f.errs[p.name] = err
+			m.Log("fact provider failed", "provider", p.name, "error", err.Error())
+			continue
+		}
+
+		for k, v := range facts {
+			f.custom[k] = v
+		}
+	}
+}
+
+// Custom returns the value of the custom fact key. Ok is false if the fact was not found.
+func (f *Facts) Custom(key string) (value string, ok bool) {
+	value, ok = f.custom[key]
+	return value, ok
+}
+
+// ProviderErrors returns the errors of the fact providers that failed, by provider name
+func (f *Facts) ProviderErrors() map[string]error {
+	return f.errs
+}
@@ -0,0 +1,79 @@
+package gossh
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/krilor/gossh/testing/faketarget"
+)
+
+func TestFactProviders(t *testing.T) {
+
+	ctx := context.Background()
+
+	defer func() {
+		globalFactProviders = newFactProviders()
+	}()
+
+	RegisterFactProvider("global", FactProviderFunc(func(ctx context.Context, h *Host) (map[string]string, error) {
+		return map[string]string{"datacenter": "dc1", "rack": "r1"}, nil
+	}))
+
+	ft := faketarget.New(t, "gossh")
+	expectFacts(ft, "==gossh:os-release\nID=debian\n")
+	ft.Expect("cat /etc/myapp/release").Returns("version=1.2.3\n# comment\nchannel=\"stable\"\n", "", 0)
+	ft.Expect("cat /etc/missing").Returns("", "cat: /etc/missing: No such file or directory", 1)
+	h := New(ft)
+	h.Reporter = NopReporter{}
+
+	h.AddFactProvider("broken", FactProviderFunc(func(ctx context.Context, h *Host) (map[string]string, error) {
+		return nil, errors.New("broken")
+	}))
+	h.AddFactProvider("app", INIFile("/etc/myapp/release", "app_"))
+	h.AddFactProvider("rack", FactProviderFunc(func(ctx context.Context, h *Host) (map[string]string, error) {
+		return map[string]string{"rack": "r2"}, nil
+	}))
+
+	f, err := h.Facts(ctx)
+	if err != nil {
+		t.Fatalf("facts errored: %v", err)
+	}
+
+	for key, expect := range map[string]string{
+		"datacenter":  "dc1",
+		"rack":        "r2",
+		"app_version": "1.2.3",
+		"app_channel": "stable",
+	} {
+		got, ok := f.Custom(key)
+		if !ok || got != expect {
+			t.Errorf("%s: got %s - expect %s", key, got, expect)
+		}
+	}
+
+	if _, ok := f.Custom("version"); ok {
+		t.Errorf("unprefixed key found")
+	}
+
+	errs := f.ProviderErrors()
+	if len(errs) != 1 || errs["broken"] == nil {
+		t.Errorf("wrong provider errors: %v", errs)
+	}
+
+	// a failing command is recorded as an error
+	h.AddFactProvider("app", INIFile("/etc/missing", "app_"))
+
+	f, err = h.RefreshFacts(ctx)
+	if err != nil {
+		t.Fatalf("refresh errored: %v", err)
+	}
+
+	if _, ok := f.Custom("app_version"); ok {
+		t.Errorf("facts from replaced provider found")
+	}
+
+	if len(f.ProviderErrors()) != 2 {
+		t.Errorf("wrong provider errors: %v", f.ProviderErrors())
+	}
+}
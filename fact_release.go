@@ -0,0 +1,229 @@
+package gossh
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// releaseScript outputs os-release, redhat-release and lsb_release information, each preceded by a section marker.
+var releaseScript = strings.Join([]string{
+	`echo '` + sectionPrefix + `os-release'; cat /etc/os-release 2>/dev/null || cat /usr/lib/os-release 2>/dev/null`,
+	`echo '` + sectionPrefix + `redhat-release'; cat /etc/redhat-release 2>/dev/null`,
+	`echo '` + sectionPrefix + `lsb_release'; lsb_release -a 2>/dev/null`,
+	`true`,
+}, "\n")
+
+// gatherRelease gathers the operating system facts.
+//
+// os-release is used if present. Otherwise, /etc/redhat-release and then lsb_release are used.
+// If no release info is found, e.g. on a minimal container, the release facts are left empty.
+func (f *Facts) gatherRelease(ctx context.Context, m *Host) error {
+
+	r, err := m.RunCheck(ctx, releaseScript, "", "")
+	if err != nil {
+		return errors.Wrap(err, "getting release info errored")
+	}
+
+	sections := parseSections(r.Stdout)
+
+	release := parseOSRelease(strings.Join(sections["os-release"], "\n"))
+	if len(release) == 0 {
+		release = parseRedhatRelease(strings.Join(sections["redhat-release"], "\n"))
+	}
+	if len(release) == 0 {
+		release = parseLSBRelease(sections["lsb_release"])
+	}
+	if len(release) == 0 {
+		m.Log("no release info found")
+	}
+
+	f.release = release
+
+	for _, item := range []struct {
+		key  string
+		fact Fact
+	}{
+		{"ID", OS},
+		{"ID_LIKE", OSFamily},
+		{"VERSION_ID", OSFullVersion},
+		{"PRETTY_NAME", PrettyName},
+		{"VERSION_CODENAME", OSCodename},
+	} {
+		value, ok := release[item.key]
+		if ok && value != "" {
+			f.kv[item.fact] = value
+		}
+	}
+
+	for _, fact := range []Fact{OS, OSFamily} {
+		if v, ok := f.kv[fact]; ok {
+			f.kv[fact] = strings.ToLower(v)
+		}
+	}
+
+	// Ubuntu has its own codename key, older Debian only has it in VERSION, e.g. 8 (jessie)
+	if _, ok := f.kv[OSCodename]; !ok {
+		if v, ok := release["UBUNTU_CODENAME"]; ok && v != "" {
+			f.kv[OSCodename] = v
+		} else if match := versionCodename.FindStringSubmatch(release["VERSION"]); match != nil {
+			f.kv[OSCodename] = match[1]
+		}
+	}
+
+	// Ensure that debian also set os family debian
+	if v, ok := f.kv[OS]; ok && v == "debian" {
+		f.kv[OSFamily] = "debian"
+	}
+
+	// Ensure that fedora also set os family rhel
+	if v, ok := f.kv[OS]; ok && v == "fedora" {
+		f.kv[OSFamily] = "rhel"
+	}
+
+	// Set rhel consistently when fedora
+	if v, ok := f.kv[OSFamily]; ok && strings.Contains(v, "fedora") {
+		f.kv[OSFamily] = "rhel"
+	}
+
+	// Only return majorversion
+	if v, ok := f.kv[OSFullVersion]; ok {
+		f.kv[OSVersion] = majorVersion(v)
+	}
+
+	return nil
+}
+
+// versionCodename matches a single lowercase word in parentheses, e.g. the codename in "8 (jessie)"
+var versionCodename = regexp.MustCompile(`\(([a-z]+)\)`)
+
+// osReleaseKey matches a valid os-release key
+var osReleaseKey = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// parseOSRelease parses the contents of an os-release file.
+//
+// Values may be unquoted, or in single or double quotes. Backslash escapes are honored outside single quotes.
+// Comments, empty lines and lines without a valid key are ignored.
+func parseOSRelease(in string) map[string]string {
+	kv := map[string]string{}
+
+	for _, l := range strings.Split(in, "\n") {
+		l = strings.TrimSpace(l)
+
+		if l == "" || l[0] == '#' {
+			continue
+		}
+
+		parts := strings.SplitN(l, "=", 2)
+		if len(parts) != 2 || !osReleaseKey.MatchString(parts[0]) {
+			continue
+		}
+
+		kv[parts[0]] = unquote(parts[1])
+	}
+
+	return kv
+}
+
+// unquote removes shell-style quotes and escapes from s
+func unquote(s string) string {
+	var b strings.Builder
+	var quote rune
+	escaped := false
+
+	for _, c := range s {
+		switch {
+		case escaped:
+			b.WriteRune(c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped = true
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case c == quote:
+			quote = 0
+		default:
+			b.WriteRune(c)
+		}
+	}
+
+	return b.String()
+}
+
+// redhatRelease matches e.g. "CentOS Linux release 7.9.2009 (Core)"
+var redhatRelease = regexp.MustCompile(`^(.*?) release ([0-9.]+)`)
+
+// parseRedhatRelease parses the contents of /etc/redhat-release into os-release keys
+func parseRedhatRelease(in string) map[string]string {
+	in = strings.TrimSpace(in)
+
+	m := redhatRelease.FindStringSubmatch(in)
+	if m == nil {
+		return map[string]string{}
+	}
+
+	id := strings.ToLower(strings.Fields(m[1])[0])
+	if strings.HasPrefix(m[1], "Red Hat") {
+		id = "rhel"
+	}
+
+	return map[string]string{
+		"ID":          id,
+		"ID_LIKE":     "rhel",
+		"VERSION_ID":  m[2],
+		"PRETTY_NAME": in,
+	}
+}
+
+// parseLSBRelease parses the output of 'lsb_release -a' into os-release keys
+func parseLSBRelease(lines []string) map[string]string {
+	kv := map[string]string{}
+
+	for _, l := range lines {
+		parts := strings.SplitN(l, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		value := strings.TrimSpace(parts[1])
+		if value == "" || value == "n/a" {
+			continue
+		}
+
+		switch strings.TrimSpace(parts[0]) {
+		case "Distributor ID":
+			kv["ID"] = strings.ToLower(value)
+		case "Description":
+			kv["PRETTY_NAME"] = value
+		case "Release":
+			kv["VERSION_ID"] = value
+		case "Codename":
+			kv["VERSION_CODENAME"] = value
+		}
+	}
+
+	return kv
+}
+
+// OSRelease returns all keys and values found in os-release, e.g. NAME and VERSION.
+// When os-release is not present, the keys are derived from /etc/redhat-release or lsb_release.
+func (f *Facts) OSRelease() map[string]string {
+	return f.release
+}
+
+// PrettyName returns the pretty name of the operating system, e.g. "Debian GNU/Linux 10 (buster)". Empty if not found.
+func (f *Facts) PrettyName() string {
+	return f.kv[PrettyName]
+}
+
+// OSCodename returns the codename of the operating system release, e.g. buster or bionic. Empty if not found.
+func (f *Facts) OSCodename() string {
+	return f.kv[OSCodename]
+}
+
+// OSFullVersion returns the full version of the operating system, e.g. 18.04 or 7. Empty if not found.
+func (f *Facts) OSFullVersion() string {
+	return f.kv[OSFullVersion]
+}
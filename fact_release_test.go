@@ -0,0 +1,186 @@
+package gossh
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/krilor/gossh/testing/faketarget"
+)
+
+func TestParseOSRelease(t *testing.T) {
+
+	in := `# comment
+NAME="Debian GNU/Linux"
+VERSION_ID="10"
+VERSION='10 (buster)'
+VERSION_CODENAME=buster
+  ID=debian
+HOME_URL="https://www.debian.org/"
+ESCAPED="a \"quoted\" \$value\\"
+MIXED=one' 'two
+EMPTY=
+not a key=value
+`
+
+	expect := map[string]string{
+		"NAME":             "Debian GNU/Linux",
+		"VERSION_ID":       "10",
+		"VERSION":          "10 (buster)",
+		"VERSION_CODENAME": "buster",
+		"ID":               "debian",
+		"HOME_URL":         "https://www.debian.org/",
+		"ESCAPED":          `a "quoted" $value\`,
+		"MIXED":            "one two",
+		"EMPTY":            "",
+	}
+
+	got := parseOSRelease(in)
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("notequal:\n got %v\n expect %v", got, expect)
+	}
+}
+
+func TestParseRedhatRelease(t *testing.T) {
+
+	var tests = []struct {
+		in     string
+		expect map[string]string
+	}{
+		{"CentOS Linux release 7.9.2009 (Core)\n", map[string]string{
+			"ID":          "centos",
+			"ID_LIKE":     "rhel",
+			"VERSION_ID":  "7.9.2009",
+			"PRETTY_NAME": "CentOS Linux release 7.9.2009 (Core)",
+		}},
+		{"Red Hat Enterprise Linux Server release 6.10 (Santiago)", map[string]string{
+			"ID":          "rhel",
+			"ID_LIKE":     "rhel",
+			"VERSION_ID":  "6.10",
+			"PRETTY_NAME": "Red Hat Enterprise Linux Server release 6.10 (Santiago)",
+		}},
+		{"", map[string]string{}},
+	}
+
+	for _, test := range tests {
+		got := parseRedhatRelease(test.in)
+		if !reflect.DeepEqual(got, test.expect) {
+			t.Errorf("%s: got %v - expect %v", test.in, got, test.expect)
+		}
+	}
+}
+
+func TestParseLSBRelease(t *testing.T) {
+
+	in := []string{
+		"Distributor ID:\tUbuntu",
+		"Description:\tUbuntu 14.04.6 LTS",
+		"Release:\t14.04",
+		"Codename:\ttrusty",
+	}
+
+	expect := map[string]string{
+		"ID":               "ubuntu",
+		"PRETTY_NAME":      "Ubuntu 14.04.6 LTS",
+		"VERSION_ID":       "14.04",
+		"VERSION_CODENAME": "trusty",
+	}
+
+	got := parseLSBRelease(in)
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("notequal:\n got %v\n expect %v", got, expect)
+	}
+}
+
+func TestGatherRelease(t *testing.T) {
+
+	var tests = []struct {
+		name   string
+		out    string
+		expect map[Fact]string
+	}{
+		{"ubuntu", `==gossh:os-release
+NAME="Ubuntu"
+VERSION="18.04.4 LTS (Bionic Beaver)"
+ID=ubuntu
+ID_LIKE=debian
+PRETTY_NAME="Ubuntu 18.04.4 LTS"
+VERSION_ID="18.04"
+VERSION_CODENAME=bionic
+UBUNTU_CODENAME=bionic
+==gossh:redhat-release
+==gossh:lsb_release
+Distributor ID:	Ubuntu
+`, map[Fact]string{
+			OS:            "ubuntu",
+			OSFamily:      "debian",
+			OSVersion:     "18",
+			OSFullVersion: "18.04",
+			OSCodename:    "bionic",
+			PrettyName:    "Ubuntu 18.04.4 LTS",
+		}},
+		{"debian jessie", `==gossh:os-release
+PRETTY_NAME="Debian GNU/Linux 8 (jessie)"
+ID=debian
+VERSION_ID="8"
+VERSION="8 (jessie)"
+`, map[Fact]string{
+			OS:            "debian",
+			OSFamily:      "debian",
+			OSVersion:     "8",
+			OSFullVersion: "8",
+			OSCodename:    "jessie",
+			PrettyName:    "Debian GNU/Linux 8 (jessie)",
+		}},
+		{"centos 6", `==gossh:os-release
+==gossh:redhat-release
+CentOS release 6.10 (Final)
+==gossh:lsb_release
+`, map[Fact]string{
+			OS:            "centos",
+			OSFamily:      "rhel",
+			OSVersion:     "6",
+			OSFullVersion: "6.10",
+			PrettyName:    "CentOS release 6.10 (Final)",
+		}},
+		{"lsb", `==gossh:os-release
+==gossh:redhat-release
+==gossh:lsb_release
+Distributor ID:	Ubuntu
+Description:	Ubuntu 12.04 LTS
+Release:	12.04
+Codename:	precise
+`, map[Fact]string{
+			OS:            "ubuntu",
+			OSVersion:     "12",
+			OSFullVersion: "12.04",
+			OSCodename:    "precise",
+			PrettyName:    "Ubuntu 12.04 LTS",
+		}},
+		{"none", `==gossh:os-release
+==gossh:redhat-release
+==gossh:lsb_release
+`, map[Fact]string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			ft := faketarget.New(t, "gossh")
+			ft.Expect(releaseScript).Returns(test.out, "", 0)
+			h := New(ft)
+			h.Reporter = NopReporter{}
+
+			f := &Facts{kv: map[Fact]string{}}
+			err := f.gatherRelease(ctx, h)
+			if err != nil {
+				t.Fatalf("gather errored: %v", err)
+			}
+
+			if !reflect.DeepEqual(f.kv, test.expect) {
+				t.Errorf("notequal:\n got %v\n expect %v", f.kv, test.expect)
+			}
+		})
+	}
+}
@@ -11,11 +11,26 @@ func _() {
 	_ = x[OS-0]
 	_ = x[OSFamily-1]
 	_ = x[OSVersion-2]
+	_ = x[OSFullVersion-3]
+	_ = x[OSCodename-4]
+	_ = x[PrettyName-5]
+	_ = x[Hostname-6]
+	_ = x[FQDN-7]
+	_ = x[KernelRelease-8]
+	_ = x[Architecture-9]
+	_ = x[CPUCount-10]
+	_ = x[MemTotal-11]
+	_ = x[DefaultInterface-12]
+	_ = x[DefaultGateway-13]
+	_ = x[DefaultIPv4-14]
+	_ = x[InitSystem-15]
+	_ = x[PkgManager-16]
+	_ = x[Virtualization-17]
 }
 
-const _Fact_name = "OSOSFamilyOSVersion"
+const _Fact_name = "OSOSFamilyOSVersionOSFullVersionOSCodenamePrettyNameHostnameFQDNKernelReleaseArchitectureCPUCountMemTotalDefaultInterfaceDefaultGatewayDefaultIPv4InitSystemPkgManagerVirtualization"
 
-var _Fact_index = [...]uint8{0, 2, 10, 19}
+var _Fact_index = [...]uint8{0, 2, 10, 19, 32, 42, 52, 60, 64, 77, 89, 97, 105, 121, 135, 146, 156, 166, 180}
 
 func (i Fact) String() string {
 	if i < 0 || i >= Fact(len(_Fact_index)-1) {
@@ -0,0 +1,288 @@
+package gossh
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Interface is a network interface on a host
+type Interface struct {
+	Name string `json:"name"`
+	// IPv4 and IPv6 are the addresses of the interface, in CIDR notation
+	IPv4 []string `json:"ipv4,omitempty"`
+	IPv6 []string `json:"ipv6,omitempty"`
+}
+
+// Mount is a mounted filesystem on a host
+type Mount struct {
+	Device string `json:"device"`
+	FSType string `json:"fs_type"`
+	Path   string `json:"path"`
+	// Size and Free are in bytes
+	Size uint64 `json:"size"`
+	Free uint64 `json:"free"`
+}
+
+// sectionPrefix marks the start of a section in the output of systemScript
+const sectionPrefix = "==gossh:"
+
+// systemScript outputs all system facts in a single run. Each part of the output is preceded by a section marker.
+// All commands are allowed to fail, so that as much as possible is gathered on minimal systems.
+var systemScript = strings.Join([]string{
+	`echo '` + sectionPrefix + `hostname'; hostname 2>/dev/null || cat /etc/hostname 2>/dev/null`,
+	`echo '` + sectionPrefix + `fqdn'; hostname -f 2>/dev/null`,
+	`echo '` + sectionPrefix + `kernel'; uname -r 2>/dev/null`,
+	`echo '` + sectionPrefix + `arch'; uname -m 2>/dev/null`,
+	`echo '` + sectionPrefix + `cpus'; nproc 2>/dev/null || grep -c ^processor /proc/cpuinfo 2>/dev/null`,
+	`echo '` + sectionPrefix + `mem'; grep ^MemTotal: /proc/meminfo 2>/dev/null`,
+	`echo '` + sectionPrefix + `addr'; ip -o addr show 2>/dev/null`,
+	`echo '` + sectionPrefix + `route'; ip -4 route show default 2>/dev/null`,
+	`echo '` + sectionPrefix + `df'; df -P -k -T 2>/dev/null`,
+	`echo '` + sectionPrefix + `init'; cat /proc/1/comm 2>/dev/null; [ -d /run/systemd/system ] && echo systemd; [ -d /run/openrc ] && echo openrc`,
+	`echo '` + sectionPrefix + `pkg'; for p in apt-get dnf yum zypper apk pacman; do command -v $p >/dev/null 2>&1 && echo $p; done`,
+	`echo '` + sectionPrefix + `virt'; systemd-detect-virt 2>/dev/null; [ -f /.dockerenv ] && echo docker; [ -f /run/.containerenv ] && echo podman; grep -qaE 'docker|kubepods' /proc/1/cgroup 2>/dev/null && echo container; grep -qa hypervisor /proc/cpuinfo 2>/dev/null && echo vm`,
+	`true`,
+}, "\n")
+
+// gatherSystem gathers hardware, kernel, network, init system, package manager and virtualization facts
+func (f *Facts) gatherSystem(ctx context.Context, m *Host) error {
+
+	r, err := m.RunCheck(ctx, systemScript, "", "")
+	if err != nil {
+		return errors.Wrap(err, "getting system info errored")
+	}
+
+	sections := parseSections(r.Stdout)
+
+	first := func(name string) string {
+		if len(sections[name]) == 0 {
+			return ""
+		}
+		return sections[name][0]
+	}
+
+	for fact, value := range map[Fact]string{
+		Hostname:       first("hostname"),
+		FQDN:           first("fqdn"),
+		KernelRelease:  first("kernel"),
+		Architecture:   first("arch"),
+		CPUCount:       first("cpus"),
+		MemTotal:       parseMemTotal(first("mem")),
+		InitSystem:     parseInitSystem(sections["init"]),
+		PkgManager:     parsePkgManager(sections["pkg"]),
+		Virtualization: parseVirtualization(sections["virt"]),
+	} {
+		if value != "" {
+			f.kv[fact] = value
+		}
+	}
+
+	f.interfaces = parseIPAddr(sections["addr"])
+	f.mounts = parseDF(sections["df"])
+
+	gw, dev := parseDefaultRoute(sections["route"])
+	if gw != "" {
+		f.kv[DefaultGateway] = gw
+	}
+	if dev != "" {
+		f.kv[DefaultInterface] = dev
+		for _, i := range f.interfaces {
+			if i.Name == dev && len(i.IPv4) > 0 {
+				f.kv[DefaultIPv4] = strings.SplitN(i.IPv4[0], "/", 2)[0]
+			}
+		}
+	}
+
+	return nil
+}
+
+// parseSections splits the output of systemScript into its sections. Empty lines are omitted.
+func parseSections(in string) map[string][]string {
+	sections := map[string][]string{}
+	section := ""
+
+	for _, l := range strings.Split(in, "\n") {
+		l = strings.TrimSpace(l)
+		if strings.HasPrefix(l, sectionPrefix) {
+			section = strings.TrimPrefix(l, sectionPrefix)
+			sections[section] = []string{}
+			continue
+		}
+		if l == "" || section == "" {
+			continue
+		}
+		sections[section] = append(sections[section], l)
+	}
+
+	return sections
+}
+
+// parseMemTotal parses the MemTotal line of /proc/meminfo and returns the total memory in bytes
+func parseMemTotal(in string) string {
+	fields := strings.Fields(in)
+	if len(fields) < 2 {
+		return ""
+	}
+
+	kb, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return ""
+	}
+
+	return strconv.FormatUint(kb*1024, 10)
+}
+
+// parseInitSystem returns systemd, openrc or sysvinit based on the name of pid 1 and the presence of init system runtime dirs.
+// If pid 1 is something else, e.g. in a container, its name is returned.
+func parseInitSystem(lines []string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+
+	for _, l := range lines[1:] {
+		if l == "systemd" || l == "openrc" {
+			return l
+		}
+	}
+
+	switch lines[0] {
+	case "systemd":
+		return "systemd"
+	case "init":
+		return "sysvinit"
+	}
+
+	return lines[0]
+}
+
+// parsePkgManager returns the first package manager found. apt-get is returned as apt.
+func parsePkgManager(lines []string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+
+	if lines[0] == "apt-get" {
+		return "apt"
+	}
+
+	return lines[0]
+}
+
+// parseVirtualization returns the first detected virtualization or container technology, or none.
+func parseVirtualization(lines []string) string {
+	for _, l := range lines {
+		if l != "none" {
+			return l
+		}
+	}
+	return "none"
+}
+
+// parseIPAddr parses the output of 'ip -o addr show'
+func parseIPAddr(lines []string) []Interface {
+	interfaces := []Interface{}
+	index := map[string]int{}
+
+	for _, l := range lines {
+		// 2: eth0    inet 172.17.0.2/16 brd 172.17.255.255 scope global eth0\       valid_lft forever preferred_lft forever
+		fields := strings.Fields(l)
+		if len(fields) < 4 {
+			continue
+		}
+
+		name := strings.SplitN(fields[1], "@", 2)[0]
+		i, ok := index[name]
+		if !ok {
+			i = len(interfaces)
+			index[name] = i
+			interfaces = append(interfaces, Interface{Name: name})
+		}
+
+		switch fields[2] {
+		case "inet":
+			interfaces[i].IPv4 = append(interfaces[i].IPv4, fields[3])
+		case "inet6":
+			interfaces[i].IPv6 = append(interfaces[i].IPv6, fields[3])
+		}
+	}
+
+	return interfaces
+}
+
+// parseDefaultRoute parses the output of 'ip route show default' and returns the gateway and interface of the first default route
+func parseDefaultRoute(lines []string) (gateway string, dev string) {
+	if len(lines) == 0 {
+		return "", ""
+	}
+
+	// default via 172.17.0.1 dev eth0
+	fields := strings.Fields(lines[0])
+	for i := 0; i < len(fields)-1; i++ {
+		switch fields[i] {
+		case "via":
+			gateway = fields[i+1]
+		case "dev":
+			dev = fields[i+1]
+		}
+	}
+
+	return gateway, dev
+}
+
+// parseDF parses the output of 'df -P -k -T'
+func parseDF(lines []string) []Mount {
+	mounts := []Mount{}
+
+	for _, l := range lines {
+		// Filesystem     Type  1024-blocks     Used Available Capacity Mounted on
+		// /dev/sda1      ext4     98309408 41212036  52058508      45% /
+		fields := strings.Fields(l)
+		if len(fields) < 7 || fields[0] == "Filesystem" {
+			continue
+		}
+
+		size, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		free, err := strconv.ParseUint(fields[4], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		mounts = append(mounts, Mount{
+			Device: fields[0],
+			FSType: fields[1],
+			Path:   strings.Join(fields[6:], " "),
+			Size:   size * 1024,
+			Free:   free * 1024,
+		})
+	}
+
+	return mounts
+}
+
+// Interfaces returns the network interfaces of the host
+func (f *Facts) Interfaces() []Interface {
+	return f.interfaces
+}
+
+// Mounts returns the mounted filesystems of the host
+func (f *Facts) Mounts() []Mount {
+	return f.mounts
+}
+
+// CPUCount returns the number of CPUs, or 0 if not found
+func (f *Facts) CPUCount() int {
+	n, _ := strconv.Atoi(f.kv[CPUCount])
+	return n
+}
+
+// MemTotal returns the total memory in bytes, or 0 if not found
+func (f *Facts) MemTotal() uint64 {
+	n, _ := strconv.ParseUint(f.kv[MemTotal], 10, 64)
+	return n
+}
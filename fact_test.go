@@ -1,12 +1,17 @@
 package gossh
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/krilor/gossh/testing/faketarget"
 )
 
 func TestMain(m *testing.M) {
@@ -14,6 +19,12 @@ func TestMain(m *testing.M) {
 	os.Exit(m.Run())
 }
 
+// expectFacts registers the commands run when gathering facts on ft. The release script outputs release.
+func expectFacts(ft *faketarget.Target, release string) {
+	ft.Expect(releaseScript).Returns(release, "", 0)
+	ft.Expect(systemScript)
+}
+
 func TestParseINI(t *testing.T) {
 
 	var tests = []struct {
@@ -141,3 +152,275 @@ func TestGather(t *testing.T) {
 	}
 
 }*/
+
+func TestHostFacts(t *testing.T) {
+
+	ctx := context.Background()
+
+	ft := faketarget.New(t, "gossh")
+	ft.Expect(releaseScript).Returns("==gossh:os-release\nID=centos\nID_LIKE=\"rhel fedora\"\nVERSION_ID=\"7\"\n", "", 0).Times(1)
+	ft.Expect(releaseScript).Returns("==gossh:os-release\nID=centos\nID_LIKE=\"rhel fedora\"\nVERSION_ID=\"8\"\n", "", 0)
+	ft.Expect(systemScript)
+	h := New(ft)
+	h.Reporter = NopReporter{}
+
+	// facts are gathered once, even with concurrent callers
+	wg := sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f, err := h.Facts(ctx)
+			if err != nil {
+				t.Errorf("facts errored: %v", err)
+				return
+			}
+			if f.OS() != "centos" || f.OSFamily() != "rhel" || f.OSVersion() != "7" || !f.Gathered() {
+				t.Errorf("wrong facts: %s %s %s", f.OS(), f.OSFamily(), f.OSVersion())
+			}
+		}()
+	}
+	wg.Wait()
+
+	// release info and system info
+	if len(ft.Cmds()) != 2 {
+		t.Errorf("gathered %d times - expect 1", len(ft.Cmds())/2)
+	}
+
+	before, _ := h.Facts(ctx)
+
+	after, err := h.RefreshFacts(ctx)
+	if err != nil {
+		t.Fatalf("refresh errored: %v", err)
+	}
+
+	if v, ok := after.Get(OSVersion); !ok || v != "8" {
+		t.Errorf("refreshed version: got %s - expect 8", v)
+	}
+
+	if before.OSVersion() != "7" {
+		t.Errorf("previous facts modified: got %s - expect 7", before.OSVersion())
+	}
+
+	if f, _ := h.Facts(ctx); f != after {
+		t.Errorf("refreshed facts not cached")
+	}
+
+	if len(ft.Cmds()) != 4 {
+		t.Errorf("gathered %d times - expect 2", len(ft.Cmds())/2)
+	}
+}
+
+// systemOutput is sample output of systemScript on a docker container
+const systemOutput = `==gossh:hostname
+web1
+==gossh:fqdn
+web1.example.com
+==gossh:kernel
+5.4.0-42-generic
+==gossh:arch
+x86_64
+==gossh:cpus
+4
+==gossh:mem
+MemTotal:        8040164 kB
+==gossh:addr
+1: lo    inet 127.0.0.1/8 scope host lo\       valid_lft forever preferred_lft forever
+1: lo    inet6 ::1/128 scope host \       valid_lft forever preferred_lft forever
+42: eth0@if43    inet 172.17.0.2/16 brd 172.17.255.255 scope global eth0\       valid_lft forever preferred_lft forever
+==gossh:route
+default via 172.17.0.1 dev eth0
+==gossh:df
+Filesystem     Type    1024-blocks     Used Available Capacity Mounted on
+overlay        overlay    98309408 41212036  52058508      45% /
+/dev/sda1      ext4       98309408 41212036  52058508      45% /mnt/my data
+==gossh:init
+bash
+==gossh:pkg
+apt-get
+==gossh:virt
+none
+docker
+container
+`
+
+func TestGatherSystem(t *testing.T) {
+
+	ctx := context.Background()
+
+	ft := faketarget.New(t, "gossh")
+	ft.Expect(systemScript).Returns(systemOutput, "", 0)
+	h := New(ft)
+	h.Reporter = NopReporter{}
+
+	f := &Facts{kv: map[Fact]string{}}
+	err := f.gatherSystem(ctx, h)
+	if err != nil {
+		t.Fatalf("gather errored: %v", err)
+	}
+
+	expect := map[Fact]string{
+		Hostname:         "web1",
+		FQDN:             "web1.example.com",
+		KernelRelease:    "5.4.0-42-generic",
+		Architecture:     "x86_64",
+		CPUCount:         "4",
+		MemTotal:         "8233127936",
+		DefaultInterface: "eth0",
+		DefaultGateway:   "172.17.0.1",
+		DefaultIPv4:      "172.17.0.2",
+		InitSystem:       "bash",
+		PkgManager:       "apt",
+		Virtualization:   "docker",
+	}
+
+	if !reflect.DeepEqual(f.kv, expect) {
+		t.Errorf("wrong facts:\n got %v\n expect %v", f.kv, expect)
+	}
+
+	if f.CPUCount() != 4 || f.MemTotal() != 8233127936 {
+		t.Errorf("wrong typed facts: %d cpus %d bytes", f.CPUCount(), f.MemTotal())
+	}
+
+	interfaces := []Interface{
+		{Name: "lo", IPv4: []string{"127.0.0.1/8"}, IPv6: []string{"::1/128"}},
+		{Name: "eth0", IPv4: []string{"172.17.0.2/16"}},
+	}
+	if !reflect.DeepEqual(f.Interfaces(), interfaces) {
+		t.Errorf("wrong interfaces:\n got %v\n expect %v", f.Interfaces(), interfaces)
+	}
+
+	mounts := []Mount{
+		{Device: "overlay", FSType: "overlay", Path: "/", Size: 98309408 * 1024, Free: 52058508 * 1024},
+		{Device: "/dev/sda1", FSType: "ext4", Path: "/mnt/my data", Size: 98309408 * 1024, Free: 52058508 * 1024},
+	}
+	if !reflect.DeepEqual(f.Mounts(), mounts) {
+		t.Errorf("wrong mounts:\n got %v\n expect %v", f.Mounts(), mounts)
+	}
+}
+
+func TestParseInitSystem(t *testing.T) {
+
+	var tests = []struct {
+		in     []string
+		expect string
+	}{
+		{[]string{"systemd", "systemd"}, "systemd"},
+		{[]string{"init", "openrc"}, "openrc"},
+		{[]string{"init"}, "sysvinit"},
+		{[]string{"tini"}, "tini"},
+		{[]string{}, ""},
+	}
+
+	for _, test := range tests {
+		got := parseInitSystem(test.in)
+		if got != test.expect {
+			t.Errorf("%v: got %s - expect %s", test.in, got, test.expect)
+		}
+	}
+}
+
+func TestParseVirtualization(t *testing.T) {
+
+	var tests = []struct {
+		in     []string
+		expect string
+	}{
+		{[]string{"none"}, "none"},
+		{[]string{"kvm", "vm"}, "kvm"},
+		{[]string{"none", "docker", "container"}, "docker"},
+		{[]string{}, "none"},
+	}
+
+	for _, test := range tests {
+		got := parseVirtualization(test.in)
+		if got != test.expect {
+			t.Errorf("%v: got %s - expect %s", test.in, got, test.expect)
+		}
+	}
+}
+
+func TestHostFactsReentrant(t *testing.T) {
+
+	ctx := context.Background()
+
+	ft := faketarget.New(t, "gossh")
+	expectFacts(ft, "==gossh:os-release\nID=centos\nID_LIKE=\"rhel fedora\"\nVERSION_ID=\"7\"\n")
+	h := New(ft)
+	h.Reporter = NopReporter{}
+	i := &Inventory{}
+	i.Add(h)
+
+	// a provider that uses the facts and host vars of the host it provides facts for
+	h.AddFactProvider("reentrant", FactProviderFunc(func(ctx context.Context, h *Host) (map[string]string, error) {
+		f, err := h.Facts(ctx)
+		if err != nil {
+			return nil, err
+		}
+		h.Inventory().HostVars()
+		return map[string]string{"os": f.OS()}, nil
+	}))
+
+	done := make(chan struct{})
+	var f *Facts
+	var err error
+	go func() {
+		f, err = h.Facts(ctx)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("facts deadlocked")
+	}
+
+	if err != nil {
+		t.Fatalf("facts errored: %v", err)
+	}
+
+	if v, ok := f.Custom("os"); !ok || v != "centos" {
+		t.Errorf("custom fact: got %s - expect centos", v)
+	}
+}
+
+func TestHostFactsWait(t *testing.T) {
+
+	ft := faketarget.New(t, "gossh")
+	expectFacts(ft, "==gossh:os-release\nID=centos\n")
+	h := New(ft)
+	h.Reporter = NopReporter{}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	h.AddFactProvider("slow", FactProviderFunc(func(ctx context.Context, h *Host) (map[string]string, error) {
+		close(started)
+		<-release
+		return nil, nil
+	}))
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := h.Facts(context.Background())
+		done <- err
+	}()
+	<-started
+
+	// a waiter gives up when its ctx is done, while the gathering continues
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := h.Facts(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("waiter: got %v - expect %v", err, context.DeadlineExceeded)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Errorf("gathering errored: %v", err)
+	}
+
+	if h.cachedFacts() == nil {
+		t.Errorf("facts not cached")
+	}
+}
@@ -9,4 +9,5 @@ require (
 	github.com/pkg/sftp v1.11.0
 	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
 	golang.org/x/tools v0.0.0-20200925191224-5d1fdd8fa346 // indirect
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -1,6 +1,11 @@
 package gossh
 
-import "github.com/krilor/gossh/target"
+import (
+	"context"
+
+	"github.com/krilor/gossh/target"
+	"github.com/pkg/errors"
+)
 
 // Package gossh provides interfaces and functionality for declarative IT automation on target vms or containers.
 
@@ -46,7 +51,7 @@ type SuperTarget interface {
 
 	// Apply checks and ensures that the Target adheres to Rule r.
 	// String name should be unique within the immediate context, short and descriptive.
-	Apply(name string, r Rule) (Status, error)
+	Apply(ctx context.Context, name string, r Rule) (Status, error)
 
 	// AllowChange reports if the target allows changes to be done or not.
 	// False will be returned if target is in check-only mode.
@@ -65,7 +70,7 @@ type SuperTarget interface {
 	// Empty user means connected user. '-' is interpreted as 'root'.
 	//
 	// Stdin can be used to add stdin to the commmand.
-	RunChange(cmd string, stdin string, user string) (Response, error)
+	RunChange(ctx context.Context, cmd string, stdin string, user string) (Response, error)
 
 	// RunCheck runs the command cmd on the Target.
 	//
@@ -75,18 +80,24 @@ type SuperTarget interface {
 	// Empty user means connected user. '-' is interpreted as 'root'.
 	//
 	// Stdin can be used to add stdin to the commmand.
-	RunCheck(cmd string, stdin string, user string) (Response, error)
+	RunCheck(ctx context.Context, cmd string, stdin string, user string) (Response, error)
 }
 
 // BlockedByValidate is an ExitCode used to indicate that the command was not run, but rather blocked because the target does not allow any modifications.
 // The intended use for this is when validating rules.
 const BlockedByValidate int = 81549300
 
+// ErrChangeBlocked is returned, wrapped, from Host.Put when the host does not allow any modifications, i.e. in check-only mode.
+// It is the Put equivalent of BlockedByValidate. Rules should handle it, e.g. by returning StatusNotSatisfied.
+var ErrChangeBlocked = errors.New("change blocked")
+
 // Rule is an interface that wraps the Ensure method
 //
 // Ensure runs commands on Target t to check and enforce that a declared state is adhered to.
 //
 // If anything goes wrong, error err is returned. Otherwise err is nil.
+//
+// Ctx should be passed on to all commands and rules applied within Ensure, so that deadlines and cancellation is honored.
 type Rule interface {
-	Ensure(h *Host) (status Status, err error)
+	Ensure(ctx context.Context, h *Host) (status Status, err error)
 }
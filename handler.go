@@ -0,0 +1,110 @@
+package gossh
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// handlers holds the handlers of a host and the ones that are notified
+type handlers struct {
+	mu       sync.Mutex
+	order    []string
+	rules    map[string]Rule
+	notified map[string]bool
+}
+
+// newHandlers returns an empty set of handlers
+func newHandlers() *handlers {
+	return &handlers{
+		rules:    map[string]Rule{},
+		notified: map[string]bool{},
+	}
+}
+
+// pending returns the notified handlers, in the order they were added, and clears the notifications
+func (hs *handlers) pending() []string {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	names := []string{}
+	for _, name := range hs.order {
+		if hs.notified[name] {
+			names = append(names, name)
+		}
+	}
+
+	hs.notified = map[string]bool{}
+
+	return names
+}
+
+// AddHandler adds Rule r as a handler on h, named name.
+//
+// Handlers are only applied when notified, and then only once, no matter how many times they were notified.
+// If a handler with the same name exists, it is replaced.
+func (h *Host) AddHandler(name string, r Rule) {
+	h.handlers.mu.Lock()
+	defer h.handlers.mu.Unlock()
+
+	if _, ok := h.handlers.rules[name]; !ok {
+		h.handlers.order = append(h.handlers.order, name)
+	}
+	h.handlers.rules[name] = r
+}
+
+// Notify notifies the handler named name, so that it will be applied at the next flush.
+// Rules should notify handlers when they are enforced.
+//
+// Notified handlers are flushed when the top-level Apply is done, or explicitly with FlushHandlers.
+// If the top-level Apply fails, the notified handlers are dropped without being applied.
+// An error is returned if there is no handler named name.
+func (h *Host) Notify(name string) error {
+	h.handlers.mu.Lock()
+	defer h.handlers.mu.Unlock()
+
+	if _, ok := h.handlers.rules[name]; !ok {
+		return errors.Errorf("no handler named %s", name)
+	}
+
+	h.handlers.notified[name] = true
+	h.Log("notified", "handler", name)
+
+	return nil
+}
+
+// FlushHandlers applies all notified handlers, in the order they were added.
+// Handlers notified by other handlers during the flush are also applied, but no handler is applied more than once.
+//
+// The handlers are applied as children of the rule currently being applied, if any.
+// The returned status is the highest status of the handlers. All handlers are applied, even if some fail. The first error is returned.
+func (h *Host) FlushHandlers(ctx context.Context) (Status, error) {
+
+	var status Status
+	var first error
+	applied := map[string]bool{}
+
+	for names := h.handlers.pending(); len(names) > 0; names = h.handlers.pending() {
+		for _, name := range names {
+			if applied[name] {
+				continue
+			}
+			applied[name] = true
+
+			h.handlers.mu.Lock()
+			r := h.handlers.rules[name]
+			h.handlers.mu.Unlock()
+
+			s, err := h.Apply(ctx, "handler: "+name, r)
+			if s > status {
+				status = s
+			}
+			if err != nil && first == nil {
+				first = errors.Wrapf(err, "handler %s failed", name)
+			}
+		}
+	}
+
+	return status, first
+}
@@ -0,0 +1,151 @@
+package gossh
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/krilor/gossh/testing/faketarget"
+)
+
+func TestHandlers(t *testing.T) {
+
+	ctx := context.Background()
+
+	// notify returns a rule that notifies handler and returns s
+	notify := func(handler string, s Status) Rule {
+		return ruleFunc(func(ctx context.Context, h *Host) (Status, error) {
+			if s == StatusEnforced {
+				if err := h.Notify(handler); err != nil {
+					return StatusFailed, err
+				}
+			}
+			return s, nil
+		})
+	}
+
+	// fail is a rule that fails
+	fail := ruleFunc(func(ctx context.Context, h *Host) (Status, error) {
+		return StatusFailed, fmt.Errorf("failed")
+	})
+
+	// nested applies all rules within a single rule
+	nested := func(rules ...Rule) Rule {
+		return ruleFunc(func(ctx context.Context, h *Host) (Status, error) {
+			var status Status
+			for i, r := range rules {
+				s, err := h.Apply(ctx, fmt.Sprintf("rule%d", i), r)
+				if err != nil {
+					return StatusFailed, err
+				}
+				if s > status {
+					status = s
+				}
+			}
+			return status, nil
+		})
+	}
+
+	var tests = []struct {
+		name     string
+		rule     Rule
+		expect   Status
+		err      bool
+		restarts int
+		reloads  int
+	}{
+		{"not notified", nested(notify("restart", StatusSatisfied)), StatusSatisfied, false, 0, 0},
+		{"notified once", nested(notify("restart", StatusEnforced)), StatusEnforced, false, 1, 0},
+		{"notified twice", nested(notify("restart", StatusEnforced), notify("restart", StatusEnforced)), StatusEnforced, false, 1, 0},
+		{"two handlers", nested(notify("reload", StatusEnforced), notify("restart", StatusEnforced)), StatusEnforced, false, 1, 1},
+		{"flush point", nested(notify("restart", StatusEnforced), ruleFunc(func(ctx context.Context, h *Host) (Status, error) {
+			return h.FlushHandlers(ctx)
+		}), notify("restart", StatusEnforced)), StatusEnforced, false, 2, 0},
+		{"unknown handler", nested(notify("unknown", StatusEnforced)), StatusFailed, true, 0, 0},
+		{"notified before failure", nested(notify("restart", StatusEnforced), fail), StatusFailed, true, 0, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ft := faketarget.New(t, "gossh")
+			ft.Expect("restart")
+			ft.Expect("reload")
+			h := New(ft)
+			h.Reporter = NopReporter{}
+
+			h.AddHandler("restart", ruleFunc(func(ctx context.Context, h *Host) (Status, error) {
+				h.RunChange(ctx, "restart", "", "")
+				return StatusEnforced, nil
+			}))
+
+			h.AddHandler("reload", ruleFunc(func(ctx context.Context, h *Host) (Status, error) {
+				h.RunChange(ctx, "reload", "", "")
+				return StatusEnforced, nil
+			}))
+
+			s, err := h.Apply(ctx, test.name, test.rule)
+
+			if s != test.expect {
+				t.Errorf("status: got %v - expect %v", s, test.expect)
+			}
+
+			if (err != nil) != test.err {
+				t.Errorf("error: got %v - expect error %v", err, test.err)
+			}
+
+			cmds := ft.Cmds()
+			restarts, reloads := 0, 0
+			for _, cmd := range cmds {
+				switch cmd {
+				case "restart":
+					restarts++
+				case "reload":
+					reloads++
+				}
+			}
+
+			if restarts != test.restarts || reloads != test.reloads {
+				t.Errorf("handlers: got %d restarts and %d reloads - expect %d and %d", restarts, reloads, test.restarts, test.reloads)
+			}
+
+			// restart is added first, and should allways run first
+			if test.restarts > 0 && test.reloads > 0 && cmds[0] != "restart" {
+				t.Errorf("handler order: got %v", cmds)
+			}
+
+			// handlers must not run again
+			h.Apply(ctx, "again", nested())
+			if len(ft.Cmds()) != restarts+reloads {
+				t.Errorf("handlers ran again: %v", ft.Cmds())
+			}
+		})
+	}
+}
+
+func TestHandlerResults(t *testing.T) {
+
+	ctx := context.Background()
+	h := New(faketarget.New(t, "gossh"))
+	h.Reporter = NopReporter{}
+
+	h.AddHandler("restart", ruleFunc(func(ctx context.Context, h *Host) (Status, error) {
+		return StatusEnforced, nil
+	}))
+
+	h.Apply(ctx, "config", ruleFunc(func(ctx context.Context, h *Host) (Status, error) {
+		return StatusSatisfied, h.Notify("restart")
+	}))
+
+	res := h.Results()
+	if len(res) != 1 || len(res[0].Children) != 1 {
+		t.Fatalf("results: got %v", res)
+	}
+
+	if res[0].Status != StatusEnforced {
+		t.Errorf("status: got %v - expect %v", res[0].Status, StatusEnforced)
+	}
+
+	if c := res[0].Children[0]; c.Name != "handler: restart" || c.Status != StatusEnforced {
+		t.Errorf("handler result: got %s %v", c.Name, c.Status)
+	}
+}
@@ -2,51 +2,101 @@ package gossh
 
 import (
 	"bytes"
+	"context"
 	"fmt"
-	"log"
+	"os"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/krilor/gossh/target"
 	"github.com/krilor/gossh/target/local"
 	"github.com/krilor/gossh/target/rmt"
+	"github.com/krilor/gossh/target/rmt/sshconfig"
 	"github.com/pkg/errors"
 	"golang.org/x/crypto/ssh"
 )
 
 // Host is where we do all the things
+//
+// A Host is safe for concurrent use, e.g. by rules applied in parallel, as long as its Reporter is.
 type Host struct {
 	t target.Target
-	// AllowChange controls if it is allowed to do any changes on the host
+	// AllowChange controls if it is allowed to do any changes on the host.
+	// When false, the host is in check-only mode: RunChange and Put are blocked and nothing is modified on the target.
 	AllowChange bool
+
+	// result is the result of the rule currently being applied. It is nil when no rule is applied.
+	result *Result
+	// results holds the results of all top-level Apply's on the host. It is shared by all views of the host.
+	results *results
+	// handlers holds the handlers of the host. It is shared by all views of the host.
+	handlers *handlers
+	// facts holds the gathered facts of the host. It is shared by all views of the host.
+	facts *hostFacts
+	// gathering is the facts being gathered, when h is the view given to fact providers. Facts returns them.
+	gathering *Facts
+	// FactCache is an optional on-disk cache of facts. Facts found there are used instead of gathering them.
+	FactCache *FactCache
+	// providers holds the fact providers of the host. It is shared by all views of the host.
+	providers *factProviders
+	// vars holds the variables of the host. It is shared by all views of the host.
+	vars *vars
+	// inventory is the inventory the host is in, if any
+	inventory *Inventory
+
+	// Reporter receives all events on the host, e.g. applies, commands and file access.
+	// It defaults to a TreeReporter on stdout, shared by all hosts. Set it to NopReporter{} to silence the host.
+	Reporter Reporter
+
+	// trace is the trace of the rule currently being applied
+	trace trace
 }
 
 // New returns a host based on a target
 func New(target target.Target) *Host {
-	return &Host{t: target}
+	return &Host{
+		t:           target,
+		AllowChange: true,
+		results:     &results{},
+		handlers:    newHandlers(),
+		facts:       &hostFacts{},
+		providers:   newFactProviders(),
+		vars:        newVars(),
+		trace:       newTrace(),
+		Reporter:    stdoutReporter,
+	}
 }
 
+// stdoutReporter is the default Reporter of hosts. It is shared, so that lines from hosts applied concurrently are not mixed.
+var stdoutReporter Reporter = NewTreeReporter(os.Stdout)
+
 // NewLocalHost returns a new host that is pointing to localhost
 func NewLocalHost(sudopass string) (*Host, error) {
-
-	h := Host{}
-	var err error
-
-	h.t, err = local.New(sudopass)
-
-	return &h, err
+	t, err := local.New(sudopass)
+	return New(t), err
 }
 
 // NewRemoteHost returns a new remote host
 func NewRemoteHost(addr string, user string, sudopass string, hostkeycallback ssh.HostKeyCallback, auths ...ssh.AuthMethod) (*Host, error) {
+	t, err := rmt.New(addr, user, sudopass, hostkeycallback, auths...)
+	return New(t), err
+}
 
-	h := Host{}
-	var err error
-
-	h.t, err = rmt.New(addr, user, sudopass, hostkeycallback, auths...)
+// NewSSHConfigHost returns a Host connected to over SSH, with the connection settings of alias in ~/.ssh/config, see rmt.NewFromSSHConfig.
+// Auths are used after the IdentityFiles in the config.
+func NewSSHConfigHost(alias string, sudopass string, hostkeycallback ssh.HostKeyCallback, auths ...ssh.AuthMethod) (*Host, error) {
+	c, err := sshconfig.Default()
+	if err != nil {
+		return nil, err
+	}
 
-	return &h, err
+	t, err := rmt.NewFromSSHConfig(c, alias, sudopass, hostkeycallback, auths...)
+	if err != nil {
+		return nil, err
+	}
 
+	return New(t), nil
 }
 
 // String implements io.Stringer for a Host
@@ -59,28 +109,84 @@ func (h *Host) isReady() bool {
 	return h.t != nil
 }
 
-// Log is logging
+// Log reports msg as an EventLog to the hosts Reporter
 func (h *Host) Log(msg string, keyAndValues ...string) {
-	log.Println(h.String(), msg, keyAndValues)
+	h.report(Event{
+		Kind:         EventLog,
+		Msg:          msg,
+		KeyAndValues: keyAndValues,
+	})
+}
+
+// report fills in the host and trace details of e and reports it to h.Reporter
+func (h *Host) report(e Event) {
+	if h.Reporter == nil {
+		return
+	}
+
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	e.Host = h.String()
+	e.ID = h.trace.id
+	e.Parent = h.trace.prev
+	e.Level = h.trace.level
+
+	if h.result != nil {
+		e.Name = h.result.Name
+		e.Rule = h.result.Rule
+	}
+
+	h.Reporter.Report(e)
 }
 
 // RunChange are used to run cmd's that RunChanges the state on m
-func (h *Host) RunChange(cmd string, stdin string, user string) (Response, error) {
-	return h.run(cmd, stdin, user)
+//
+// If changes are not allowed on h, cmd is not run and a Response with ExitStatus BlockedByValidate is returned.
+//
+// If ctx is done before cmd completes, cmd is killed and an error wrapping ctx.Err() is returned.
+func (h *Host) RunChange(ctx context.Context, cmd string, stdin string, user string) (Response, error) {
+	h.report(Event{Kind: EventEnforceStart, Cmd: cmd, User: user})
+
+	if !h.AllowChange {
+		h.report(Event{Kind: EventEnforceEnd, Cmd: cmd, User: user, ExitStatus: BlockedByValidate, Blocked: true})
+		return Response{ExitStatus: BlockedByValidate}, nil
+	}
+
+	start := time.Now()
+	r, err := h.run(ctx, cmd, stdin, user)
+	h.report(Event{Kind: EventEnforceEnd, Cmd: cmd, User: user, ExitStatus: r.ExitStatus, Err: err, Duration: time.Since(start)})
+
+	return r, err
 }
 
 // RunCheck are used to run cmd's that does not modify anything on m
-func (h *Host) RunCheck(cmd string, stdin string, user string) (Response, error) {
-	return h.run(cmd, stdin, user)
+//
+// If ctx is done before cmd completes, cmd is killed and an error wrapping ctx.Err() is returned.
+func (h *Host) RunCheck(ctx context.Context, cmd string, stdin string, user string) (Response, error) {
+	start := time.Now()
+	r, err := h.run(ctx, cmd, stdin, user)
+	h.report(Event{Kind: EventCheck, Cmd: cmd, User: user, ExitStatus: r.ExitStatus, Err: err, Duration: time.Since(start)})
+
+	return r, err
 }
 
-// Run runs cmd on host, as sudo or not, and returns the response
-func (h *Host) run(cmd string, stdin string, user string) (Response, error) {
-	if user != "" {
-		h.t.As(user)
+// as returns the target of h with user as the active user.
+// Empty user means connected user. '-' is interpreted as 'root'.
+func (h *Host) as(user string) target.Target {
+	switch user {
+	case "":
+		return h.t
+	case "-":
+		return h.t.As("root")
 	}
+	return h.t.As(user)
+}
 
-	r, err := h.t.Run(cmd, bytes.NewBufferString(stdin))
+// Run runs cmd on host, as sudo or not, and returns the response
+func (h *Host) run(ctx context.Context, cmd string, stdin string, user string) (Response, error) {
+	r, err := h.as(user).Run(ctx, cmd, bytes.NewBufferString(stdin))
 
 	res := Response{
 		Stderr:     r.Stderr.String(),
@@ -94,6 +200,31 @@ func (h *Host) run(cmd string, stdin string, user string) (Response, error) {
 	return res, nil
 }
 
+// Put creates or truncates the file filename on h with data and perm, as user.
+//
+// If changes are not allowed on h, nothing is written and an error wrapping ErrChangeBlocked is returned.
+func (h *Host) Put(ctx context.Context, filename string, data []byte, perm os.FileMode, user string) error {
+	if !h.AllowChange {
+		h.report(Event{Kind: EventPut, Path: filename, User: user, Blocked: true})
+		return errors.Wrapf(ErrChangeBlocked, "put %s", filename)
+	}
+
+	start := time.Now()
+	err := h.as(user).Put(ctx, filename, data, perm)
+	h.report(Event{Kind: EventPut, Path: filename, User: user, Err: err, Duration: time.Since(start)})
+
+	return err
+}
+
+// Get reads the file filename on h, as user.
+func (h *Host) Get(ctx context.Context, filename string, user string) ([]byte, error) {
+	start := time.Now()
+	b, err := h.as(user).Get(ctx, filename)
+	h.report(Event{Kind: EventGet, Path: filename, User: user, Err: err, Duration: time.Since(start)})
+
+	return b, err
+}
+
 // nSpaces is a little utility to get n spaces and lines
 func nSpaces(n int) string {
 	b := strings.Builder{}
@@ -111,26 +242,88 @@ func nSpaces(n int) string {
 
 // Apply applies Rule r on m, i.e. runs Check and conditionally runs Ensure
 // Id must be unique string. //TODO - how to explain this
-func (h *Host) Apply(name string, r Rule) (Status, error) {
+//
+// The Result of the Apply is added to the result tree of h. Rules applied from within r are added as children.
+//
+// When a top-level Apply is successful, i.e. not an Apply from within another rule, all notified handlers are flushed.
+// The handlers are added as children, and their status and errors are included in the returned status and error.
+// When a top-level Apply fails, the notified handlers are dropped, so that they are not flushed by a later Apply.
+//
+// Ctx is passed on to r. If ctx is allready done, r is not ensured and StatusFailed is returned with an error wrapping ctx.Err().
+func (h *Host) Apply(ctx context.Context, name string, r Rule) (Status, error) {
 	// TODO - maybe use ... on r to allow specification of multiple rules at once
+	res := h.apply(ctx, name, r)
+	return res.Status, res.Err
+}
+
+// apply does the work of Apply, and returns the Result
+func (h *Host) apply(ctx context.Context, name string, r Rule) *Result {
+
+	res := &Result{
+		Name:  name,
+		Rule:  fmt.Sprintf("%T", r),
+		Start: time.Now(),
+	}
+
+	if h.result != nil {
+		h.result.add(res)
+	} else {
+		h.results.add(res)
+	}
 
-	h.Log("apply", "name", name)
+	// r is ensured on a view of h, so that rules applied within r end up as children of res
+	child := *h
+	child.result = res
+	child.trace = h.trace.span()
 
-	h.Log("apply", "start")
-	defer h.Log("apply", "end")
+	child.report(Event{Kind: EventApplyStart, Time: res.Start})
 
-	h.Log("ensure", "start")
+	var status Status
 	var err error
-	_, err = r.Ensure(h)
-	h.Log("ensure", "end")
+
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	} else {
+		status, err = r.Ensure(ctx, &child)
+	}
 
 	if err != nil {
-		fmt.Printf("%s└ %s\n", name, "CHANGED")
-		return StatusFailed, errors.Wrapf(err, "could not ensure rule %v on host %v", r, h)
+		status = StatusFailed
+		err = errors.Wrapf(err, "could not ensure rule %v on host %v", r, h)
+	}
+
+	if h.result == nil && err == nil {
+		s, herr := child.FlushHandlers(ctx)
+		if s > status {
+			status = s
+		}
+		if herr != nil {
+			status = StatusFailed
+			err = herr
+		}
+	} else if h.result == nil {
+		for _, name := range child.handlers.pending() {
+			child.Log("dropped", "handler", name)
+		}
+	}
+
+	// In check-only mode, nothing can have been enforced. Rules that does not handle blocked responses will still report StatusEnforced.
+	if !h.AllowChange && status == StatusEnforced {
+		status = StatusNotSatisfied
 	}
 
-	fmt.Printf("%s└ %s\n", name, "OK")
-	return 1, nil
+	res.Status = status
+	res.Err = err
+	res.End = time.Now()
+
+	child.report(Event{Kind: EventApplyEnd, Time: res.End, Status: res.Status, Err: res.Err, Duration: res.Duration()})
+
+	return res
+}
+
+// Results returns the results of all top-level rules applied on h, in the order they were applied.
+func (h *Host) Results() []*Result {
+	return h.results.get()
 }
 
 // sudopattern matches sudo prompt
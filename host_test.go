@@ -1,10 +1,16 @@
 package gossh
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/user"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/krilor/gossh/testing/faketarget"
+	"github.com/pkg/errors"
 )
 
 var sudopass string
@@ -144,3 +150,271 @@ func TestLocal(t *testing.T) {
 	}
 }
 */
+
+func TestRunChangeBlocked(t *testing.T) {
+
+	ctx := context.Background()
+
+	var tests = []struct {
+		allowChange bool
+		expectCmds  int
+		expectExit  int
+	}{
+		{true, 1, 0},
+		{false, 0, BlockedByValidate},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%v", test.allowChange), func(t *testing.T) {
+			ft := faketarget.New(t, "gossh")
+			ft.Expect("touch /tmp/file")
+			h := New(ft)
+			h.AllowChange = test.allowChange
+
+			r, err := h.RunChange(ctx, "touch /tmp/file", "", "")
+			if err != nil {
+				t.Fatalf("runchange errored: %v", err)
+			}
+
+			if r.ExitStatus != test.expectExit {
+				t.Errorf("exitstatus: got %d - expect %d", r.ExitStatus, test.expectExit)
+			}
+
+			if len(ft.Cmds()) != test.expectCmds {
+				t.Errorf("cmds run: got %d - expect %d", len(ft.Cmds()), test.expectCmds)
+			}
+
+			err = h.Put(ctx, "/tmp/file", []byte("content"), 0644, "")
+			if test.allowChange && err != nil {
+				t.Fatalf("put errored: %v", err)
+			}
+			if !test.allowChange && errors.Cause(err) != ErrChangeBlocked {
+				t.Errorf("put: got %v - expect ErrChangeBlocked", err)
+			}
+
+			_, written := ft.File("/tmp/file")
+			if written != test.allowChange {
+				t.Errorf("file written: got %v - expect %v", written, test.allowChange)
+			}
+		})
+	}
+}
+
+func TestApplyCheckOnly(t *testing.T) {
+
+	ctx := context.Background()
+
+	enforcer := ruleFunc(func(ctx context.Context, h *Host) (Status, error) {
+		r, err := h.RunCheck(ctx, "false", "", "")
+		if err != nil || r.ExitStatus == 0 {
+			return StatusSatisfied, err
+		}
+		_, err = h.RunChange(ctx, "true", "", "")
+		return StatusEnforced, err
+	})
+
+	var tests = []struct {
+		allowChange bool
+		expect      Status
+	}{
+		{true, StatusEnforced},
+		{false, StatusNotSatisfied},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%v", test.allowChange), func(t *testing.T) {
+			ft := faketarget.New(t, "gossh")
+			ft.Expect("false").Returns("", "", 1)
+			ft.Expect("true")
+			h := New(ft)
+			h.AllowChange = test.allowChange
+
+			got, err := h.Apply(ctx, "enforcer", enforcer)
+			if err != nil {
+				t.Fatalf("apply errored: %v", err)
+			}
+
+			if got != test.expect {
+				t.Errorf("status: got %v - expect %v", got, test.expect)
+			}
+		})
+	}
+}
+
+func TestApplyResults(t *testing.T) {
+
+	ctx := context.Background()
+
+	satisfied := ruleFunc(func(ctx context.Context, h *Host) (Status, error) {
+		return StatusSatisfied, nil
+	})
+
+	enforced := ruleFunc(func(ctx context.Context, h *Host) (Status, error) {
+		return StatusEnforced, nil
+	})
+
+	failed := ruleFunc(func(ctx context.Context, h *Host) (Status, error) {
+		return StatusUndefined, fmt.Errorf("failed")
+	})
+
+	nested := ruleFunc(func(ctx context.Context, h *Host) (Status, error) {
+		var status Status
+		for i, r := range []Rule{satisfied, enforced} {
+			s, err := h.Apply(ctx, fmt.Sprintf("child%d", i), r)
+			if err != nil {
+				return StatusFailed, err
+			}
+			if s > status {
+				status = s
+			}
+		}
+		return status, nil
+	})
+
+	h := New(faketarget.New(t, "gossh"))
+
+	s, err := h.Apply(ctx, "nested", nested)
+	if err != nil || s != StatusEnforced {
+		t.Errorf("nested: got %v, %v - expect %v, nil", s, err, StatusEnforced)
+	}
+
+	s, err = h.Apply(ctx, "failed", failed)
+	if err == nil || s != StatusFailed {
+		t.Errorf("failed: got %v, %v - expect %v, error", s, err, StatusFailed)
+	}
+
+	results := h.Results()
+	if len(results) != 2 {
+		t.Fatalf("results: got %d - expect 2", len(results))
+	}
+
+	if results[0].Name != "nested" || results[0].Rule != "gossh.ruleFunc" || results[0].Status != StatusEnforced {
+		t.Errorf("nested result: got %s %s %v", results[0].Name, results[0].Rule, results[0].Status)
+	}
+
+	if len(results[0].Children) != 2 {
+		t.Fatalf("nested children: got %d - expect 2", len(results[0].Children))
+	}
+
+	for i, expect := range []Status{StatusSatisfied, StatusEnforced} {
+		c := results[0].Children[i]
+		if c.Name != fmt.Sprintf("child%d", i) || c.Status != expect {
+			t.Errorf("child%d: got %s %v - expect %v", i, c.Name, c.Status, expect)
+		}
+		if c.Start.Before(results[0].Start) || c.End.After(results[0].End) {
+			t.Errorf("child%d: not within parent timespan", i)
+		}
+	}
+
+	if results[1].Status != StatusFailed || results[1].Err == nil {
+		t.Errorf("failed result: got %v %v", results[1].Status, results[1].Err)
+	}
+}
+
+func TestApplyContext(t *testing.T) {
+
+	blocking := ruleFunc(func(ctx context.Context, h *Host) (Status, error) {
+		_, err := h.RunCheck(ctx, "block", "", "")
+		if err != nil {
+			return StatusFailed, err
+		}
+		return StatusSatisfied, nil
+	})
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	var tests = []struct {
+		name   string
+		ctx    context.Context
+		cmds   int
+		expect error
+	}{
+		{"cancelled", cancelled, 0, context.Canceled},
+		{"timeout", timeout, 1, context.DeadlineExceeded},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ft := faketarget.New(t, "gossh")
+			ft.Expect("block").Blocks()
+			h := New(ft)
+
+			s, err := h.Apply(test.ctx, "blocking", blocking)
+
+			if s != StatusFailed {
+				t.Errorf("status: got %v - expect %v", s, StatusFailed)
+			}
+
+			if !errors.Is(err, test.expect) {
+				t.Errorf("error: got %v - expect %v", err, test.expect)
+			}
+
+			if len(ft.Cmds()) != test.cmds {
+				t.Errorf("cmds: got %d - expect %d", len(ft.Cmds()), test.cmds)
+			}
+		})
+	}
+}
+
+func TestRunUser(t *testing.T) {
+
+	ctx := context.Background()
+	ft := faketarget.New(t, "gossh")
+	ft.ExpectRegexp(`^(as-[a-z]+|after)$`)
+	h := New(ft)
+	h.Reporter = NopReporter{}
+
+	var tests = []struct {
+		cmd    string
+		user   string
+		expect string
+	}{
+		{"as-root", "root", "root"},
+		{"as-connected", "", "gossh"},
+		{"as-dash", "-", "root"},
+		{"as-other", "other", "other"},
+	}
+
+	// commands are run concurrently, to ensure that users does not leak between them
+	wg := sync.WaitGroup{}
+	for _, test := range tests {
+		wg.Add(1)
+		go func(cmd, user string) {
+			defer wg.Done()
+			h.RunCheck(ctx, cmd, "", user)
+		}(test.cmd, test.user)
+	}
+	wg.Wait()
+
+	h.RunCheck(ctx, "after", "", "")
+
+	users := map[string]string{}
+	for _, c := range ft.Calls() {
+		users[c.Cmd] = c.User
+	}
+
+	for _, test := range tests {
+		if got := users[test.cmd]; got != test.expect {
+			t.Errorf("%s: got user %s - expect %s", test.cmd, got, test.expect)
+		}
+	}
+
+	if got := users["after"]; got != "gossh" {
+		t.Errorf("after: got user %s - expect gossh", got)
+	}
+
+	if ft.ActiveUser() != "gossh" {
+		t.Errorf("target active user modified: %s", ft.ActiveUser())
+	}
+}
+
+// ruleFunc is a Rule implemented by a plain function
+type ruleFunc func(ctx context.Context, h *Host) (Status, error)
+
+func (f ruleFunc) Ensure(ctx context.Context, h *Host) (Status, error) {
+	return f(ctx, h)
+}
@@ -0,0 +1,80 @@
+package gossh
+
+import (
+	"context"
+	"sync"
+)
+
+// HostVars are the facts and variables of a host in an Inventory
+type HostVars struct {
+	Host *Host
+	// Facts are the facts of the host. Nil if they are not gathered yet.
+	Facts *Facts
+	// Vars are the variables of the host
+	Vars map[string]interface{}
+}
+
+// Inventory returns the inventory h is in, giving rules access to the facts and variables of other hosts.
+// Nil if h is not in an inventory.
+func (h *Host) Inventory() *Inventory {
+	return h.inventory
+}
+
+// cachedFacts returns the facts of h if they are gathered, or nil
+func (h *Host) cachedFacts() *Facts {
+	h.facts.mu.Lock()
+	defer h.facts.mu.Unlock()
+
+	return h.facts.facts
+}
+
+// HostVars returns the facts and variables of all hosts in i, in the order of the hosts.
+// Hosts with the same name, as given by Host.String(), have an entry each.
+//
+// Facts are not gathered, so use GatherFacts first to make sure that the facts of all hosts are available.
+func (i *Inventory) HostVars() []HostVars {
+	hv := []HostVars{}
+
+	for _, h := range i.Hosts() {
+		hv = append(hv, HostVars{
+			Host:  h,
+			Facts: h.cachedFacts(),
+			Vars:  h.Vars(),
+		})
+	}
+
+	return hv
+}
+
+// GatherFacts gathers the facts of all hosts in i, concurrently on up to opts.Forks hosts at a time.
+// Facts already gathered, or found in the hosts FactCache, are not gathered again.
+//
+// The errors of the hosts where gathering failed are returned, keyed by host. It is empty if all hosts succeeded.
+func (i *Inventory) GatherFacts(ctx context.Context, opts ApplyOptions) map[*Host]error {
+
+	errs := map[*Host]error{}
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	sem := make(chan struct{}, opts.forks())
+
+	for _, h := range i.Hosts() {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(h *Host) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			_, err := h.Facts(ctx)
+			if err != nil {
+				mu.Lock()
+				defer mu.Unlock()
+				errs[h] = err
+			}
+		}(h)
+	}
+
+	wg.Wait()
+
+	return errs
+}
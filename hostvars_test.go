@@ -0,0 +1,91 @@
+package gossh
+
+import (
+	"context"
+	"testing"
+
+	"github.com/krilor/gossh/target/sh"
+	"github.com/krilor/gossh/testing/faketarget"
+	"github.com/pkg/errors"
+)
+
+func TestHostVars(t *testing.T) {
+
+	ctx := context.Background()
+
+	i := newTestInventory(t, 3)
+	for n, h := range i.Hosts() {
+		h.SetVar("role", "db")
+		if n == 2 {
+			// the release info can not be read, so gathering fails
+			ft := faketarget.NewHost(t, "gossh", "host2")
+			ft.Expect(releaseScript).Do(func(cmd string, stdin string) (sh.Result, error) {
+				return sh.Result{ExitStatus: -1}, errors.New("connection lost")
+			})
+			h.t = ft
+			continue
+		}
+		expectFacts(h.t.(*faketarget.Target), "==gossh:os-release\nID=debian\n")
+	}
+
+	hosts := i.Hosts()
+
+	errs := i.GatherFacts(ctx, ApplyOptions{Forks: 2})
+	if len(errs) != 1 || errs[hosts[2]] == nil {
+		t.Errorf("wrong errors: %v", errs)
+	}
+
+	// a rule on one host sees the facts and vars of all hosts
+	var hv []HostVars
+	_, err := i.Host("gossh@host0").Apply(ctx, "hostvars", ruleFunc(func(ctx context.Context, h *Host) (Status, error) {
+		hv = h.Inventory().HostVars()
+		return StatusSatisfied, nil
+	}))
+	if err != nil {
+		t.Fatalf("apply errored: %v", err)
+	}
+
+	if len(hv) != 3 {
+		t.Fatalf("got %d hosts - expect 3", len(hv))
+	}
+
+	for n, expectFacts := range []bool{true, true, false} {
+		if hv[n].Host != hosts[n] {
+			t.Errorf("%d: got host %v - expect %v", n, hv[n].Host, hosts[n])
+		}
+		if (hv[n].Facts != nil) != expectFacts {
+			t.Errorf("%s: got facts %v - expect facts %t", hv[n].Host, hv[n].Facts, expectFacts)
+		}
+		if hv[n].Vars["role"] != "db" {
+			t.Errorf("%s: got role %v - expect db", hv[n].Host, hv[n].Vars["role"])
+		}
+	}
+
+	if hv[1].Facts.OS() != "debian" {
+		t.Errorf("wrong os: got %s - expect debian", hv[1].Facts.OS())
+	}
+
+	if New(faketarget.New(t, "gossh")).Inventory() != nil {
+		t.Errorf("host not in inventory has inventory")
+	}
+}
+
+func TestHostVarsSameName(t *testing.T) {
+	i := &Inventory{}
+	for _, role := range []string{"web", "db"} {
+		h := New(faketarget.New(t, "gossh"))
+		h.SetVar("role", role)
+		i.Add(h)
+	}
+
+	hv := i.HostVars()
+	if len(hv) != 2 {
+		t.Fatalf("got %d hosts - expect 2", len(hv))
+	}
+
+	for n, role := range []string{"web", "db"} {
+		if hv[n].Vars["role"] != role {
+			t.Errorf("%d: got role %v - expect %s", n, hv[n].Vars["role"], role)
+		}
+	}
+}
@@ -1,11 +1,184 @@
 package gossh
 
-// Inventory is a list of Hosts
-type Inventory []*Host
+import (
+	"context"
+	"sync"
+)
 
-// Add adds m to i
+// Inventory is a list of Hosts, optionally organized in groups
+//
+// The zero value is an empty inventory ready to use. An Inventory is safe for concurrent use.
+type Inventory struct {
+	mu    sync.RWMutex
+	hosts []*Host
+	// groups are keyed by name
+	groups map[string]*group
+	// vars are the inventory, group and extra variables. Nil until a variable is set.
+	vars *inventoryVars
+}
+
+// NewInventory returns an inventory of hosts
+func NewInventory(hosts ...*Host) *Inventory {
+	i := &Inventory{}
+	for _, h := range hosts {
+		i.Add(h)
+	}
+	return i
+}
+
+// Add adds m to i.
+//
+// Rules applied on m can access the facts and variables of all hosts in i through m.Inventory.
+// A host can only be in one inventory at a time, so hosts should be added before rules are applied.
+// Adding a host that is already in i does nothing.
 func (i *Inventory) Add(m *Host) {
-	l := append(*i, m)
-	*i = l
-	return
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.add(m)
+}
+
+// add adds m to i, if it is not already in i. i.mu must be held by the caller.
+func (i *Inventory) add(m *Host) {
+	for _, h := range i.hosts {
+		if h == m {
+			return
+		}
+	}
+
+	m.inventory = i
+	i.hosts = append(i.hosts, m)
+}
+
+// Hosts returns the hosts in i, in the order they were added
+func (i *Inventory) Hosts() []*Host {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	return append([]*Host{}, i.hosts...)
+}
+
+// Host returns the host in i named name, as given by Host.String(). Nil if not found.
+func (i *Inventory) Host(name string) *Host {
+	for _, h := range i.Hosts() {
+		if h.String() == name {
+			return h
+		}
+	}
+	return nil
+}
+
+// Len returns the number of hosts in i
+func (i *Inventory) Len() int {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	return len(i.hosts)
+}
+
+// AddHandler adds Rule r as a handler named name on all hosts in i.
+// See Host.AddHandler.
+func (i *Inventory) AddHandler(name string, r Rule) {
+	for _, h := range i.Hosts() {
+		h.AddHandler(name, r)
+	}
+}
+
+// DefaultForks is the number of hosts rules are applied to concurrently, if not specified in ApplyOptions
+const DefaultForks int = 5
+
+// ApplyOptions controls how a rule is applied to the hosts in an Inventory
+type ApplyOptions struct {
+	// Forks is the maximum number of hosts the rule is applied to concurrently.
+	// If zero, DefaultForks is used.
+	Forks int
+}
+
+// forks returns the number of forks to use
+func (o ApplyOptions) forks() int {
+	if o.Forks < 1 {
+		return DefaultForks
+	}
+	return o.Forks
+}
+
+// HostResult is the result of applying a rule to a single host in an Inventory
+type HostResult struct {
+	Host   *Host
+	Status Status
+	Err    error
+	// Result is the result tree of the apply on the host
+	Result *Result
+	// NotRun is true if the rule was never applied to the host, e.g. because a Rollout was stopped before reaching it.
+	// The Status is then StatusSkipped, but the host does not count as OK.
+	NotRun bool
+}
+
+// OK reports if the rule was applied to the host with an OK status
+func (hr HostResult) OK() bool {
+	return !hr.NotRun && hr.Status.OK()
+}
+
+// InventoryResult holds the result for each host in an Inventory, in the order of the hosts.
+// Hosts with the same name, as given by Host.String(), have a result each.
+type InventoryResult []HostResult
+
+// OK reports if the status of all hosts are OK. Hosts the rule was not run on are not OK.
+func (r InventoryResult) OK() bool {
+	for _, hr := range r {
+		if !hr.OK() {
+			return false
+		}
+	}
+	return true
+}
+
+// Failed returns the results of all hosts where the status is not OK or the rule was not run, in the order of the hosts
+func (r InventoryResult) Failed() []HostResult {
+	failed := []HostResult{}
+	for _, hr := range r {
+		if !hr.OK() {
+			failed = append(failed, hr)
+		}
+	}
+	return failed
+}
+
+// Apply applies Rule r to all hosts in i, concurrently on up to opts.Forks hosts at a time.
+//
+// A failing host does not stop the rule from being applied to other hosts. If ctx is done, the remaining hosts will fail with an error wrapping ctx.Err().
+func (i *Inventory) Apply(ctx context.Context, name string, r Rule, opts ApplyOptions) InventoryResult {
+	return apply(ctx, i.Hosts(), name, r, opts)
+}
+
+// apply applies Rule r to hosts, as by Inventory.Apply
+func apply(ctx context.Context, hosts []*Host, name string, r Rule, opts ApplyOptions) InventoryResult {
+
+	res := make(InventoryResult, len(hosts))
+	wg := sync.WaitGroup{}
+	sem := make(chan struct{}, opts.forks())
+
+	for n, h := range hosts {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		// each host has its own element in res, so no locking is needed
+		go func(n int, h *Host) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			result := h.apply(ctx, name, r)
+
+			res[n] = HostResult{
+				Host:   h,
+				Status: result.Status,
+				Err:    result.Err,
+				Result: result,
+			}
+		}(n, h)
+	}
+
+	wg.Wait()
+
+	return res
 }
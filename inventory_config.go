@@ -0,0 +1,493 @@
+package gossh
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/krilor/gossh/target"
+	"github.com/krilor/gossh/target/lazy"
+	"github.com/krilor/gossh/target/local"
+	"github.com/krilor/gossh/target/rmt"
+	"github.com/krilor/gossh/target/rmt/sshconfig"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// InventoryConfig describes the hosts and groups of an inventory, e.g. as loaded from a file.
+// Use Inventory to connect to the hosts.
+type InventoryConfig struct {
+	// Hosts are the hosts, in order
+	Hosts []HostConfig
+	// Groups are the groups, keyed by name
+	Groups map[string]GroupConfig
+	// Vars are the variables of all hosts
+	Vars map[string]interface{}
+}
+
+// HostConfig describes how to connect to a host, and its groups and variables.
+//
+// Connection settings that are not set are taken from the variables of the host, including the variables of its groups,
+// using the Ansible names ansible_host, ansible_port, ansible_user, ansible_password, ansible_become_password, ansible_ssh_private_key_file and ansible_connection.
+type HostConfig struct {
+	// Name is the name of the host in the inventory
+	Name string `json:"-" yaml:"-"`
+	// Address is the hostname or IP address to connect to. Defaults to Name.
+	Address string `json:"address,omitempty" yaml:"address,omitempty"`
+	// Port is the SSH port. Defaults to 22.
+	Port int `json:"port,omitempty" yaml:"port,omitempty"`
+	// User is the user to connect as. Defaults to the current user.
+	User string `json:"user,omitempty" yaml:"user,omitempty"`
+	// Auth are references to the auth methods to use, in order:
+	//
+	//	password   - the Password of the host
+	//	agent      - the keys in the ssh agent at SSH_AUTH_SOCK
+	//	key:<path> - the unencrypted private key in the file at path
+	//
+	// Defaults to the IdentityFiles in SSHConfig, password if Password is set, and agent if SSH_AUTH_SOCK is set.
+	Auth []string `json:"auth,omitempty" yaml:"auth,omitempty"`
+	// Password is the password of User. See ResolveSecret for the supported sources.
+	Password string `json:"password,omitempty" yaml:"password,omitempty"`
+	// SudoPassword is the sudo password of User. See ResolveSecret for the supported sources. Defaults to Password.
+	SudoPassword string `json:"sudo_password,omitempty" yaml:"sudo_password,omitempty"`
+	// Connection is ssh or local. Defaults to ssh.
+	Connection string `json:"connection,omitempty" yaml:"connection,omitempty"`
+	// SSHConfig is the path to an OpenSSH client config, e.g. ~/.ssh/config, that the host is looked up in by Name.
+	// Address, Port and User defaults to the HostName, Port and User in it, and ProxyJump is followed. See SSHConfigFile.
+	SSHConfig string `json:"ssh_config,omitempty" yaml:"ssh_config,omitempty"`
+	// Groups are the names of the groups the host is a direct member of
+	Groups []string `json:"groups,omitempty" yaml:"groups,omitempty"`
+	// Vars are the variables of the host
+	Vars map[string]interface{} `json:"vars,omitempty" yaml:"vars,omitempty"`
+}
+
+// GroupConfig describes a group of hosts
+type GroupConfig struct {
+	// Hosts are the names of the hosts that are direct members of the group
+	Hosts []string `json:"hosts,omitempty" yaml:"hosts,omitempty"`
+	// Children are the names of the child groups
+	Children []string `json:"children,omitempty" yaml:"children,omitempty"`
+	// Vars are the variables of the hosts in the group
+	Vars map[string]interface{} `json:"vars,omitempty" yaml:"vars,omitempty"`
+}
+
+// host returns a pointer to the host named name in c, adding it if it does not exist
+func (c *InventoryConfig) host(name string) *HostConfig {
+	for i := range c.Hosts {
+		if c.Hosts[i].Name == name {
+			return &c.Hosts[i]
+		}
+	}
+
+	c.Hosts = append(c.Hosts, HostConfig{Name: name})
+	return &c.Hosts[len(c.Hosts)-1]
+}
+
+// groupsOf returns the names of the groups h is a member of, directly or through child groups, ordered as by orderGroups
+func (c *InventoryConfig) groupsOf(h HostConfig) []string {
+	parents := map[string][]string{}
+	for name, g := range c.Groups {
+		for _, child := range g.Children {
+			parents[child] = append(parents[child], name)
+		}
+	}
+
+	direct := append([]string{}, h.Groups...)
+	for name, g := range c.Groups {
+		for _, m := range g.Hosts {
+			if m == h.Name {
+				direct = append(direct, name)
+			}
+		}
+	}
+
+	return orderGroups(direct, parents)
+}
+
+// hostVars returns the variables of h, resolved like Host.Vars.
+//
+// The variables of a group named AllGroup are variables of all hosts.
+func (c *InventoryConfig) hostVars(h HostConfig) map[string]interface{} {
+	kv := mergeVars(c.Groups[AllGroup].Vars, c.Vars)
+
+	for _, name := range c.groupsOf(h) {
+		kv = mergeVars(kv, c.Groups[name].Vars)
+	}
+
+	return mergeVars(kv, h.Vars)
+}
+
+// withVars returns h with connection settings that are not set taken from the Ansible connection variables in kv
+func (h HostConfig) withVars(kv map[string]interface{}) (HostConfig, error) {
+	str := func(key string, field *string) {
+		if v, ok := kv[key]; ok && *field == "" {
+			*field = fmt.Sprint(v)
+		}
+	}
+
+	str("ansible_host", &h.Address)
+	str("ansible_user", &h.User)
+	str("ansible_password", &h.Password)
+	str("ansible_become_password", &h.SudoPassword)
+	str("ansible_become_pass", &h.SudoPassword)
+	str("ansible_connection", &h.Connection)
+
+	if v, ok := kv["ansible_port"]; ok && h.Port == 0 {
+		port, err := strconv.Atoi(fmt.Sprint(v))
+		if err != nil {
+			return h, errors.Wrapf(err, "invalid ansible_port of %s", h.Name)
+		}
+		h.Port = port
+	}
+
+	if v, ok := kv["ansible_ssh_private_key_file"]; ok && len(h.Auth) == 0 {
+		h.Auth = []string{"key:" + fmt.Sprint(v)}
+	}
+
+	return h, nil
+}
+
+// Inventory returns an inventory with the hosts and groups in c.
+//
+// Hosts are connected to on first use, so connection errors are returned when rules are applied, not from Inventory.
+// Host keys are checked using hostkeycallback.
+// The variables of c, its groups and hosts are set as inventory, group and host variables, see Host.Vars.
+func (c *InventoryConfig) Inventory(hostkeycallback ssh.HostKeyCallback) (*Inventory, error) {
+	i := NewInventory()
+
+	hosts := map[string]*Host{}
+
+	for _, hc := range c.Hosts {
+		kv := c.hostVars(hc)
+
+		hc, err := hc.withVars(kv)
+		if err != nil {
+			return nil, err
+		}
+
+		t, err := hc.target(hostkeycallback)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid host %s", hc.Name)
+		}
+
+		h := New(t)
+		for k, v := range hc.Vars {
+			h.SetVar(k, v)
+		}
+
+		i.Add(h)
+		hosts[hc.Name] = h
+	}
+
+	groups := []string{}
+	for name := range c.Groups {
+		groups = append(groups, name)
+	}
+	sort.Strings(groups)
+
+	for k, v := range mergeVars(c.Groups[AllGroup].Vars, c.Vars) {
+		i.SetVar(k, v)
+	}
+
+	for _, name := range groups {
+		if name == AllGroup {
+			continue
+		}
+
+		g := c.Groups[name]
+		for k, v := range g.Vars {
+			i.SetGroupVar(name, k, v)
+		}
+		i.AddChildren(name, g.Children...)
+		for _, m := range g.Hosts {
+			h, ok := hosts[m]
+			if !ok {
+				return nil, fmt.Errorf("unknown host %s in group %s", m, name)
+			}
+			i.AddToGroup(name, h)
+		}
+	}
+
+	for _, hc := range c.Hosts {
+		for _, name := range hc.Groups {
+			if name != AllGroup {
+				i.AddToGroup(name, hosts[hc.Name])
+			}
+		}
+	}
+
+	return i, nil
+}
+
+// Merge merges o into c. Hosts and groups with the same name are merged.
+//
+// Host settings and variables in o takes precedence over the ones in c, and maps in variables are merged. Group members and children are combined.
+func (c *InventoryConfig) Merge(o *InventoryConfig) {
+	if c.Groups == nil {
+		c.Groups = map[string]GroupConfig{}
+	}
+	c.Vars = mergeVars(c.Vars, o.Vars)
+
+	for _, oh := range o.Hosts {
+		h := c.host(oh.Name)
+
+		if oh.Address != "" {
+			h.Address = oh.Address
+		}
+		if oh.Port != 0 {
+			h.Port = oh.Port
+		}
+		if oh.User != "" {
+			h.User = oh.User
+		}
+		if len(oh.Auth) > 0 {
+			h.Auth = oh.Auth
+		}
+		if oh.Password != "" {
+			h.Password = oh.Password
+		}
+		if oh.SudoPassword != "" {
+			h.SudoPassword = oh.SudoPassword
+		}
+		if oh.Connection != "" {
+			h.Connection = oh.Connection
+		}
+		if oh.SSHConfig != "" {
+			h.SSHConfig = oh.SSHConfig
+		}
+
+		h.Groups = union(h.Groups, oh.Groups)
+		h.Vars = mergeVars(h.Vars, oh.Vars)
+	}
+
+	for name, og := range o.Groups {
+		g := c.Groups[name]
+		g.Hosts = union(g.Hosts, og.Hosts)
+		g.Children = union(g.Children, og.Children)
+		g.Vars = mergeVars(g.Vars, og.Vars)
+		c.Groups[name] = g
+	}
+}
+
+// union returns the elements of a followed by the elements of b that are not in a
+func union(a []string, b []string) []string {
+	out := append([]string{}, a...)
+
+outer:
+	for _, s := range b {
+		for _, existing := range out {
+			if s == existing {
+				continue outer
+			}
+		}
+		out = append(out, s)
+	}
+
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+// target returns a lazily connected target for h
+func (h HostConfig) target(hostkeycallback ssh.HostKeyCallback) (target.Target, error) {
+	switch h.Connection {
+	case "local":
+		u, err := currentUser()
+		if err != nil {
+			return nil, err
+		}
+		return lazy.New(h.Name, u, func(ctx context.Context) (target.Target, error) {
+			sudopass, err := h.sudoPassword()
+			if err != nil {
+				return nil, err
+			}
+			return local.New(sudopass)
+		}), nil
+	case "", "ssh":
+	default:
+		return nil, fmt.Errorf("unknown connection %s", h.Connection)
+	}
+
+	for _, a := range h.Auth {
+		if a != "password" && a != "agent" && !strings.HasPrefix(a, "key:") {
+			return nil, fmt.Errorf("unknown auth method %s", a)
+		}
+	}
+
+	address := h.Address
+	port := h.Port
+	u := h.User
+
+	// settings from the ssh config, if any
+	var sshc *sshconfig.Config
+	var identities []string
+
+	if h.SSHConfig != "" {
+		var err error
+		sshc, err = sshconfig.Load(sshconfig.ExpandHome(h.SSHConfig))
+		if err != nil {
+			return nil, err
+		}
+
+		s, err := sshc.Get(h.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		if address == "" {
+			address = s.HostName
+		}
+		if port == 0 {
+			port = s.Port
+		}
+		if u == "" {
+			u = s.User
+		}
+		identities = s.IdentityFiles
+	}
+
+	if u == "" {
+		var err error
+		u, err = currentUser()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if address == "" {
+		address = h.Name
+	}
+
+	if port == 0 {
+		port = 22
+	}
+
+	addr := net.JoinHostPort(address, strconv.Itoa(port))
+
+	return lazy.New(h.Name, u, func(ctx context.Context) (target.Target, error) {
+		auths, err := h.auths(rmt.IdentityAuths(identities...)...)
+		if err != nil {
+			return nil, err
+		}
+
+		sudopass, err := h.sudoPassword()
+		if err != nil {
+			return nil, err
+		}
+
+		if sshc == nil {
+			return rmt.NewContext(ctx, addr, u, sudopass, hostkeycallback, auths...)
+		}
+
+		// the password of the host is not sent to the jump hosts
+		jumpauths := []ssh.AuthMethod{}
+		if os.Getenv("SSH_AUTH_SOCK") != "" {
+			jumpauths = append(jumpauths, rmt.AgentAuths())
+		}
+
+		jumps, err := rmt.SSHConfigJumps(sshc, h.Name, jumpauths...)
+		if err != nil {
+			return nil, err
+		}
+
+		return rmt.NewViaContext(ctx, jumps, addr, u, sudopass, hostkeycallback, auths...)
+	}), nil
+}
+
+// auths returns the ssh auth methods of h. If h.Auth is empty, keys are used first.
+func (h HostConfig) auths(keys ...ssh.AuthMethod) ([]ssh.AuthMethod, error) {
+	auths := []ssh.AuthMethod{}
+
+	refs := h.Auth
+	if len(refs) == 0 {
+		auths = append(auths, keys...)
+		if h.Password != "" {
+			refs = append(refs, "password")
+		}
+		if os.Getenv("SSH_AUTH_SOCK") != "" {
+			refs = append(refs, "agent")
+		}
+	}
+
+	if len(refs) == 0 && len(auths) == 0 {
+		return nil, errors.New("no auth methods")
+	}
+
+	for _, ref := range refs {
+		switch {
+		case ref == "password":
+			pwd, err := ResolveSecret(h.Password)
+			if err != nil {
+				return nil, errors.Wrap(err, "could not get password")
+			}
+			auths = append(auths, ssh.Password(pwd))
+		case ref == "agent":
+			auths = append(auths, rmt.AgentAuths())
+		case strings.HasPrefix(ref, "key:"):
+			a, err := rmt.KeyFileAuth(sshconfig.ExpandHome(strings.TrimPrefix(ref, "key:")))
+			if err != nil {
+				return nil, err
+			}
+			auths = append(auths, a)
+		}
+	}
+
+	return auths, nil
+}
+
+// sudoPassword returns the resolved sudo password of h
+func (h HostConfig) sudoPassword() (string, error) {
+	src := h.SudoPassword
+	if src == "" {
+		src = h.Password
+	}
+
+	pwd, err := ResolveSecret(src)
+	if err != nil {
+		return "", errors.Wrap(err, "could not get sudo password")
+	}
+
+	return pwd, nil
+}
+
+// ResolveSecret returns the secret referenced by src, so that secrets does not have to be stored in inventory files:
+//
+//	env:<name>  - the value of the environment variable name
+//	file:<path> - the contents of the file at path, without trailing newlines
+//
+// Any other src is returned as is.
+func ResolveSecret(src string) (string, error) {
+	switch {
+	case strings.HasPrefix(src, "env:"):
+		name := strings.TrimPrefix(src, "env:")
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return v, nil
+	case strings.HasPrefix(src, "file:"):
+		b, err := ioutil.ReadFile(sshconfig.ExpandHome(strings.TrimPrefix(src, "file:")))
+		if err != nil {
+			return "", errors.Wrap(err, "could not read secret")
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	}
+
+	return src, nil
+}
+
+// currentUser returns the username of the current user
+func currentUser() (string, error) {
+	u, err := user.Current()
+	if err != nil {
+		return "", errors.Wrap(err, "could not get current user")
+	}
+	return u.Username, nil
+}
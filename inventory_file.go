@@ -0,0 +1,470 @@
+package gossh
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/krilor/gossh/target/rmt/sshconfig"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/yaml.v2"
+)
+
+// NewInventoryFromFile returns an inventory with the hosts and groups in the inventory file filename.
+// See LoadInventoryConfig for the supported formats, and InventoryConfig.Inventory for how hosts are connected to.
+func NewInventoryFromFile(filename string, hostkeycallback ssh.HostKeyCallback) (*Inventory, error) {
+	c, err := LoadInventoryConfig(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Inventory(hostkeycallback)
+}
+
+// LoadInventoryConfig loads the inventory file filename.
+// The format is given by the extension: .json for JSON, .yml or .yaml for YAML, and Ansible-style INI for anything else.
+// Executables without a .ini extension are dynamic inventory scripts, see InventoryScript.
+func LoadInventoryConfig(filename string) (*InventoryConfig, error) {
+	return loadInventoryConfig(context.Background(), filename)
+}
+
+// loadInventoryConfig loads the inventory file filename, see LoadInventoryConfig. Dynamic inventory scripts are run with ctx.
+func loadInventoryConfig(ctx context.Context, filename string) (*InventoryConfig, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open inventory")
+	}
+	defer f.Close()
+
+	var c *InventoryConfig
+
+	switch ext := strings.ToLower(filepath.Ext(filename)); {
+	case ext == ".json":
+		c, err = ParseInventoryJSON(f)
+	case ext == ".yml" || ext == ".yaml":
+		c, err = ParseInventoryYAML(f)
+	case ext != ".ini" && isExecutable(filename):
+		c, err = InventoryScript{Path: filename}.Load(ctx)
+	default:
+		c, err = ParseInventoryINI(f)
+	}
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not load inventory %s", filename)
+	}
+
+	return c, nil
+}
+
+// InventorySource is a source of hosts and groups, e.g. an inventory file or a dynamic inventory script
+type InventorySource interface {
+	Load(ctx context.Context) (*InventoryConfig, error)
+}
+
+// InventoryFile is an InventorySource for the inventory file at the path, see LoadInventoryConfig
+type InventoryFile string
+
+// Load implements InventorySource. Dynamic inventory scripts are run with ctx.
+func (f InventoryFile) Load(ctx context.Context) (*InventoryConfig, error) {
+	return loadInventoryConfig(ctx, string(f))
+}
+
+// SSHConfigFile is an InventorySource for the hosts in an OpenSSH client config file at the path, e.g. ~/.ssh/config.
+//
+// Each host alias that is not a pattern is a host, connected to with the settings in the file, see HostConfig.SSHConfig.
+// Hosts in other sources are also connected to with the settings in the file if they have the same name.
+type SSHConfigFile string
+
+// Load implements InventorySource
+func (f SSHConfigFile) Load(ctx context.Context) (*InventoryConfig, error) {
+	c, err := sshconfig.Load(sshconfig.ExpandHome(string(f)))
+	if err != nil {
+		return nil, err
+	}
+
+	ic := &InventoryConfig{
+		Groups: map[string]GroupConfig{},
+		Vars:   map[string]interface{}{},
+	}
+
+	for _, alias := range c.Aliases() {
+		ic.Hosts = append(ic.Hosts, HostConfig{Name: alias, SSHConfig: string(f)})
+	}
+
+	return ic, nil
+}
+
+// NewInventoryFromSources returns an inventory with the hosts and groups of all sources, merged in order as by InventoryConfig.Merge.
+// This way, static and dynamic hosts can be combined, and static files can add groups and variables to dynamic hosts.
+func NewInventoryFromSources(ctx context.Context, hostkeycallback ssh.HostKeyCallback, sources ...InventorySource) (*Inventory, error) {
+	c := &InventoryConfig{}
+
+	for _, s := range sources {
+		sc, err := s.Load(ctx)
+		if err != nil {
+			return nil, err
+		}
+		c.Merge(sc)
+	}
+
+	return c.Inventory(hostkeycallback)
+}
+
+// isExecutable reports if the file at path is an executable, i.e. a dynamic inventory script
+func isExecutable(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return info.Mode().IsRegular() && info.Mode()&0111 != 0
+}
+
+// inventoryFile is the format of JSON and YAML inventory files
+type inventoryFile struct {
+	Vars   map[string]interface{} `json:"vars" yaml:"vars"`
+	Hosts  map[string]HostConfig  `json:"hosts" yaml:"hosts"`
+	Groups map[string]GroupConfig `json:"groups" yaml:"groups"`
+}
+
+// config returns the InventoryConfig of f.
+// Hosts are sorted by name. Hosts that are only listed in groups are added after the others.
+func (f inventoryFile) config() *InventoryConfig {
+	c := &InventoryConfig{
+		Groups: map[string]GroupConfig{},
+		Vars:   map[string]interface{}{},
+	}
+
+	for k, v := range f.Vars {
+		c.Vars[k] = v
+	}
+
+	names := []string{}
+	for name := range f.Hosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		h := f.Hosts[name]
+		h.Name = name
+		c.Hosts = append(c.Hosts, h)
+	}
+
+	groups := []string{}
+	for name := range f.Groups {
+		groups = append(groups, name)
+	}
+	sort.Strings(groups)
+
+	for _, name := range groups {
+		g := f.Groups[name]
+		c.Groups[name] = g
+		for _, m := range g.Hosts {
+			c.host(m)
+		}
+	}
+
+	return c
+}
+
+// ParseInventoryJSON parses a JSON inventory:
+//
+//	{
+//	  "vars": {"ntp": "pool.ntp.org"},
+//	  "hosts": {
+//	    "web01": {"address": "10.0.0.1", "user": "deploy", "auth": ["agent"], "sudo_password": "env:SUDO_PASS", "vars": {"http_port": 8080}},
+//	    "db01": {"port": 2222, "groups": ["db"]}
+//	  },
+//	  "groups": {
+//	    "web": {"hosts": ["web01"], "vars": {"http_port": 80}},
+//	    "eu": {"children": ["web", "db"]}
+//	  }
+//	}
+//
+// The fields of hosts and groups are as in HostConfig and GroupConfig. Unknown fields are an error.
+func ParseInventoryJSON(r io.Reader) (*InventoryConfig, error) {
+	f := inventoryFile{}
+
+	d := json.NewDecoder(r)
+	d.DisallowUnknownFields()
+
+	err := d.Decode(&f)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse json inventory")
+	}
+
+	return f.config(), nil
+}
+
+// ParseInventoryYAML parses a YAML inventory. The format is the same as for ParseInventoryJSON:
+//
+//	vars:
+//	  ntp: pool.ntp.org
+//	hosts:
+//	  web01:
+//	    address: 10.0.0.1
+//	    auth: [agent]
+//	  db01:
+//	groups:
+//	  web:
+//	    hosts: [web01]
+//	    vars:
+//	      http_port: 80
+func ParseInventoryYAML(r io.Reader) (*InventoryConfig, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read yaml inventory")
+	}
+
+	f := inventoryFile{}
+	err = yaml.UnmarshalStrict(b, &f)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse yaml inventory")
+	}
+
+	f.Vars = yamlMap(f.Vars)
+	for name, h := range f.Hosts {
+		h.Vars = yamlMap(h.Vars)
+		f.Hosts[name] = h
+	}
+	for name, g := range f.Groups {
+		g.Vars = yamlMap(g.Vars)
+		f.Groups[name] = g
+	}
+
+	return f.config(), nil
+}
+
+// yamlMap returns kv with all nested map[interface{}]interface{} from the yaml decoder converted to map[string]interface{}, like from the json decoder
+func yamlMap(kv map[string]interface{}) map[string]interface{} {
+	if kv == nil {
+		return nil
+	}
+
+	out := map[string]interface{}{}
+	for k, v := range kv {
+		out[k] = yamlValue(v)
+	}
+	return out
+}
+
+// yamlValue converts nested maps in v, see yamlMap
+func yamlValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		out := map[string]interface{}{}
+		for k, e := range v {
+			out[fmt.Sprint(k)] = yamlValue(e)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			out[i] = yamlValue(e)
+		}
+		return out
+	}
+	return v
+}
+
+// ParseInventoryINI parses an Ansible-style INI inventory:
+//
+//	web01 ansible_host=10.0.0.1 http_port=8080
+//
+//	[web]
+//	web01
+//	web02 ansible_port=2222
+//
+//	[web:vars]
+//	http_port=80
+//
+//	[eu:children]
+//	web
+//
+//	[all:vars]
+//	ansible_user=deploy
+//
+// Hosts are added in the order they first appear. Ranges in host names are expanded, e.g. web[01:20] is web01 to web20, see Inventory.Select.
+// All key=value pairs are variables, and values are strings.
+// Connection settings are given by the Ansible connection variables, see HostConfig.
+// Lines starting with # or ; are comments.
+func ParseInventoryINI(r io.Reader) (*InventoryConfig, error) {
+	c := &InventoryConfig{
+		Groups: map[string]GroupConfig{},
+		Vars:   map[string]interface{}{},
+	}
+
+	// section is the current group, and kind is "", "vars" or "children"
+	section := ""
+	kind := ""
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		if line[0] == '[' {
+			if line[len(line)-1] != ']' {
+				return nil, fmt.Errorf("line %d: invalid section %s", n, line)
+			}
+
+			parts := strings.SplitN(line[1:len(line)-1], ":", 2)
+			section = strings.TrimSpace(parts[0])
+			kind = ""
+			if len(parts) == 2 {
+				kind = strings.TrimSpace(parts[1])
+			}
+
+			if section == "" || (kind != "" && kind != "vars" && kind != "children") {
+				return nil, fmt.Errorf("line %d: invalid section %s", n, line)
+			}
+
+			if section != AllGroup {
+				if _, ok := c.Groups[section]; !ok {
+					c.Groups[section] = GroupConfig{}
+				}
+			}
+			continue
+		}
+
+		fields, err := splitFields(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+
+		switch kind {
+		case "vars":
+			k, v, err := keyValue(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n, err)
+			}
+
+			if section == AllGroup {
+				c.Vars[k] = v
+				continue
+			}
+
+			g := c.Groups[section]
+			if g.Vars == nil {
+				g.Vars = map[string]interface{}{}
+			}
+			g.Vars[k] = v
+			c.Groups[section] = g
+
+		case "children":
+			if len(fields) != 1 {
+				return nil, fmt.Errorf("line %d: invalid child group %s", n, line)
+			}
+
+			if _, ok := c.Groups[fields[0]]; !ok {
+				c.Groups[fields[0]] = GroupConfig{}
+			}
+
+			if section == AllGroup {
+				continue
+			}
+
+			g := c.Groups[section]
+			g.Children = append(g.Children, fields[0])
+			c.Groups[section] = g
+
+		default:
+			names, err := expandRange(fields[0])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n, err)
+			}
+
+			for _, name := range names {
+				h := c.host(name)
+
+				for _, f := range fields[1:] {
+					k, v, err := keyValue(f)
+					if err != nil {
+						return nil, fmt.Errorf("line %d: %v", n, err)
+					}
+					if h.Vars == nil {
+						h.Vars = map[string]interface{}{}
+					}
+					h.Vars[k] = v
+				}
+
+				if section != "" && section != AllGroup {
+					g := c.Groups[section]
+					g.Hosts = append(g.Hosts, name)
+					c.Groups[section] = g
+				}
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "could not read ini inventory")
+	}
+
+	return c, nil
+}
+
+// keyValue splits a key=value pair. Quotes around value are removed.
+func keyValue(in string) (key string, value string, err error) {
+	parts := strings.SplitN(in, "=", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return "", "", fmt.Errorf("invalid variable %s", in)
+	}
+
+	key = strings.TrimSpace(parts[0])
+	value = strings.TrimSpace(parts[1])
+
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		value = value[1 : len(value)-1]
+	}
+
+	return key, value, nil
+}
+
+// splitFields splits line on whitespace, except for whitespace within single or double quotes. The quotes are kept.
+func splitFields(line string) ([]string, error) {
+	fields := []string{}
+	field := strings.Builder{}
+	var quote rune
+
+	for _, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+			field.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+			field.WriteRune(r)
+		case r == ' ' || r == '\t':
+			if field.Len() > 0 {
+				fields = append(fields, field.String())
+				field.Reset()
+			}
+		default:
+			field.WriteRune(r)
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in %s", line)
+	}
+
+	if field.Len() > 0 {
+		fields = append(fields, field.String())
+	}
+
+	return fields, nil
+}
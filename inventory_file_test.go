@@ -0,0 +1,425 @@
+package gossh
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/krilor/gossh/testing/sshtest"
+	"golang.org/x/crypto/ssh"
+)
+
+// The same inventory in all formats
+const (
+	inventoryJSON = `{
+  "vars": {"ntp": "pool.ntp.org", "http_port": "8000"},
+  "hosts": {
+    "db01": {"address": "10.0.0.3", "user": "postgres", "groups": ["db"]},
+    "web01": {"address": "10.0.0.1", "port": 2222, "user": "deploy", "vars": {"http_port": "8080"}},
+    "web02": {"user": "deploy"}
+  },
+  "groups": {
+    "web": {"hosts": ["web01", "web02"], "vars": {"http_port": "80", "tier": "web"}},
+    "eu": {"children": ["web", "db"], "vars": {"tier": "eu", "region": "eu"}}
+  }
+}`
+
+	inventoryYAML = `
+vars:
+  ntp: pool.ntp.org
+  http_port: "8000"
+hosts:
+  db01:
+    address: 10.0.0.3
+    user: postgres
+    groups: [db]
+  web01:
+    address: 10.0.0.1
+    port: 2222
+    user: deploy
+    vars:
+      http_port: "8080"
+  web02:
+    user: deploy
+groups:
+  web:
+    hosts: [web01, web02]
+    vars:
+      http_port: "80"
+      tier: web
+  eu:
+    children: [web, db]
+    vars:
+      tier: eu
+      region: eu
+`
+
+	inventoryINI = `# comment
+db01 ansible_host=10.0.0.3 ansible_user=postgres
+
+[web]
+web01 ansible_host=10.0.0.1 ansible_port=2222 http_port="8080"
+web02
+
+[web:vars]
+http_port=80
+tier = web
+ansible_user=deploy
+
+[db]
+db01
+
+[eu:children]
+web
+db
+
+[eu:vars]
+; another comment
+tier=eu
+region=eu
+
+[all:vars]
+ntp=pool.ntp.org
+http_port=8000
+`
+)
+
+func TestParseInventory(t *testing.T) {
+
+	var tests = []struct {
+		name  string
+		parse func() (*InventoryConfig, error)
+	}{
+		{"json", func() (*InventoryConfig, error) { return ParseInventoryJSON(strings.NewReader(inventoryJSON)) }},
+		{"yaml", func() (*InventoryConfig, error) { return ParseInventoryYAML(strings.NewReader(inventoryYAML)) }},
+		{"ini", func() (*InventoryConfig, error) { return ParseInventoryINI(strings.NewReader(inventoryINI)) }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, err := test.parse()
+			if err != nil {
+				t.Fatalf("parse errored: %v", err)
+			}
+
+			i, err := c.Inventory(ssh.InsecureIgnoreHostKey())
+			if err != nil {
+				t.Fatalf("inventory errored: %v", err)
+			}
+
+			if names := hostNames(i.Hosts()); !reflect.DeepEqual(names, []string{"db01", "web01", "web02"}) {
+				t.Errorf("hosts: got %v", names)
+			}
+
+			if names := hostNames(i.Group("eu")); !reflect.DeepEqual(names, []string{"db01", "web01", "web02"}) {
+				t.Errorf("eu: got %v", names)
+			}
+
+			if groups := i.Groups(); !reflect.DeepEqual(groups, []string{"db", "eu", "web"}) {
+				t.Errorf("groups: got %v", groups)
+			}
+
+			users := map[string]string{"db01": "postgres", "web01": "deploy", "web02": "deploy"}
+			for name, user := range users {
+				if u := i.Host(name).t.User(); u != user {
+					t.Errorf("user of %s: got %s - expect %s", name, u, user)
+				}
+			}
+
+			vars := map[string]map[string]string{
+				"db01":  {"ntp": "pool.ntp.org", "http_port": "8000", "tier": "eu", "region": "eu"},
+				"web01": {"ntp": "pool.ntp.org", "http_port": "8080", "tier": "web", "region": "eu"},
+				"web02": {"ntp": "pool.ntp.org", "http_port": "80", "tier": "web", "region": "eu"},
+			}
+			for name, kv := range vars {
+				h := i.Host(name)
+				for k, expect := range kv {
+					if v, _ := h.Var(k); v != expect {
+						t.Errorf("var %s of %s: got %v - expect %s", k, name, v, expect)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestParseInventoryErrors(t *testing.T) {
+
+	var tests = []struct {
+		name  string
+		parse func() (*InventoryConfig, error)
+	}{
+		{"json unknown field", func() (*InventoryConfig, error) {
+			return ParseInventoryJSON(strings.NewReader(`{"hosts": {"web01": {"adress": "10.0.0.1"}}}`))
+		}},
+		{"yaml unknown field", func() (*InventoryConfig, error) {
+			return ParseInventoryYAML(strings.NewReader("hosts:\n  web01:\n    adress: 10.0.0.1\n"))
+		}},
+		{"ini invalid section", func() (*InventoryConfig, error) {
+			return ParseInventoryINI(strings.NewReader("[web:hosts]\nweb01\n"))
+		}},
+		{"ini invalid var", func() (*InventoryConfig, error) {
+			return ParseInventoryINI(strings.NewReader("web01 ansible_port\n"))
+		}},
+		{"ini unterminated quote", func() (*InventoryConfig, error) {
+			return ParseInventoryINI(strings.NewReader("web01 motd=\"hello\n"))
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := test.parse()
+			if err == nil {
+				t.Errorf("expect error")
+			}
+		})
+	}
+}
+
+func TestInventoryConfigErrors(t *testing.T) {
+
+	var tests = []struct {
+		name string
+		c    InventoryConfig
+	}{
+		{"unknown connection", InventoryConfig{Hosts: []HostConfig{{Name: "web01", Connection: "telnet"}}}},
+		{"unknown auth", InventoryConfig{Hosts: []HostConfig{{Name: "web01", Auth: []string{"kerberos"}}}}},
+		{"invalid port", InventoryConfig{Hosts: []HostConfig{{Name: "web01", Vars: map[string]interface{}{"ansible_port": "ssh"}}}}},
+		{"unknown host in group", InventoryConfig{Groups: map[string]GroupConfig{"web": {Hosts: []string{"web01"}}}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := test.c.Inventory(ssh.InsecureIgnoreHostKey())
+			if err == nil {
+				t.Errorf("expect error")
+			}
+		})
+	}
+}
+
+func TestInventoryConfigMerge(t *testing.T) {
+
+	static, err := ParseInventoryYAML(strings.NewReader(`
+vars:
+  ntp:
+    enabled: true
+    servers: [pool.ntp.org]
+hosts:
+  web01:
+    vars:
+      app: {port: 80}
+groups:
+  web:
+    hosts: [web01]
+    vars:
+      tls: {enabled: false}
+`))
+	if err != nil {
+		t.Fatalf("parse errored: %v", err)
+	}
+
+	dynamic, err := ParseInventoryJSON(strings.NewReader(`{
+  "vars": {"ntp": {"servers": ["time.example.com"]}},
+  "hosts": {"web01": {"vars": {"app": {"workers": 4}}}},
+  "groups": {"web": {"vars": {"tls": {"cert": "web.pem"}}}}
+}`))
+	if err != nil {
+		t.Fatalf("parse errored: %v", err)
+	}
+
+	static.Merge(dynamic)
+
+	expect := map[string]interface{}{
+		"ntp": map[string]interface{}{"enabled": true, "servers": []interface{}{"time.example.com"}},
+		"app": map[string]interface{}{"port": 80, "workers": float64(4)},
+		"tls": map[string]interface{}{"enabled": false, "cert": "web.pem"},
+	}
+
+	if got := static.hostVars(static.Hosts[0]); !reflect.DeepEqual(got, expect) {
+		t.Errorf("merged vars:\n got %v\n expect %v", got, expect)
+	}
+}
+
+func TestNewInventoryFromFile(t *testing.T) {
+
+	server, err := sshtest.New(nil)
+	if err != nil {
+		t.Fatalf("could not start ssh server: %v", err)
+	}
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "gossh-inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "sudopass"), []byte("gosshpwd\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("GOSSH_TEST_PASSWORD", "gosshpwd")
+	defer os.Unsetenv("GOSSH_TEST_PASSWORD")
+
+	inventory := `
+[web]
+web01 ansible_host=127.0.0.1 ansible_port=` + strconv.Itoa(server.Port()) + ` ansible_user=gossh ansible_password=env:GOSSH_TEST_PASSWORD ansible_become_password=file:` + filepath.Join(dir, "sudopass") + `
+web02 ansible_host=127.0.0.1 ansible_port=` + strconv.Itoa(server.Port()) + ` ansible_user=gossh ansible_password=wrong
+`
+	filename := filepath.Join(dir, "hosts")
+	err = ioutil.WriteFile(filename, []byte(inventory), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i, err := NewInventoryFromFile(filename, ssh.FixedHostKey(server.HostKey()))
+	if err != nil {
+		t.Fatalf("could not load inventory: %v", err)
+	}
+
+	web01 := i.Host("web01")
+	web01.Reporter = NopReporter{}
+
+	r, err := web01.RunCheck(context.Background(), "echo hello", "", "root")
+	if err != nil || strings.TrimSpace(r.Stdout) != "hello" {
+		t.Errorf("run on web01: got %s %v", r.Stdout, err)
+	}
+
+	web02 := i.Host("web02")
+	web02.Reporter = NopReporter{}
+
+	_, err = web02.RunCheck(context.Background(), "echo hello", "", "")
+	if err == nil {
+		t.Errorf("expect connect error on web02")
+	}
+}
+
+func TestSSHConfigFile(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gossh-inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyfile := filepath.Join(dir, "id_test")
+	err = ioutil.WriteFile(keyfile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jump, err := sshtest.New(map[string]sshtest.User{"jumper": {Keys: []ssh.PublicKey{signer.PublicKey()}}})
+	if err != nil {
+		t.Fatalf("could not start ssh server: %v", err)
+	}
+	defer jump.Close()
+
+	server, err := sshtest.New(map[string]sshtest.User{"gossh": {Keys: []ssh.PublicKey{signer.PublicKey()}, Password: "gosshpwd", Sudo: sshtest.SudoPassword}})
+	if err != nil {
+		t.Fatalf("could not start ssh server: %v", err)
+	}
+	defer server.Close()
+
+	sshConfig := filepath.Join(dir, "ssh_config")
+	err = ioutil.WriteFile(sshConfig, []byte(`
+Host web01
+    HostName 127.0.0.1
+    Port `+strconv.Itoa(server.Port())+`
+    ProxyJump bastion
+
+Host bastion
+    HostName 127.0.0.1
+    Port `+strconv.Itoa(jump.Port())+`
+    User jumper
+
+Host *
+    User gossh
+    IdentityFile `+keyfile+`
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	static := filepath.Join(dir, "hosts")
+	err = ioutil.WriteFile(static, []byte("[web]\nweb01 ansible_become_password=gosshpwd\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i, err := NewInventoryFromSources(context.Background(), ssh.InsecureIgnoreHostKey(), InventoryFile(static), SSHConfigFile(sshConfig))
+	if err != nil {
+		t.Fatalf("could not load inventory: %v", err)
+	}
+
+	if got := hostNames(i.Hosts()); !reflect.DeepEqual(got, []string{"web01", "bastion"}) {
+		t.Errorf("hosts: got %v", got)
+	}
+
+	if got := hostNames(i.Group("web")); !reflect.DeepEqual(got, []string{"web01"}) {
+		t.Errorf("web: got %v", got)
+	}
+
+	web01 := i.Host("web01")
+	web01.Reporter = NopReporter{}
+
+	r, err := web01.RunCheck(context.Background(), "echo hello", "", "root")
+	if err != nil || strings.TrimSpace(r.Stdout) != "hello" {
+		t.Errorf("run on web01: got %s %v", r.Stdout, err)
+	}
+
+	if forwarded := jump.Forwarded(); len(forwarded) != 1 || forwarded[0] != server.Addr() {
+		t.Errorf("expect connection through bastion, got forwards %v", forwarded)
+	}
+}
+
+func TestResolveSecret(t *testing.T) {
+
+	os.Setenv("GOSSH_TEST_SECRET", "s3cret")
+	defer os.Unsetenv("GOSSH_TEST_SECRET")
+
+	var tests = []struct {
+		src    string
+		expect string
+		err    bool
+	}{
+		{"plain", "plain", false},
+		{"env:GOSSH_TEST_SECRET", "s3cret", false},
+		{"env:GOSSH_TEST_NOT_SET", "", true},
+		{"file:/nonexistent/secret", "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.src, func(t *testing.T) {
+			got, err := ResolveSecret(test.src)
+			if (err != nil) != test.err || got != test.expect {
+				t.Errorf("got %s %v - expect %s, error %v", got, err, test.expect, test.err)
+			}
+		})
+	}
+}
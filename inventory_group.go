@@ -0,0 +1,217 @@
+package gossh
+
+import (
+	"sort"
+)
+
+// AllGroup is the name of the implicit group that contains all hosts in an Inventory
+const AllGroup string = "all"
+
+// group is a named group of hosts in an Inventory
+type group struct {
+	// hosts are the direct members of the group
+	hosts []*Host
+	// children are the names of the child groups. The members of child groups are members of the group.
+	children []string
+}
+
+// group returns the group named name, creating it if it does not exist. i.mu must be held by the caller.
+func (i *Inventory) group(name string) *group {
+	if i.groups == nil {
+		i.groups = map[string]*group{}
+	}
+
+	g, ok := i.groups[name]
+	if !ok {
+		g = &group{}
+		i.groups[name] = g
+	}
+	return g
+}
+
+// AddToGroup adds hosts to the group named name, creating the group if it does not exist.
+// Hosts that are not in i are added to i. A host can be in any number of groups.
+func (i *Inventory) AddToGroup(name string, hosts ...*Host) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	g := i.group(name)
+
+outer:
+	for _, h := range hosts {
+		i.add(h)
+		for _, m := range g.hosts {
+			if m == h {
+				continue outer
+			}
+		}
+		g.hosts = append(g.hosts, h)
+	}
+}
+
+// AddChildren adds the groups named children as children of the group named name, creating the groups if they does not exist.
+// The hosts in a child group are members of the parent group.
+func (i *Inventory) AddChildren(name string, children ...string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	g := i.group(name)
+
+outer:
+	for _, c := range children {
+		i.group(c)
+		for _, existing := range g.children {
+			if existing == c {
+				continue outer
+			}
+		}
+		g.children = append(g.children, c)
+	}
+}
+
+// Groups returns the names of all groups in i, sorted. AllGroup is not included.
+func (i *Inventory) Groups() []string {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	names := []string{}
+	for name := range i.groups {
+		if name != AllGroup {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// HasGroup reports if i has a group named name. AllGroup always exists.
+func (i *Inventory) HasGroup(name string) bool {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	_, ok := i.groups[name]
+	return ok || name == AllGroup
+}
+
+// Group returns the hosts in the group named name, including the hosts of child groups, in the order they were added to i.
+// AllGroup contains all hosts in i. An unknown group has no hosts.
+func (i *Inventory) Group(name string) []*Host {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	if name == AllGroup {
+		return append([]*Host{}, i.hosts...)
+	}
+
+	members := map[*Host]bool{}
+	i.members(name, members, map[string]bool{})
+
+	hosts := []*Host{}
+	for _, h := range i.hosts {
+		if members[h] {
+			hosts = append(hosts, h)
+		}
+	}
+
+	return hosts
+}
+
+// members adds the hosts of the group named name and its children to members. i.mu must be held by the caller.
+// Visited guards against cycles in the group tree.
+func (i *Inventory) members(name string, members map[*Host]bool, visited map[string]bool) {
+	g, ok := i.groups[name]
+	if !ok || visited[name] {
+		return
+	}
+	visited[name] = true
+
+	for _, h := range g.hosts {
+		members[h] = true
+	}
+
+	for _, c := range g.children {
+		i.members(c, members, visited)
+	}
+}
+
+// HostGroups returns the names of the groups h is a member of, directly or through child groups, sorted. AllGroup is not included.
+func (i *Inventory) HostGroups(h *Host) []string {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	names := []string{}
+	for name := range i.groups {
+		if name == AllGroup {
+			continue
+		}
+		members := map[*Host]bool{}
+		i.members(name, members, map[string]bool{})
+		if members[h] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// groupsOf returns the names of the groups h is a member of, directly or through child groups, ordered as by orderGroups.
+// Views of h, e.g. the hosts passed to rules, are members of the same groups as h. i.mu must be held by the caller.
+func (i *Inventory) groupsOf(h *Host) []string {
+	parents := map[string][]string{}
+	direct := []string{}
+
+	for name, g := range i.groups {
+		for _, c := range g.children {
+			parents[c] = append(parents[c], name)
+		}
+		for _, m := range g.hosts {
+			if m.vars == h.vars {
+				direct = append(direct, name)
+			}
+		}
+	}
+
+	return orderGroups(direct, parents)
+}
+
+// orderGroups returns the groups in direct and their ancestors given by parents, keyed by child.
+//
+// The groups are ordered so that parents comes before their children, and groups at the same level are sorted by name.
+// AllGroup is not included.
+func orderGroups(direct []string, parents map[string][]string) []string {
+	// direct groups has depth 0, and their ancestors negative depths. A group reached by several paths gets the lowest depth.
+	depth := map[string]int{}
+	var visit func(name string, d int, path map[string]bool)
+	visit = func(name string, d int, path map[string]bool) {
+		if name == AllGroup || path[name] {
+			return
+		}
+		path[name] = true
+		defer delete(path, name)
+
+		if cur, ok := depth[name]; !ok || d < cur {
+			depth[name] = d
+		}
+		for _, p := range parents[name] {
+			visit(p, d-1, path)
+		}
+	}
+	for _, name := range direct {
+		visit(name, 0, map[string]bool{})
+	}
+
+	names := []string{}
+	for name := range depth {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if depth[names[i]] != depth[names[j]] {
+			return depth[names[i]] < depth[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	return names
+}
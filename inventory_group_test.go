@@ -0,0 +1,73 @@
+package gossh
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/krilor/gossh/testing/faketarget"
+)
+
+// hostNames returns the names of hosts
+func hostNames(hosts []*Host) []string {
+	names := []string{}
+	for _, h := range hosts {
+		names = append(names, h.String())
+	}
+	return names
+}
+
+func TestInventoryGroups(t *testing.T) {
+
+	i := newTestInventory(t, 5)
+	h := i.Hosts()
+
+	i.AddToGroup("web", h[3], h[1], h[1])
+	i.AddToGroup("db", h[2])
+	i.AddChildren("eu", "web", "db")
+	i.AddChildren("prod", "eu", "us")
+	i.AddChildren("us", "prod") // cycles are ignored
+
+	extra := New(faketarget.NewHost(t, "gossh", "test"))
+	i.AddToGroup("us", extra)
+
+	if i.Len() != 6 {
+		t.Errorf("host not added to inventory: got %d hosts", i.Len())
+	}
+
+	var tests = []struct {
+		group  string
+		expect []string
+	}{
+		{"web", []string{"gossh@host1", "gossh@host3"}},
+		{"eu", []string{"gossh@host1", "gossh@host2", "gossh@host3"}},
+		{"prod", []string{"gossh@host1", "gossh@host2", "gossh@host3", "gossh@test"}},
+		{"us", []string{"gossh@host1", "gossh@host2", "gossh@host3", "gossh@test"}},
+		{"all", []string{"gossh@host0", "gossh@host1", "gossh@host2", "gossh@host3", "gossh@host4", "gossh@test"}},
+		{"unknown", []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.group, func(t *testing.T) {
+			got := hostNames(i.Group(test.group))
+			if !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("got %v - expect %v", got, test.expect)
+			}
+		})
+	}
+
+	if groups := i.Groups(); !reflect.DeepEqual(groups, []string{"db", "eu", "prod", "us", "web"}) {
+		t.Errorf("groups: got %v", groups)
+	}
+
+	if groups := i.HostGroups(h[1]); !reflect.DeepEqual(groups, []string{"eu", "prod", "us", "web"}) {
+		t.Errorf("host groups: got %v", groups)
+	}
+
+	if groups := i.HostGroups(h[0]); len(groups) != 0 {
+		t.Errorf("host groups of ungrouped host: got %v", groups)
+	}
+
+	if !i.HasGroup("db") || !i.HasGroup(AllGroup) || i.HasGroup("unknown") {
+		t.Errorf("HasGroup wrong")
+	}
+}
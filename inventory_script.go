@@ -0,0 +1,322 @@
+package gossh
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultInventoryScriptTimeout is the timeout of dynamic inventory scripts, if not specified in InventoryScript
+const DefaultInventoryScriptTimeout time.Duration = 30 * time.Second
+
+// InventoryScript is a dynamic inventory, i.e. an executable that prints an inventory as JSON, e.g. from a CMDB or a cloud provider.
+//
+// It is compatible with Ansible dynamic inventory scripts. The executable is run with --list, and must print the groups:
+//
+//	{
+//	  "web": {"hosts": ["web01", "web02"], "vars": {"http_port": 80}, "children": ["canary"]},
+//	  "db": ["db01"],
+//	  "_meta": {"hostvars": {"web01": {"ansible_host": "10.0.0.1"}}}
+//	}
+//
+// If _meta is missing, the executable is run with --host <name> for each host, and must print the variables of the host.
+// The variables of the group all are the variables of all hosts.
+// Connection settings are given by the Ansible connection variables, see HostConfig.
+type InventoryScript struct {
+	// Path is the path to the executable
+	Path string
+	// Timeout is the timeout of each run of the executable. If zero, DefaultInventoryScriptTimeout is used.
+	Timeout time.Duration
+	// CacheDir is the directory the inventory is cached in. If empty, the inventory is not cached.
+	CacheDir string
+	// CacheTTL is how long the cached inventory is valid. Zero means that it never expires.
+	CacheTTL time.Duration
+}
+
+// timeout returns the timeout to use
+func (s InventoryScript) timeout() time.Duration {
+	if s.Timeout <= 0 {
+		return DefaultInventoryScriptTimeout
+	}
+	return s.Timeout
+}
+
+// cachePath returns the path of the cache file of s
+func (s InventoryScript) cachePath() (string, error) {
+	abs, err := filepath.Abs(s.Path)
+	if err != nil {
+		return "", errors.Wrap(err, "could not get absolute path of inventory script")
+	}
+	return filepath.Join(s.CacheDir, url.PathEscape(abs)+".json"), nil
+}
+
+// Load runs the script, or reads the cached inventory if it has not expired, and returns the inventory
+func (s InventoryScript) Load(ctx context.Context) (*InventoryConfig, error) {
+	if s.CacheDir != "" {
+		c, err := s.loadCache()
+		if err != nil {
+			return nil, err
+		}
+		if c != nil {
+			return c, nil
+		}
+	}
+
+	out, err := s.run(ctx, "--list")
+	if err != nil {
+		return nil, err
+	}
+
+	inv := dynamicInventory{}
+	err = json.Unmarshal(out, &inv)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not parse output of %s --list", s.Path)
+	}
+
+	if inv.Meta == nil {
+		inv.Meta = &dynamicMeta{HostVars: map[string]map[string]interface{}{}}
+		for _, name := range inv.hosts() {
+			out, err := s.run(ctx, "--host", name)
+			if err != nil {
+				return nil, err
+			}
+
+			kv := map[string]interface{}{}
+			err = json.Unmarshal(out, &kv)
+			if err != nil {
+				return nil, errors.Wrapf(err, "could not parse output of %s --host %s", s.Path, name)
+			}
+			inv.Meta.HostVars[name] = kv
+		}
+	}
+
+	if s.CacheDir != "" {
+		err = s.saveCache(inv)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return inv.config(), nil
+}
+
+// run runs the script with args and returns stdout
+func (s InventoryScript) run(ctx context.Context, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout())
+	defer cancel()
+
+	stdout := bytes.Buffer{}
+	stderr := bytes.Buffer{}
+
+	cmd := exec.CommandContext(ctx, s.Path, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Start()
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not run inventory script %s", s.Path)
+	}
+
+	// the script is killed when ctx is done, but Wait also waits for children of the script that keeps stdout open, so don't wait for it
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+	}
+
+	if ctx.Err() != nil {
+		return nil, errors.Wrapf(ctx.Err(), "inventory script %s %s aborted", s.Path, strings.Join(args, " "))
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "inventory script %s %s failed: %s", s.Path, strings.Join(args, " "), strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// loadCache returns the cached inventory. It is nil if not cached or expired.
+func (s InventoryScript) loadCache() (*InventoryConfig, error) {
+	path, err := s.cachePath()
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read cached inventory")
+	}
+
+	if s.CacheTTL > 0 && time.Since(info.ModTime()) > s.CacheTTL {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read cached inventory")
+	}
+
+	inv := dynamicInventory{}
+	err = json.Unmarshal(data, &inv)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not parse cached inventory in %s", path)
+	}
+
+	return inv.config(), nil
+}
+
+// saveCache caches inv
+func (s InventoryScript) saveCache(inv dynamicInventory) error {
+	path, err := s.cachePath()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(s.CacheDir, 0700)
+	if err != nil {
+		return errors.Wrap(err, "could not create inventory cache dir")
+	}
+
+	data, err := json.Marshal(inv)
+	if err != nil {
+		return errors.Wrap(err, "could not encode inventory")
+	}
+
+	return errors.Wrap(writeFileAtomic(path, data), "could not write inventory")
+}
+
+// dynamicInventory is the output of an Ansible dynamic inventory script run with --list
+type dynamicInventory struct {
+	Groups map[string]dynamicGroup
+	Meta   *dynamicMeta
+}
+
+// dynamicMeta holds the variables of all hosts
+type dynamicMeta struct {
+	HostVars map[string]map[string]interface{} `json:"hostvars"`
+}
+
+// dynamicGroup is a group in a dynamic inventory. It is either a list of hosts or an object.
+type dynamicGroup struct {
+	Hosts    []string               `json:"hosts,omitempty"`
+	Vars     map[string]interface{} `json:"vars,omitempty"`
+	Children []string               `json:"children,omitempty"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (d *dynamicInventory) UnmarshalJSON(data []byte) error {
+	raw := map[string]json.RawMessage{}
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
+
+	d.Groups = map[string]dynamicGroup{}
+
+	for name, msg := range raw {
+		if name == "_meta" {
+			d.Meta = &dynamicMeta{}
+			err = json.Unmarshal(msg, d.Meta)
+			if err != nil {
+				return errors.Wrap(err, "invalid _meta")
+			}
+			continue
+		}
+
+		g := dynamicGroup{}
+		if bytes.HasPrefix(bytes.TrimSpace(msg), []byte("[")) {
+			err = json.Unmarshal(msg, &g.Hosts)
+		} else {
+			err = json.Unmarshal(msg, &g)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "invalid group %s", name)
+		}
+		d.Groups[name] = g
+	}
+
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler
+func (d dynamicInventory) MarshalJSON() ([]byte, error) {
+	raw := map[string]interface{}{}
+	for name, g := range d.Groups {
+		raw[name] = g
+	}
+	if d.Meta != nil {
+		raw["_meta"] = d.Meta
+	}
+	return json.Marshal(raw)
+}
+
+// hosts returns the names of all hosts in d, sorted
+func (d dynamicInventory) hosts() []string {
+	seen := map[string]bool{}
+	for _, g := range d.Groups {
+		for _, h := range g.Hosts {
+			seen[h] = true
+		}
+	}
+	if d.Meta != nil {
+		for h := range d.Meta.HostVars {
+			seen[h] = true
+		}
+	}
+
+	names := []string{}
+	for h := range seen {
+		names = append(names, h)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// config returns the InventoryConfig of d. Hosts are sorted by name.
+func (d dynamicInventory) config() *InventoryConfig {
+	c := &InventoryConfig{
+		Groups: map[string]GroupConfig{},
+		Vars:   map[string]interface{}{},
+	}
+
+	for _, name := range d.hosts() {
+		h := c.host(name)
+		if d.Meta != nil && d.Meta.HostVars[name] != nil {
+			h.Vars = d.Meta.HostVars[name]
+		}
+	}
+
+	for name, g := range d.Groups {
+		if name == AllGroup {
+			for k, v := range g.Vars {
+				c.Vars[k] = v
+			}
+			continue
+		}
+
+		c.Groups[name] = GroupConfig{
+			Hosts:    g.Hosts,
+			Children: g.Children,
+			Vars:     g.Vars,
+		}
+	}
+
+	return c
+}
@@ -0,0 +1,260 @@
+package gossh
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// writeScript writes an executable shell script with body to dir, and returns its path.
+// Each run of the script is logged to dir/runs.
+func writeScript(t *testing.T, dir string, name string, body string) string {
+	path := filepath.Join(dir, name)
+	script := "#!/bin/sh\necho \"$@\" >> " + filepath.Join(dir, "runs") + "\n" + body
+	err := ioutil.WriteFile(path, []byte(script), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// runs returns the logged runs of scripts in dir
+func runs(dir string) []string {
+	b, _ := ioutil.ReadFile(filepath.Join(dir, "runs"))
+	return strings.Fields(strings.ReplaceAll(string(b), "--", ""))
+}
+
+const (
+	// listWithMeta is the output of a dynamic inventory script that includes _meta
+	listWithMeta = `{
+  "web": {"hosts": ["web01", "web02"], "vars": {"http_port": 80}},
+  "db": ["db01"],
+  "eu": {"children": ["web", "db"]},
+  "all": {"vars": {"ntp": "pool.ntp.org"}},
+  "_meta": {"hostvars": {"web01": {"ansible_host": "10.0.0.1", "http_port": 8080}, "db01": {"ansible_user": "postgres"}}}
+}`
+
+	// listWithoutMeta is the output of a dynamic inventory script without _meta
+	listWithoutMeta = `{"web": ["web01", "web02"]}`
+)
+
+func TestInventoryScript(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gossh-inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var tests = []struct {
+		name   string
+		body   string
+		expect map[string]map[string]interface{}
+		runs   []string
+	}{
+		{
+			name: "meta",
+			body: "cat <<'EOF'\n" + listWithMeta + "\nEOF\n",
+			expect: map[string]map[string]interface{}{
+				"db01":  {"ntp": "pool.ntp.org", "ansible_user": "postgres"},
+				"web01": {"ntp": "pool.ntp.org", "ansible_host": "10.0.0.1", "http_port": float64(8080)},
+				"web02": {"ntp": "pool.ntp.org", "http_port": float64(80)},
+			},
+			runs: []string{"list"},
+		},
+		{
+			name: "host",
+			body: `if [ "$1" = "--list" ]; then echo '` + listWithoutMeta + `'; else echo "{\"name\": \"$2\"}"; fi`,
+			expect: map[string]map[string]interface{}{
+				"web01": {"name": "web01"},
+				"web02": {"name": "web02"},
+			},
+			runs: []string{"list", "host", "web01", "host", "web02"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			os.Remove(filepath.Join(dir, "runs"))
+			path := writeScript(t, dir, test.name, test.body)
+
+			c, err := InventoryScript{Path: path}.Load(context.Background())
+			if err != nil {
+				t.Fatalf("load errored: %v", err)
+			}
+
+			i, err := c.Inventory(ssh.InsecureIgnoreHostKey())
+			if err != nil {
+				t.Fatalf("inventory errored: %v", err)
+			}
+
+			got := map[string]map[string]interface{}{}
+			for _, h := range i.Hosts() {
+				got[h.String()] = h.Vars()
+			}
+
+			if !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("vars:\n got %v\n expect %v", got, test.expect)
+			}
+
+			if r := runs(dir); !reflect.DeepEqual(r, test.runs) {
+				t.Errorf("runs: got %v - expect %v", r, test.runs)
+			}
+		})
+	}
+}
+
+func TestInventoryScriptCache(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gossh-inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// no _meta, so --host is run for each host
+	path := writeScript(t, dir, "cmdb", `if [ "$1" = "--list" ]; then echo '`+listWithoutMeta+`'; else echo '{}'; fi`)
+
+	s := InventoryScript{Path: path, CacheDir: filepath.Join(dir, "cache"), CacheTTL: time.Hour}
+
+	for n := 0; n < 3; n++ {
+		c, err := s.Load(context.Background())
+		if err != nil {
+			t.Fatalf("load %d errored: %v", n, err)
+		}
+		if len(c.Hosts) != 2 || !reflect.DeepEqual(c.Groups["web"].Hosts, []string{"web01", "web02"}) {
+			t.Errorf("load %d: wrong inventory %v", n, c)
+		}
+	}
+
+	if r := runs(dir); len(r) != 5 {
+		t.Errorf("runs: got %v - expect a single list and two hosts", r)
+	}
+
+	// expired cache
+	s.CacheTTL = time.Nanosecond
+	_, err = s.Load(context.Background())
+	if err != nil {
+		t.Fatalf("load errored: %v", err)
+	}
+
+	if r := runs(dir); len(r) != 10 {
+		t.Errorf("runs: got %v - expect script to run again", r)
+	}
+}
+
+func TestInventoryScriptErrors(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gossh-inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var tests = []struct {
+		name    string
+		body    string
+		timeout bool
+	}{
+		{"fails", "echo 'cmdb unavailable' >&2; exit 1", false},
+		{"invalid", "echo 'not json'", false},
+		{"invalid group", `echo '{"web": "web01"}'`, false},
+		{"slow", "sleep 5", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := writeScript(t, dir, strings.ReplaceAll(test.name, " ", "_"), test.body)
+
+			start := time.Now()
+			_, err := InventoryScript{Path: path, Timeout: 100 * time.Millisecond}.Load(context.Background())
+			if err == nil {
+				t.Fatalf("expect error")
+			}
+
+			if test.timeout && (errors.Cause(err) != context.DeadlineExceeded || time.Since(start) > 2*time.Second) {
+				t.Errorf("expect timeout, got %v after %s", err, time.Since(start))
+			}
+		})
+	}
+}
+
+func TestNewInventoryFromSources(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gossh-inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	static := filepath.Join(dir, "static.ini")
+	err = ioutil.WriteFile(static, []byte(`
+bastion ansible_host=10.0.0.254
+
+[web]
+web01 tier=static
+
+[web:vars]
+http_port=8000
+
+[prod:children]
+web
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	script := writeScript(t, dir, "cmdb", "cat <<'EOF'\n"+listWithMeta+"\nEOF\n")
+
+	// executable inventory files are scripts
+	i, err := NewInventoryFromSources(context.Background(), ssh.InsecureIgnoreHostKey(), InventoryFile(static), InventoryFile(script))
+	if err != nil {
+		t.Fatalf("load errored: %v", err)
+	}
+
+	if got := hostNames(i.Hosts()); !reflect.DeepEqual(got, []string{"bastion", "web01", "db01", "web02"}) {
+		t.Errorf("hosts: got %v", got)
+	}
+
+	if got := hostNames(i.Group("prod")); !reflect.DeepEqual(got, []string{"web01", "web02"}) {
+		t.Errorf("prod: got %v", got)
+	}
+
+	web01 := i.Host("web01").Vars()
+	if web01["tier"] != "static" || web01["http_port"] != float64(8080) || web01["ntp"] != "pool.ntp.org" {
+		t.Errorf("web01 vars: got %v", web01)
+	}
+
+	web02 := i.Host("web02").Vars()
+	if web02["http_port"] != float64(80) {
+		t.Errorf("web02 vars: got %v", web02)
+	}
+}
+
+func TestNewInventoryFromSourcesContext(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gossh-inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	script := writeScript(t, dir, "slow", "sleep 5")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err = NewInventoryFromSources(ctx, ssh.InsecureIgnoreHostKey(), InventoryFile(script))
+	if errors.Cause(err) != context.DeadlineExceeded || time.Since(start) > 2*time.Second {
+		t.Errorf("expect deadline of ctx to abort the script, got %v after %s", err, time.Since(start))
+	}
+}
@@ -0,0 +1,281 @@
+package gossh
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Select returns the hosts in i that matches the host pattern, in the order they were added to i.
+//
+// A pattern is a list of terms separated by colons, or by commas if the pattern contains any:
+//
+//	web        - the hosts in group web, or the host named web
+//	web*       - the hosts with names or in groups matching the glob web*, see path.Match
+//	web[01:20] - the hosts or groups web01, web02, ..., web20. Ranges can also be letters, e.g. [a:f].
+//	~web\d+    - the hosts with names matching the regular expression web\d+
+//	all or *   - all hosts
+//
+// Terms prefixed with & are intersections, and terms prefixed with ! are exclusions.
+// The hosts are the union of the plain terms, intersected with the & terms, without the hosts of the ! terms, regardless of order.
+// E.g. web:&prod:!web01 is the hosts in both web and prod, except web01. A pattern with only & or ! terms starts from all hosts.
+//
+// Host names are given by Host.String(). Use commas to separate terms if host names contain colons, e.g. user@host:port.
+func (i *Inventory) Select(pattern string) ([]*Host, error) {
+	terms := splitPattern(pattern)
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("empty host pattern")
+	}
+
+	var union map[*Host]bool
+	intersections := []map[*Host]bool{}
+	exclusions := []map[*Host]bool{}
+
+	for _, term := range terms {
+		kind := byte(0)
+		if term[0] == '&' || term[0] == '!' {
+			kind = term[0]
+			term = term[1:]
+		}
+
+		hosts, err := i.match(term)
+		if err != nil {
+			return nil, err
+		}
+
+		switch kind {
+		case '&':
+			intersections = append(intersections, hosts)
+		case '!':
+			exclusions = append(exclusions, hosts)
+		default:
+			if union == nil {
+				union = map[*Host]bool{}
+			}
+			for h := range hosts {
+				union[h] = true
+			}
+		}
+	}
+
+	selected := []*Host{}
+
+outer:
+	for _, h := range i.Hosts() {
+		if union != nil && !union[h] {
+			continue
+		}
+		for _, hosts := range intersections {
+			if !hosts[h] {
+				continue outer
+			}
+		}
+		for _, hosts := range exclusions {
+			if hosts[h] {
+				continue outer
+			}
+		}
+		selected = append(selected, h)
+	}
+
+	return selected, nil
+}
+
+// Limit returns an inventory with the hosts in i that matches pattern, see Select. The groups of i are kept, limited to the selected hosts.
+//
+// The hosts are still in i, so rules applied on them can access all hosts in i through Host.Inventory, and their variables are resolved through i.
+// The returned inventory shares the variables of i.
+// An error is returned if no hosts matches pattern.
+func (i *Inventory) Limit(pattern string) (*Inventory, error) {
+	hosts, err := i.Select(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no hosts matches %s", pattern)
+	}
+
+	selected := map[*Host]bool{}
+	for _, h := range hosts {
+		selected[h] = true
+	}
+
+	// the variables are shared, so that they can be set on either inventory
+	vars := i.variables()
+
+	l := &Inventory{
+		hosts:  hosts,
+		groups: map[string]*group{},
+		vars:   vars,
+	}
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	for name, g := range i.groups {
+		lg := &group{children: append([]string{}, g.children...)}
+		for _, h := range g.hosts {
+			if selected[h] {
+				lg.hosts = append(lg.hosts, h)
+			}
+		}
+		l.groups[name] = lg
+	}
+
+	return l, nil
+}
+
+// splitPattern splits pattern into terms, on commas if pattern contains any, and else on colons that are not within a range
+func splitPattern(pattern string) []string {
+	var parts []string
+
+	if strings.Contains(pattern, ",") {
+		parts = strings.Split(pattern, ",")
+	} else {
+		depth := 0
+		start := 0
+		for n, r := range pattern {
+			switch r {
+			case '[':
+				depth++
+			case ']':
+				depth--
+			case ':':
+				if depth == 0 {
+					parts = append(parts, pattern[start:n])
+					start = n + 1
+				}
+			}
+		}
+		parts = append(parts, pattern[start:])
+	}
+
+	terms := []string{}
+	for _, p := range parts {
+		p = strings.TrimSpace(p)
+		if p != "" && p != "&" && p != "!" {
+			terms = append(terms, p)
+		}
+	}
+
+	return terms
+}
+
+// match returns the hosts in i that matches a single term of a host pattern, see Select
+func (i *Inventory) match(term string) (map[*Host]bool, error) {
+	hosts := map[*Host]bool{}
+
+	if term == AllGroup || term == "*" {
+		for _, h := range i.Hosts() {
+			hosts[h] = true
+		}
+		return hosts, nil
+	}
+
+	if strings.HasPrefix(term, "~") {
+		re, err := regexp.Compile(term[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression in host pattern %s: %v", term, err)
+		}
+		for _, h := range i.Hosts() {
+			if re.MatchString(h.String()) {
+				hosts[h] = true
+			}
+		}
+		return hosts, nil
+	}
+
+	names, err := expandRange(term)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range names {
+		if !strings.ContainsAny(name, "*?[") {
+			for _, h := range i.Group(name) {
+				hosts[h] = true
+			}
+			if h := i.Host(name); h != nil {
+				hosts[h] = true
+			}
+			continue
+		}
+
+		if _, err := path.Match(name, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob in host pattern %s: %v", term, err)
+		}
+
+		for _, h := range i.Hosts() {
+			if ok, _ := path.Match(name, h.String()); ok {
+				hosts[h] = true
+			}
+		}
+		for _, g := range i.Groups() {
+			if ok, _ := path.Match(name, g); ok {
+				for _, h := range i.Group(g) {
+					hosts[h] = true
+				}
+			}
+		}
+	}
+
+	return hosts, nil
+}
+
+// rangeRe matches the first range in a name, e.g. [01:20] or [a:f]
+var rangeRe = regexp.MustCompile(`^(.*?)\[([0-9]+|[a-z]|[A-Z]):([0-9]+|[a-z]|[A-Z])\](.*)$`)
+
+// expandRange expands all ranges in name, e.g. web[01:03] to web01, web02 and web03.
+// Numbers are zero-padded to the width of the start of the range if it has leading zeros.
+// A name without ranges is returned as is.
+func expandRange(name string) ([]string, error) {
+	m := rangeRe.FindStringSubmatch(name)
+	if m == nil {
+		return []string{name}, nil
+	}
+
+	prefix, start, end, suffix := m[1], m[2], m[3], m[4]
+
+	values := []string{}
+
+	if s, err := strconv.Atoi(start); err == nil {
+		e, err := strconv.Atoi(end)
+		if err != nil || e < s {
+			return nil, fmt.Errorf("invalid range [%s:%s] in %s", start, end, name)
+		}
+
+		format := "%d"
+		if len(start) > 1 && start[0] == '0' {
+			format = fmt.Sprintf("%%0%dd", len(start))
+		}
+
+		for n := s; n <= e; n++ {
+			values = append(values, fmt.Sprintf(format, n))
+		}
+	} else {
+		if len(end) != 1 || end[0] < start[0] || (start[0] >= 'a') != (end[0] >= 'a') {
+			return nil, fmt.Errorf("invalid range [%s:%s] in %s", start, end, name)
+		}
+
+		for c := start[0]; c <= end[0]; c++ {
+			values = append(values, string(c))
+		}
+	}
+
+	rest, err := expandRange(suffix)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, v := range values {
+		for _, r := range rest {
+			names = append(names, prefix+v+r)
+		}
+	}
+
+	return names, nil
+}
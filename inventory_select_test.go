@@ -0,0 +1,180 @@
+package gossh
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// selectInventory is an inventory of web and db servers in eu and us
+const selectInventory = `
+[web]
+web[01:04]
+
+[db]
+db[a:b]
+
+[eu]
+web01
+web02
+dba
+
+[us]
+web03
+web04
+dbb
+
+[prod]
+web01
+web03
+dba
+dbb
+
+[servers:children]
+web
+db
+`
+
+func TestSelect(t *testing.T) {
+
+	c, err := ParseInventoryINI(strings.NewReader(selectInventory))
+	if err != nil {
+		t.Fatalf("parse errored: %v", err)
+	}
+
+	i, err := c.Inventory(ssh.InsecureIgnoreHostKey())
+	if err != nil {
+		t.Fatalf("inventory errored: %v", err)
+	}
+
+	var tests = []struct {
+		pattern string
+		expect  []string
+		err     bool
+	}{
+		{"web", []string{"web01", "web02", "web03", "web04"}, false},
+		{"web01", []string{"web01"}, false},
+		{"web:db", []string{"web01", "web02", "web03", "web04", "dba", "dbb"}, false},
+		{"web,db", []string{"web01", "web02", "web03", "web04", "dba", "dbb"}, false},
+		{"web:&prod", []string{"web01", "web03"}, false},
+		{"web:&prod:&eu", []string{"web01"}, false},
+		{"&prod:web:&eu", []string{"web01"}, false},
+		{"all:!db", []string{"web01", "web02", "web03", "web04"}, false},
+		{"!db:!web01", []string{"web02", "web03", "web04"}, false},
+		{"all:!dba", []string{"web01", "web02", "web03", "web04", "dbb"}, false},
+		{"*", []string{"web01", "web02", "web03", "web04", "dba", "dbb"}, false},
+		{"web*", []string{"web01", "web02", "web03", "web04"}, false},
+		{"u?", []string{"web03", "web04", "dbb"}, false},
+		{"ser*:&eu", []string{"web01", "web02", "dba"}, false},
+		{"web[02:03]", []string{"web02", "web03"}, false},
+		{"web[02:03]:db[b:c]", []string{"web02", "web03", "dbb"}, false},
+		{"~^web0[13]$", []string{"web01", "web03"}, false},
+		{"servers:!~^web", []string{"dba", "dbb"}, false},
+		{"nope", []string{}, false},
+		{"", nil, true},
+		{"~(", nil, true},
+		{"web[3:1]", nil, true},
+		{"web[a:3]", nil, true},
+		{"web[", nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.pattern, func(t *testing.T) {
+			hosts, err := i.Select(test.pattern)
+			if (err != nil) != test.err {
+				t.Fatalf("error: got %v - expect error %v", err, test.err)
+			}
+			if test.err {
+				return
+			}
+
+			if got := hostNames(hosts); !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("got %v - expect %v", got, test.expect)
+			}
+		})
+	}
+}
+
+func TestExpandRange(t *testing.T) {
+
+	var tests = []struct {
+		in     string
+		expect []string
+	}{
+		{"web", []string{"web"}},
+		{"web[1:3]", []string{"web1", "web2", "web3"}},
+		{"web[08:10].example.com", []string{"web08.example.com", "web09.example.com", "web10.example.com"}},
+		{"[a:b]-[1:2]", []string{"a-1", "a-2", "b-1", "b-2"}},
+		{"rack[A:B]", []string{"rackA", "rackB"}},
+		{"web[12]", []string{"web[12]"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.in, func(t *testing.T) {
+			got, err := expandRange(test.in)
+			if err != nil {
+				t.Fatalf("errored: %v", err)
+			}
+			if !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("got %v - expect %v", got, test.expect)
+			}
+		})
+	}
+}
+
+func TestLimit(t *testing.T) {
+
+	c, err := ParseInventoryINI(strings.NewReader(selectInventory))
+	if err != nil {
+		t.Fatalf("parse errored: %v", err)
+	}
+
+	i, err := c.Inventory(ssh.InsecureIgnoreHostKey())
+	if err != nil {
+		t.Fatalf("inventory errored: %v", err)
+	}
+
+	l, err := i.Limit("prod:&eu")
+	if err != nil {
+		t.Fatalf("limit errored: %v", err)
+	}
+
+	if got := hostNames(l.Hosts()); !reflect.DeepEqual(got, []string{"web01", "dba"}) {
+		t.Errorf("hosts: got %v", got)
+	}
+
+	if got := hostNames(l.Group("servers")); !reflect.DeepEqual(got, []string{"web01", "dba"}) {
+		t.Errorf("servers: got %v", got)
+	}
+
+	if got := hostNames(l.Group("us")); len(got) != 0 {
+		t.Errorf("us: got %v", got)
+	}
+
+	// hosts are still in the full inventory
+	if inv := l.Host("web01").Inventory(); inv != i {
+		t.Errorf("host moved to limited inventory")
+	}
+
+	for _, h := range l.Hosts() {
+		h.Reporter = NopReporter{}
+	}
+
+	ran := map[string]bool{}
+	res := l.Apply(context.Background(), "noop", ruleFunc(func(ctx context.Context, h *Host) (Status, error) {
+		ran[h.String()] = true
+		return StatusSatisfied, nil
+	}), ApplyOptions{Forks: 1})
+
+	if len(res) != 2 || !ran["web01"] || !ran["dba"] {
+		t.Errorf("applied to wrong hosts: %v", ran)
+	}
+
+	_, err = i.Limit("nope")
+	if err == nil {
+		t.Errorf("expect error when no hosts matches")
+	}
+}
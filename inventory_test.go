@@ -0,0 +1,133 @@
+package gossh
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/krilor/gossh/testing/faketarget"
+)
+
+// newTestInventory returns an inventory of n hosts named host0..host(n-1), with fake targets that fail t on any command
+func newTestInventory(t testing.TB, n int) *Inventory {
+	i := &Inventory{}
+	for j := 0; j < n; j++ {
+		h := New(faketarget.NewHost(t, "gossh", fmt.Sprintf("host%d", j)))
+		h.Reporter = NopReporter{}
+		i.Add(h)
+	}
+	return i
+}
+
+func TestInventoryApply(t *testing.T) {
+
+	var tests = []struct {
+		hosts int
+		forks int
+		max   int
+	}{
+		{10, 3, 3},
+		{4, 0, DefaultForks},
+		{2, 1, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%d-%d", test.hosts, test.forks), func(t *testing.T) {
+
+			mu := sync.Mutex{}
+			running := 0
+			max := 0
+
+			rule := ruleFunc(func(ctx context.Context, h *Host) (Status, error) {
+				mu.Lock()
+				running++
+				if running > max {
+					max = running
+				}
+				mu.Unlock()
+
+				time.Sleep(5 * time.Millisecond)
+
+				mu.Lock()
+				running--
+				mu.Unlock()
+
+				if h.String() == "gossh@host1" {
+					return StatusFailed, fmt.Errorf("host1 fails")
+				}
+				return StatusEnforced, nil
+			})
+
+			i := newTestInventory(t, test.hosts)
+			res := i.Apply(context.Background(), "rule", rule, ApplyOptions{Forks: test.forks})
+
+			if len(res) != test.hosts {
+				t.Fatalf("results: got %d - expect %d", len(res), test.hosts)
+			}
+
+			if max > test.max {
+				t.Errorf("concurrency: got %d - expect max %d", max, test.max)
+			}
+
+			if res.OK() {
+				t.Errorf("expected not OK")
+			}
+
+			failed := res.Failed()
+			if len(failed) != 1 || failed[0].Host.String() != "gossh@host1" || failed[0].Err == nil {
+				t.Errorf("failed: got %v", failed)
+			}
+
+			for n, hr := range res {
+				if hr.Host.String() != fmt.Sprintf("gossh@host%d", n) {
+					t.Errorf("result %d: got host %s", n, hr.Host)
+				}
+				if n == 1 {
+					continue
+				}
+				if hr.Status != StatusEnforced || hr.Err != nil || hr.Result == nil || hr.Result.Name != "rule" {
+					t.Errorf("%s: got %v %v %v", hr.Host, hr.Status, hr.Err, hr.Result)
+				}
+			}
+		})
+	}
+}
+
+func TestInventoryApplySameName(t *testing.T) {
+
+	// two hosts with the same name, e.g. the same address with different ports
+	first := faketarget.New(t, "gossh")
+	i := &Inventory{}
+	for _, ft := range []*faketarget.Target{first, faketarget.New(t, "gossh")} {
+		h := New(ft)
+		h.Reporter = NopReporter{}
+		i.Add(h)
+	}
+
+	rule := ruleFunc(func(ctx context.Context, h *Host) (Status, error) {
+		if h.t == first {
+			return StatusFailed, fmt.Errorf("first fails")
+		}
+		return StatusEnforced, nil
+	})
+
+	res := i.Apply(context.Background(), "rule", rule, ApplyOptions{})
+	if len(res) != 2 || res[0].Host != i.Hosts()[0] || res[1].Host != i.Hosts()[1] {
+		t.Fatalf("results: got %v", res)
+	}
+
+	if res[0].Status != StatusFailed || res[1].Status != StatusEnforced {
+		t.Errorf("status: got %v and %v - expect %v and %v", res[0].Status, res[1].Status, StatusFailed, StatusEnforced)
+	}
+
+	if res.OK() || len(res.Failed()) != 1 {
+		t.Errorf("expect the failed host to be reported, got %v", res.Failed())
+	}
+
+	res, _ = i.Rollout(context.Background(), "rule", rule, RolloutOptions{BatchSize: 1, MaxFailPercent: 100})
+	if len(res) != 2 || res[0].Status != StatusFailed || res[1].Status != StatusEnforced {
+		t.Errorf("rollout results: got %v", res)
+	}
+}
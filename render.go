@@ -0,0 +1,83 @@
+package gossh
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// renderWidth is the width of the lines rendered by TreeReporter
+const renderWidth = 66
+
+// renderTimeFormat is the format of the timestamps rendered by TreeReporter
+const renderTimeFormat = "15:04:05.000"
+
+// indent returns the tree prefix for a line on level
+func indent(level int) string {
+	return strings.TrimPrefix(nSpaces(2*level-1), " ")
+}
+
+// fill joins left and right with a line, making the total width renderWidth
+func fill(left, right string) string {
+	n := renderWidth - utf8.RuneCountInString(left) - utf8.RuneCountInString(right)
+	if n < 1 {
+		n = 1
+	}
+	return left + strings.Repeat("─", n) + right
+}
+
+// header returns the first line of an apply on level
+func header(level int, name string, rule string, at time.Time) string {
+	return fill(
+		fmt.Sprintf("%s┌+ %s ", indent(level), name),
+		fmt.Sprintf(" %s ─ %s ─", rule, at.Format(renderTimeFormat)),
+	)
+}
+
+// footer returns the last line of an apply on level
+func footer(level int, name string, status Status, at time.Time) string {
+	return fill(
+		fmt.Sprintf("%s└─ %s ─ %s ", indent(level), name, statusLabel(status)),
+		fmt.Sprintf(" %s ─", at.Format(renderTimeFormat)),
+	)
+}
+
+// line returns a line of text within an apply on level.
+// Text outside of any apply, level 0, is returned as is.
+func line(level int, text string) string {
+	if level < 1 {
+		return text
+	}
+	return fmt.Sprintf("%s│ %s", indent(level), text)
+}
+
+// statusLabel returns a short, human readable label for s
+func statusLabel(s Status) string {
+	switch s {
+	case StatusSkipped:
+		return "Skipped"
+	case StatusSatisfied:
+		return "OK"
+	case StatusNotSatisfied:
+		return "Not satisfied"
+	case StatusEnforced:
+		return "Changed"
+	case StatusFailed:
+		return "Failed"
+	}
+	return "Undefined"
+}
+
+// logLine returns msg followed by key=value pairs from keyAndValues
+func logLine(msg string, keyAndValues []string) string {
+	b := strings.Builder{}
+	b.WriteString(msg)
+	for i := 0; i < len(keyAndValues); i += 2 {
+		b.WriteString(" " + keyAndValues[i])
+		if i+1 < len(keyAndValues) {
+			b.WriteString("=" + keyAndValues[i+1])
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,106 @@
+package gossh
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/krilor/gossh/testing/faketarget"
+)
+
+func TestIndent(t *testing.T) {
+
+	var tests = []struct {
+		in     int
+		expect string
+	}{
+		{0, ""},
+		{1, ""},
+		{2, "│ "},
+		{4, "│ │ │ "},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%d", test.in), func(t *testing.T) {
+			got := indent(test.in)
+			if got != test.expect {
+				t.Errorf("value: got \"%s\" - expect \"%s\"", got, test.expect)
+			}
+		})
+	}
+}
+
+func TestHeaderFooter(t *testing.T) {
+
+	at := time.Date(2020, 4, 1, 21, 1, 1, 0, time.UTC)
+
+	var tests = []struct {
+		got    string
+		expect string
+	}{
+		{header(1, "bootstrap", "base.Multi", at), "┌+ bootstrap ───────────────────────── base.Multi ─ 21:01:01.000 ─"},
+		{header(2, "tree", "apt.Package", at), "│ ┌+ tree ─────────────────────────── apt.Package ─ 21:01:01.000 ─"},
+		{footer(2, "tree", StatusSatisfied, at), "│ └─ tree ─ OK ──────────────────────────────────── 21:01:01.000 ─"},
+		{footer(1, "bootstrap", StatusEnforced, at), "└─ bootstrap ─ Changed ──────────────────────────── 21:01:01.000 ─"},
+		{line(2, "some text"), "│ │ some text"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expect, func(t *testing.T) {
+			if test.got != test.expect {
+				t.Errorf("value: got \"%s\" - expect \"%s\"", test.got, test.expect)
+			}
+		})
+	}
+
+	long := header(1, strings.Repeat("x", renderWidth), "base.Multi", at)
+	if !strings.Contains(long, "x ─ base.Multi") {
+		t.Errorf("long header not joined by line: \"%s\"", long)
+	}
+
+	if n := utf8.RuneCountInString(header(3, "name", "rule", at)); n != renderWidth {
+		t.Errorf("width: got %d - expect %d", n, renderWidth)
+	}
+}
+
+func TestApplyRender(t *testing.T) {
+
+	ctx := context.Background()
+
+	child := ruleFunc(func(ctx context.Context, h *Host) (Status, error) {
+		return StatusSatisfied, nil
+	})
+
+	parent := ruleFunc(func(ctx context.Context, h *Host) (Status, error) {
+		return h.Apply(ctx, "child", child)
+	})
+
+	b := &bytes.Buffer{}
+	h := New(faketarget.New(t, "gossh"))
+	h.Reporter = NewTreeReporter(b)
+
+	h.Apply(ctx, "parent", parent)
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+
+	expect := []string{
+		"gossh@fake ┌+ parent ",
+		"gossh@fake │ ┌+ child ",
+		"gossh@fake │ └─ child ─ OK ",
+		"gossh@fake └─ parent ─ OK ",
+	}
+
+	if len(lines) != len(expect) {
+		t.Fatalf("lines: got %d - expect %d:\n%s", len(lines), len(expect), b.String())
+	}
+
+	for i, prefix := range expect {
+		if !strings.HasPrefix(lines[i], prefix) {
+			t.Errorf("line %d: got \"%s\" - expect prefix \"%s\"", i, lines[i], prefix)
+		}
+	}
+}
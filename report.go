@@ -0,0 +1,219 @@
+package gossh
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+//go:generate stringer -type=EventKind
+
+// EventKind is the kind of an Event
+type EventKind int
+
+const (
+	// EventApplyStart is reported when a rule is about to be applied
+	EventApplyStart EventKind = iota
+
+	// EventApplyEnd is reported when a rule has been applied. Status, Err and Duration is set.
+	EventApplyEnd
+
+	// EventCheck is reported when a RunCheck command has been run.
+	// Cmd, User, ExitStatus and Duration is set.
+	EventCheck
+
+	// EventEnforceStart is reported when a RunChange command is about to be run.
+	// Cmd and User is set.
+	EventEnforceStart
+
+	// EventEnforceEnd is reported when a RunChange command has been run, or blocked because changes are not allowed.
+	// Cmd, User, ExitStatus, Duration and Blocked is set.
+	EventEnforceEnd
+
+	// EventPut is reported when a file has been written, or blocked because changes are not allowed.
+	// Path, User, Duration and Blocked is set.
+	EventPut
+
+	// EventGet is reported when a file has been read.
+	// Path, User and Duration is set.
+	EventGet
+
+	// EventLog is reported when a message is logged on a host. Msg and KeyAndValues is set.
+	EventLog
+)
+
+// Event is something that happened on a Host.
+//
+// Host, ID, Parent, Level, Name and Rule are set for all events and identifies the rule being applied when the event happened.
+// Commands and files accessed outside of any Apply have an empty Name and Rule.
+type Event struct {
+	Kind EventKind
+	Time time.Time
+	Host string
+
+	// ID and Parent are the trace span IDs of the rule being applied and its parent rule. Level is the nesting level.
+	ID     string
+	Parent string
+	Level  int
+
+	Name string
+	Rule string
+
+	Status Status
+	Err    error
+
+	Cmd        string
+	Path       string
+	User       string
+	ExitStatus int
+	Blocked    bool
+	Duration   time.Duration
+
+	Msg          string
+	KeyAndValues []string
+}
+
+// Reporter receives events from Hosts.
+//
+// Report may be called concurrently, e.g. when several hosts share a Reporter.
+type Reporter interface {
+	Report(e Event)
+}
+
+// NopReporter is a Reporter that discards all events
+type NopReporter struct{}
+
+// Report implements Reporter
+func (NopReporter) Report(e Event) {}
+
+// multiReporter reports to several reporters
+type multiReporter []Reporter
+
+// Report implements Reporter
+func (m multiReporter) Report(e Event) {
+	for _, r := range m {
+		r.Report(e)
+	}
+}
+
+// MultiReporter returns a Reporter that reports all events to each of rs.
+func MultiReporter(rs ...Reporter) Reporter {
+	return multiReporter(rs)
+}
+
+// TreeReporter is a Reporter that renders applies as a human readable tree.
+//
+//	web01 ┌+ bootstrap ───────────────────────── base.Multi ─ 21:01:01.000 ─
+//	web01 │ ┌+ tree ─────────────────────────── apt.Package ─ 21:01:01.000 ─
+//	web01 │ └─ tree ─ OK ──────────────────────────────────── 21:01:01.000 ─
+//	web01 └─ bootstrap ─ Changed ──────────────────────────── 21:01:01.000 ─
+//
+// The nesting is given by the trace level of the events. Only apply and log events are rendered.
+// Each line is prefixed with the host of the event, so that the trees of hosts applied concurrently can be told apart.
+// Lines are written whole, so a TreeReporter can be shared by several hosts.
+type TreeReporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewTreeReporter returns a TreeReporter that writes to w
+func NewTreeReporter(w io.Writer) *TreeReporter {
+	return &TreeReporter{w: w}
+}
+
+// Report implements Reporter
+func (t *TreeReporter) Report(e Event) {
+	switch e.Kind {
+	case EventApplyStart:
+		t.write(e.Host, header(e.Level, e.Name, e.Rule, e.Time))
+	case EventApplyEnd:
+		if e.Err != nil {
+			t.write(e.Host, line(e.Level, e.Err.Error()))
+		}
+		t.write(e.Host, footer(e.Level, e.Name, e.Status, e.Time))
+	case EventLog:
+		t.write(e.Host, line(e.Level, logLine(e.Msg, e.KeyAndValues)))
+	}
+}
+
+// write writes s as a line to t.w, prefixed with host if it is set
+func (t *TreeReporter) write(host string, s string) {
+	if host != "" {
+		s = host + " " + s
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	io.WriteString(t.w, s+"\n")
+}
+
+// JSONReporter is a Reporter that writes every event as a JSON object on a single line
+type JSONReporter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewJSONReporter returns a JSONReporter that writes to w
+func NewJSONReporter(w io.Writer) *JSONReporter {
+	return &JSONReporter{enc: json.NewEncoder(w)}
+}
+
+// jsonEvent is the JSON representation of an Event
+type jsonEvent struct {
+	Kind         string    `json:"kind"`
+	Time         time.Time `json:"time"`
+	Host         string    `json:"host"`
+	ID           string    `json:"id,omitempty"`
+	Parent       string    `json:"parent,omitempty"`
+	Level        int       `json:"level"`
+	Name         string    `json:"name,omitempty"`
+	Rule         string    `json:"rule,omitempty"`
+	Status       string    `json:"status,omitempty"`
+	Err          string    `json:"error,omitempty"`
+	Cmd          string    `json:"cmd,omitempty"`
+	Path         string    `json:"path,omitempty"`
+	User         string    `json:"user,omitempty"`
+	ExitStatus   *int      `json:"exit_status,omitempty"`
+	Blocked      bool      `json:"blocked,omitempty"`
+	Duration     int64     `json:"duration_ns,omitempty"`
+	Msg          string    `json:"msg,omitempty"`
+	KeyAndValues []string  `json:"kv,omitempty"`
+}
+
+// Report implements Reporter
+func (j *JSONReporter) Report(e Event) {
+	je := jsonEvent{
+		Kind:         e.Kind.String(),
+		Time:         e.Time,
+		Host:         e.Host,
+		ID:           e.ID,
+		Parent:       e.Parent,
+		Level:        e.Level,
+		Name:         e.Name,
+		Rule:         e.Rule,
+		Cmd:          e.Cmd,
+		Path:         e.Path,
+		User:         e.User,
+		Blocked:      e.Blocked,
+		Duration:     int64(e.Duration),
+		Msg:          e.Msg,
+		KeyAndValues: e.KeyAndValues,
+	}
+
+	if e.Kind == EventApplyEnd {
+		je.Status = e.Status.String()
+	}
+
+	if e.Kind == EventCheck || e.Kind == EventEnforceEnd {
+		je.ExitStatus = &e.ExitStatus
+	}
+
+	if e.Err != nil {
+		je.Err = e.Err.Error()
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.enc.Encode(je)
+}
@@ -0,0 +1,215 @@
+package gossh
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/krilor/gossh/testing/faketarget"
+)
+
+// eventRecorder is a Reporter that records all events
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *eventRecorder) Report(e Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+}
+
+// kinds returns the kinds of all recorded events
+func (r *eventRecorder) kinds() []EventKind {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	k := []EventKind{}
+	for _, e := range r.events {
+		k = append(k, e.Kind)
+	}
+	return k
+}
+
+func TestReportEvents(t *testing.T) {
+
+	ctx := context.Background()
+
+	rule := ruleFunc(func(ctx context.Context, h *Host) (Status, error) {
+		h.Log("checking")
+		h.RunCheck(ctx, "false", "", "")
+		h.RunChange(ctx, "true", "", "root")
+		h.Put(ctx, "/tmp/file", []byte("content"), 0644, "")
+		h.Get(ctx, "/tmp/file", "")
+		return StatusEnforced, nil
+	})
+
+	var tests = []struct {
+		allowChange bool
+		expect      []EventKind
+	}{
+		{true, []EventKind{EventApplyStart, EventLog, EventCheck, EventEnforceStart, EventEnforceEnd, EventPut, EventGet, EventApplyEnd}},
+		{false, []EventKind{EventApplyStart, EventLog, EventCheck, EventEnforceStart, EventEnforceEnd, EventPut, EventGet, EventApplyEnd}},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%v", test.allowChange), func(t *testing.T) {
+			rec := &eventRecorder{}
+			ft := faketarget.New(t, "gossh")
+			ft.Expect("false").Returns("", "", 1)
+			ft.Expect("true").As("root")
+			h := New(ft)
+			h.AllowChange = test.allowChange
+			h.Reporter = rec
+
+			h.Apply(ctx, "rule", rule)
+
+			got := rec.kinds()
+			if fmt.Sprint(got) != fmt.Sprint(test.expect) {
+				t.Fatalf("kinds: got %v - expect %v", got, test.expect)
+			}
+
+			for _, e := range rec.events {
+				if e.Name != "rule" || e.Rule != "gossh.ruleFunc" || e.Level != 1 || e.Host != "gossh@fake" {
+					t.Errorf("%v: wrong apply details: %s %s %d %s", e.Kind, e.Name, e.Rule, e.Level, e.Host)
+				}
+			}
+
+			check := rec.events[2]
+			if check.Cmd != "false" || check.ExitStatus != 1 {
+				t.Errorf("check: got %s %d", check.Cmd, check.ExitStatus)
+			}
+
+			enforce := rec.events[4]
+			if enforce.Cmd != "true" || enforce.User != "root" || enforce.Blocked == test.allowChange {
+				t.Errorf("enforce end: got %s %s %v", enforce.Cmd, enforce.User, enforce.Blocked)
+			}
+
+			if rec.events[5].Blocked == test.allowChange {
+				t.Errorf("put: blocked %v", rec.events[5].Blocked)
+			}
+
+			end := rec.events[7]
+			if end.Status == StatusUndefined || end.Duration <= 0 {
+				t.Errorf("apply end: got %v %v", end.Status, end.Duration)
+			}
+		})
+	}
+}
+
+func TestTreeReporterConcurrent(t *testing.T) {
+
+	ctx := context.Background()
+
+	child := ruleFunc(func(ctx context.Context, h *Host) (Status, error) {
+		time.Sleep(time.Millisecond)
+		return StatusSatisfied, nil
+	})
+
+	parent := ruleFunc(func(ctx context.Context, h *Host) (Status, error) {
+		return h.Apply(ctx, "child", child)
+	})
+
+	b := &bytes.Buffer{}
+	tree := NewTreeReporter(b)
+
+	i := newTestInventory(t, 8)
+	for _, h := range i.Hosts() {
+		h.Reporter = tree
+	}
+
+	i.Apply(ctx, "parent", parent, ApplyOptions{Forks: 8})
+
+	// the lines of each host, without the host prefix
+	lines := map[string][]string{}
+	for _, l := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+		host := strings.SplitN(l, " ", 2)[0]
+		if i.Host(host) == nil {
+			t.Fatalf("line not prefixed with a host: \"%s\"", l)
+		}
+		lines[host] = append(lines[host], strings.TrimPrefix(l, host+" "))
+	}
+
+	expect := []string{
+		"┌+ parent ",
+		"│ ┌+ child ",
+		"│ └─ child ─ OK ",
+		"└─ parent ─ OK ",
+	}
+
+	for _, h := range i.Hosts() {
+		got := lines[h.String()]
+		if len(got) != len(expect) {
+			t.Errorf("%s: got %d lines - expect %d:\n%s", h, len(got), len(expect), strings.Join(got, "\n"))
+			continue
+		}
+		for n, prefix := range expect {
+			if !strings.HasPrefix(got[n], prefix) {
+				t.Errorf("%s line %d: got \"%s\" - expect prefix \"%s\"", h, n, got[n], prefix)
+			}
+		}
+	}
+
+	// hosts share the default reporter
+	if New(faketarget.New(t, "gossh")).Reporter != New(faketarget.New(t, "gossh")).Reporter {
+		t.Errorf("expect hosts to share the default reporter")
+	}
+}
+
+func TestJSONReporter(t *testing.T) {
+
+	ctx := context.Background()
+
+	b := &bytes.Buffer{}
+	ft := faketarget.New(t, "gossh")
+	ft.Expect("true")
+	h := New(ft)
+	h.Reporter = NewJSONReporter(b)
+
+	h.Apply(ctx, "rule", ruleFunc(func(ctx context.Context, h *Host) (Status, error) {
+		h.RunCheck(ctx, "true", "", "")
+		return StatusSatisfied, nil
+	}))
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("lines: got %d - expect 3:\n%s", len(lines), b.String())
+	}
+
+	var tests = []struct {
+		kind   string
+		status string
+		cmd    string
+	}{
+		{"EventApplyStart", "", ""},
+		{"EventCheck", "", "true"},
+		{"EventApplyEnd", "StatusSatisfied", ""},
+	}
+
+	for i, test := range tests {
+		t.Run(test.kind, func(t *testing.T) {
+			got := map[string]interface{}{}
+			err := json.Unmarshal([]byte(lines[i]), &got)
+			if err != nil {
+				t.Fatalf("invalid json \"%s\": %v", lines[i], err)
+			}
+
+			if got["kind"] != test.kind || got["name"] != "rule" || got["host"] != "gossh@fake" {
+				t.Errorf("got %v", got)
+			}
+
+			if test.status != "" && got["status"] != test.status {
+				t.Errorf("status: got %v - expect %s", got["status"], test.status)
+			}
+
+			if test.cmd != "" && (got["cmd"] != test.cmd || got["exit_status"] != float64(0)) {
+				t.Errorf("cmd: got %v %v - expect %s 0", got["cmd"], got["exit_status"], test.cmd)
+			}
+		})
+	}
+}
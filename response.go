@@ -13,3 +13,8 @@ func (r Response) Success() bool {
 	// TODO add exitStatuses ...int to allow for including more exit statuses as ok.
 	return r.ExitStatus == 0 || r.ExitStatus == BlockedByValidate
 }
+
+// Blocked reports if the command was blocked, i.e. not run, because changes are not allowed on the host.
+func (r Response) Blocked() bool {
+	return r.ExitStatus == BlockedByValidate
+}
@@ -0,0 +1,59 @@
+package gossh
+
+import (
+	"sync"
+	"time"
+)
+
+// Result is the result of applying a Rule on a Host.
+//
+// Results form a tree. Rules applied from within the Ensure of another rule, e.g. by base.Multi, are Children of that rules Result.
+type Result struct {
+	// Name is the name given to Apply
+	Name string
+	// Rule is the type of the rule, e.g. base.Multi
+	Rule string
+	// Status is the status returned from Apply
+	Status Status
+	// Err is the error returned from Apply, if any
+	Err error
+	// Start and End is when the Apply started and ended
+	Start time.Time
+	End   time.Time
+	// Children are the results of rules applied within the rule
+	Children []*Result
+
+	mu sync.Mutex
+}
+
+// Duration returns the time it took to apply the rule
+func (r *Result) Duration() time.Duration {
+	return r.End.Sub(r.Start)
+}
+
+// add adds c as a child of r
+func (r *Result) add(c *Result) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Children = append(r.Children, c)
+}
+
+// results is a concurrency safe list of top-level results
+type results struct {
+	mu   sync.Mutex
+	list []*Result
+}
+
+// add adds c to the list
+func (r *results) add(c *Result) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.list = append(r.list, c)
+}
+
+// get returns a copy of the list
+func (r *results) get() []*Result {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*Result{}, r.list...)
+}
@@ -0,0 +1,144 @@
+package gossh
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// ErrRolloutStopped is returned, wrapped, from Rollout when a batch exceeds the maximum failure percentage
+var ErrRolloutStopped = errors.New("rollout stopped")
+
+// RolloutOptions controls how a rule is rolled out to the hosts in an Inventory
+type RolloutOptions struct {
+	// ApplyOptions is used when applying to the hosts within a batch
+	ApplyOptions
+
+	// BatchSize is the number of hosts in each batch.
+	BatchSize int
+
+	// BatchPercent is the number of hosts in each batch, as a percentage of all hosts. It is only used if BatchSize is zero.
+	// Batches contain at least one host.
+	//
+	// If neither BatchSize or BatchPercent is set, all hosts are in a single batch.
+	BatchPercent int
+
+	// MaxFailPercent is the percentage of hosts in a batch that can fail before the rollout is stopped.
+	// Zero means that the rollout is stopped if any host fails.
+	MaxFailPercent int
+
+	// PreBatch is applied to all hosts in a batch before the rule, e.g. to drain them from a load balancer.
+	// Hosts where PreBatch fails count as failed, and the rule is not applied to them.
+	PreBatch Rule
+
+	// PostBatch is applied to all hosts in a batch where the rule was applied successfully, e.g. to add them back to a load balancer.
+	// Hosts where PostBatch fails count as failed.
+	PostBatch Rule
+}
+
+// batchSize returns the size of each batch when rolling out to n hosts
+func (o RolloutOptions) batchSize(n int) int {
+	size := n
+	if o.BatchSize > 0 {
+		size = o.BatchSize
+	} else if o.BatchPercent > 0 {
+		size = (n*o.BatchPercent + 99) / 100
+	}
+
+	if size < 1 {
+		size = 1
+	}
+
+	return size
+}
+
+// batches splits hosts into batches of size
+func batches(hosts []*Host, size int) [][]*Host {
+	batches := [][]*Host{}
+	for start := 0; start < len(hosts); start += size {
+		end := start + size
+		if end > len(hosts) {
+			end = len(hosts)
+		}
+		batches = append(batches, hosts[start:end])
+	}
+	return batches
+}
+
+// Rollout applies Rule r to the hosts in i in batches, one batch at a time.
+//
+// Within a batch, PreBatch, r and PostBatch are applied in turn, as by Apply.
+// If the percentage of failed hosts in a batch exceeds opts.MaxFailPercent, the rollout is stopped and an error wrapping ErrRolloutStopped is returned.
+// Hosts in the remaining batches are then in the result with StatusSkipped and NotRun set, so that the result is not OK.
+func (i *Inventory) Rollout(ctx context.Context, name string, r Rule, opts RolloutOptions) (InventoryResult, error) {
+
+	res := map[*Host]HostResult{}
+	hosts := i.Hosts()
+	batches := batches(hosts, opts.batchSize(len(hosts)))
+
+	for n, batch := range batches {
+
+		failed := rolloutBatch(ctx, batch, name, r, opts, res)
+
+		if failed*100 > opts.MaxFailPercent*len(batch) {
+			for _, rest := range batches[n+1:] {
+				for _, h := range rest {
+					res[h] = HostResult{Host: h, Status: StatusSkipped, NotRun: true}
+				}
+			}
+			return ordered(hosts, res), errors.Wrapf(ErrRolloutStopped, "batch %d of %d: %d of %d hosts failed", n+1, len(batches), failed, len(batch))
+		}
+	}
+
+	return ordered(hosts, res), nil
+}
+
+// ordered returns the results in res in the order of hosts
+func ordered(hosts []*Host, res map[*Host]HostResult) InventoryResult {
+	out := InventoryResult{}
+	for _, h := range hosts {
+		if hr, ok := res[h]; ok {
+			out = append(out, hr)
+		}
+	}
+	return out
+}
+
+// rolloutBatch applies PreBatch, r and PostBatch to all hosts in batch, and adds the results to res.
+// The number of failed hosts is returned.
+func rolloutBatch(ctx context.Context, batch []*Host, name string, r Rule, opts RolloutOptions, res map[*Host]HostResult) int {
+
+	failed := 0
+	hosts := batch
+
+	// phase applies rule to hosts, and removes failed hosts from hosts
+	phase := func(name string, rule Rule, main bool) {
+		ok := []*Host{}
+		applied := apply(ctx, hosts, name, rule, opts.ApplyOptions)
+		for n, h := range hosts {
+			hr := applied[n]
+			if !hr.Status.OK() {
+				failed++
+				res[h] = hr
+				continue
+			}
+			if main {
+				res[h] = hr
+			}
+			ok = append(ok, h)
+		}
+		hosts = ok
+	}
+
+	if opts.PreBatch != nil {
+		phase(name+" - pre batch", opts.PreBatch, false)
+	}
+
+	phase(name, r, true)
+
+	if opts.PostBatch != nil {
+		phase(name+" - post batch", opts.PostBatch, false)
+	}
+
+	return failed
+}
@@ -0,0 +1,152 @@
+package gossh
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestBatchSize(t *testing.T) {
+
+	var tests = []struct {
+		hosts  int
+		opts   RolloutOptions
+		expect int
+	}{
+		{10, RolloutOptions{}, 10},
+		{10, RolloutOptions{BatchSize: 3}, 3},
+		{10, RolloutOptions{BatchPercent: 25}, 3},
+		{10, RolloutOptions{BatchPercent: 1}, 1},
+		{10, RolloutOptions{BatchSize: 2, BatchPercent: 50}, 2},
+		{0, RolloutOptions{}, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%d %v", test.hosts, test.opts), func(t *testing.T) {
+			got := test.opts.batchSize(test.hosts)
+			if got != test.expect {
+				t.Errorf("size: got %d - expect %d", got, test.expect)
+			}
+		})
+	}
+}
+
+func TestBatches(t *testing.T) {
+	hosts := newTestInventory(t, 7).Hosts()
+
+	batches := batches(hosts, 3)
+
+	if len(batches) != 3 || len(batches[0]) != 3 || len(batches[2]) != 1 {
+		t.Fatalf("batches: got %v", batches)
+	}
+
+	if batches[2][0] != hosts[6] {
+		t.Errorf("last batch: got %v - expect %v", batches[2][0], hosts[6])
+	}
+}
+
+func TestRollout(t *testing.T) {
+
+	var tests = []struct {
+		name    string
+		fail    []string // hosts where the rule fails
+		opts    RolloutOptions
+		stopped bool
+		skipped int
+		order   string
+	}{
+		{
+			name:  "all ok",
+			opts:  RolloutOptions{BatchSize: 2},
+			order: "pre:host0,pre:host1,main:host0,main:host1,post:host0,post:host1,pre:host2,pre:host3,main:host2,main:host3,post:host2,post:host3",
+		},
+		{
+			name:    "stop on first failure",
+			fail:    []string{"main:host1"},
+			opts:    RolloutOptions{BatchSize: 2},
+			stopped: true,
+			skipped: 2,
+			order:   "pre:host0,pre:host1,main:host0,main:host1,post:host0",
+		},
+		{
+			name:  "failure within threshold",
+			fail:  []string{"main:host1"},
+			opts:  RolloutOptions{BatchSize: 2, MaxFailPercent: 50},
+			order: "pre:host0,pre:host1,main:host0,main:host1,post:host0,pre:host2,pre:host3,main:host2,main:host3,post:host2,post:host3",
+		},
+		{
+			name:    "pre batch failure",
+			fail:    []string{"pre:host0", "pre:host1"},
+			opts:    RolloutOptions{BatchPercent: 50, MaxFailPercent: 50},
+			stopped: true,
+			skipped: 2,
+			order:   "pre:host0,pre:host1",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			mu := sync.Mutex{}
+			order := []string{}
+
+			rule := func(phase string) Rule {
+				return ruleFunc(func(ctx context.Context, h *Host) (Status, error) {
+					id := phase + ":" + strings.TrimPrefix(h.String(), "gossh@")
+					mu.Lock()
+					order = append(order, id)
+					mu.Unlock()
+					for _, f := range test.fail {
+						if f == id {
+							return StatusFailed, fmt.Errorf("%s failed", id)
+						}
+					}
+					return StatusEnforced, nil
+				})
+			}
+
+			opts := test.opts
+			opts.Forks = 1
+			opts.PreBatch = rule("pre")
+			opts.PostBatch = rule("post")
+
+			i := newTestInventory(t, 4)
+			res, err := i.Rollout(context.Background(), "rule", rule("main"), opts)
+
+			if test.stopped != (errors.Cause(err) == ErrRolloutStopped) {
+				t.Errorf("stopped: got %v - expect %v", err, test.stopped)
+			}
+
+			if len(res) != 4 {
+				t.Errorf("results: got %d - expect 4", len(res))
+			}
+
+			skipped := 0
+			for _, hr := range res {
+				if hr.Status == StatusSkipped && hr.NotRun {
+					skipped++
+				}
+			}
+
+			if skipped != test.skipped {
+				t.Errorf("skipped: got %d - expect %d", skipped, test.skipped)
+			}
+
+			if ok := len(test.fail) == 0; res.OK() != ok {
+				t.Errorf("ok: got %v - expect %v", res.OK(), ok)
+			}
+
+			if got, expect := len(res.Failed()), len(test.fail)+test.skipped; got != expect {
+				t.Errorf("failed: got %d - expect %d", got, expect)
+			}
+
+			if got := strings.Join(order, ","); got != test.order {
+				t.Errorf("order:\ngot    %s\nexpect %s", got, test.order)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package apt
+
+import (
+	"context"
+	"testing"
+
+	"github.com/krilor/gossh"
+	"github.com/krilor/gossh/testing/faketarget"
+)
+
+func TestPackage(t *testing.T) {
+
+	query := `dpkg-query -f '${Package}\t${db:Status-Abbrev}\t${Version}\t${Name}' -W curl`
+
+	var tests = []struct {
+		name   string
+		setup  func(ft *faketarget.Target)
+		status PackageStatus
+		expect gossh.Status
+	}{
+		{"installed", func(ft *faketarget.Target) {
+			ft.Expect(query).Returns("curl\tii \t7.64.0\tcurl", "", 0)
+		}, StatusInstalled, gossh.StatusSatisfied},
+		{"not found", func(ft *faketarget.Target) {
+			ft.Expect(query).Returns("", "no packages found matching curl", 1)
+			ft.Expect("apt install -y curl").As("root")
+		}, StatusInstalled, gossh.StatusEnforced},
+		{"install fails", func(ft *faketarget.Target) {
+			ft.Expect(query).Returns("", "no packages found matching curl", 1)
+			ft.Expect("apt install -y curl").As("root").Returns("", "E: Unable to locate package curl", 100)
+		}, StatusInstalled, gossh.StatusFailed},
+		{"remove", func(ft *faketarget.Target) {
+			ft.Expect(query).Returns("curl\tii \t7.64.0\tcurl", "", 0)
+			ft.Expect("apt remove -y curl").As("root")
+		}, StatusNotInstalled, gossh.StatusEnforced},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ft := faketarget.New(t, "gossh")
+			test.setup(ft)
+
+			h := gossh.New(ft)
+			h.Reporter = gossh.NopReporter{}
+
+			s, _ := h.Apply(context.Background(), test.name, Package{Name: "curl", Status: test.status, User: "root"})
+			if s != test.expect {
+				t.Errorf("status: got %s - expect %s", s, test.expect)
+			}
+
+			ft.Verify()
+		})
+	}
+}
@@ -1,7 +1,10 @@
 package base
 
 import (
+	"context"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/krilor/gossh"
 	"github.com/pkg/errors"
@@ -17,9 +20,9 @@ type Cmd struct {
 }
 
 // Ensure simply runs Cmd's CheckCmd, then EnsureCmd
-func (c Cmd) Ensure(h *gossh.Host) (gossh.Status, error) {
+func (c Cmd) Ensure(ctx context.Context, h *gossh.Host) (gossh.Status, error) {
 
-	r, err := h.RunCheck(c.CheckCmd, "", c.User)
+	r, err := h.RunCheck(ctx, c.CheckCmd, "", c.User)
 
 	if err != nil {
 		return gossh.StatusFailed, errors.Wrapf(err, "command %s failed", c.CheckCmd)
@@ -29,27 +32,31 @@ func (c Cmd) Ensure(h *gossh.Host) (gossh.Status, error) {
 		return gossh.StatusSatisfied, nil
 	}
 
-	r, err = h.RunChange(c.EnsureCmd, "", c.User)
+	r, err = h.RunChange(ctx, c.EnsureCmd, "", c.User)
 
 	if err != nil || !r.Success() {
 		return gossh.StatusFailed, errors.Wrapf(err, "command %s failed", c.EnsureCmd)
 	}
 
+	if r.Blocked() {
+		return gossh.StatusNotSatisfied, nil
+	}
+
 	return gossh.StatusEnforced, nil
 }
 
 // Meta is a rule that can be used to write your own rules, on the fly
 type Meta struct {
-	EnsureFunc func(h *gossh.Host) (gossh.Status, error)
+	EnsureFunc func(ctx context.Context, h *gossh.Host) (gossh.Status, error)
 }
 
 // Ensure runs EnsureFunc
-func (ma Meta) Ensure(h *gossh.Host) (gossh.Status, error) {
-	return ma.EnsureFunc(h)
+func (ma Meta) Ensure(ctx context.Context, h *gossh.Host) (gossh.Status, error) {
+	return ma.EnsureFunc(ctx, h)
 }
 
 // NewMeta can be used to create a new Meta rule
-func NewMeta(ensure func(h *gossh.Host) (gossh.Status, error)) Meta {
+func NewMeta(ensure func(ctx context.Context, h *gossh.Host) (gossh.Status, error)) Meta {
 	return Meta{ensure}
 }
 
@@ -63,13 +70,13 @@ type Multi []gossh.Rule
 // Ensure runs Check and Ensure on all rules in the lish.
 //
 // Multi will stop executing and return an error if encountering an error from any Check or Ensure method.
-func (p Multi) Ensure(h *gossh.Host) (gossh.Status, error) {
+func (p Multi) Ensure(ctx context.Context, h *gossh.Host) (gossh.Status, error) {
 
 	var status gossh.Status
 
 	for i, r := range p {
 		name := fmt.Sprintf("multi%d - %v", i, r)
-		s, err := h.Apply(name, r)
+		s, err := h.Apply(ctx, name, r)
 		if s > status {
 			status = s
 		}
@@ -87,3 +94,71 @@ func (p *Multi) Add(r gossh.Rule) {
 	*p = l
 	return
 }
+
+// Parallel is a rule that consists of a list of rules that are applied concurrently
+//
+// Rules in Parallel must not depend on each other.
+type Parallel []gossh.Rule
+
+// Ensure implements Ensurer
+//
+// Ensure applies all rules in the list concurrently and waits for all of them to finish.
+//
+// The returned status is the highest status of the rules. If any rules fail, the error from the first of them in the list is returned.
+func (p Parallel) Ensure(ctx context.Context, h *gossh.Host) (gossh.Status, error) {
+
+	statuses := make([]gossh.Status, len(p))
+	errs := make([]error, len(p))
+
+	wg := sync.WaitGroup{}
+	for i, r := range p {
+		wg.Add(1)
+		go func(i int, r gossh.Rule) {
+			defer wg.Done()
+			name := fmt.Sprintf("parallel%d - %v", i, r)
+			statuses[i], errs[i] = h.Apply(ctx, name, r)
+			if errs[i] != nil {
+				errs[i] = errors.Wrapf(errs[i], "%s - %s failed to apply", name, r)
+			}
+		}(i, r)
+	}
+	wg.Wait()
+
+	var status gossh.Status
+	for i := range p {
+		if statuses[i] > status {
+			status = statuses[i]
+		}
+	}
+
+	for _, err := range errs {
+		if err != nil {
+			return status, err
+		}
+	}
+
+	return status, nil
+}
+
+// Add adds a rule to Parallel p
+func (p *Parallel) Add(r gossh.Rule) {
+	l := append(*p, r)
+	*p = l
+	return
+}
+
+// Timeout is a rule that ensures Rule with a deadline
+//
+// If Rule is not done within Timeout, commands are aborted and an error wrapping context.DeadlineExceeded is returned.
+type Timeout struct {
+	Rule    gossh.Rule
+	Timeout time.Duration
+}
+
+// Ensure runs Ensure on Rule, with a context that times out after Timeout
+func (t Timeout) Ensure(ctx context.Context, h *gossh.Host) (gossh.Status, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.Timeout)
+	defer cancel()
+
+	return t.Rule.Ensure(ctx, h)
+}
@@ -0,0 +1,46 @@
+package base
+
+import (
+	"context"
+
+	"github.com/krilor/gossh"
+	"github.com/pkg/errors"
+)
+
+// Notify is a rule that notifies Handlers when Rule is enforced
+//
+// In check-only mode, the handlers are notified when Rule is not satisfied, so that it is evident what the handlers would do.
+type Notify struct {
+	Rule     gossh.Rule
+	Handlers []string
+}
+
+// Ensure runs Ensure on Rule, and notifies Handlers if Rule was enforced
+func (n Notify) Ensure(ctx context.Context, h *gossh.Host) (gossh.Status, error) {
+
+	s, err := n.Rule.Ensure(ctx, h)
+	if err != nil {
+		return s, err
+	}
+
+	if s == gossh.StatusEnforced || (s == gossh.StatusNotSatisfied && !h.AllowChange) {
+		for _, handler := range n.Handlers {
+			err = h.Notify(handler)
+			if err != nil {
+				return gossh.StatusFailed, errors.Wrap(err, "could not notify")
+			}
+		}
+	}
+
+	return s, nil
+}
+
+// FlushHandlers is a rule that applies all notified handlers on the host
+//
+// It can be used in e.g. Multi to apply handlers before the top-level Apply is done.
+type FlushHandlers struct{}
+
+// Ensure flushes the handlers on h
+func (FlushHandlers) Ensure(ctx context.Context, h *gossh.Host) (gossh.Status, error) {
+	return h.FlushHandlers(ctx)
+}
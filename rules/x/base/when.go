@@ -0,0 +1,124 @@
+package base
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/krilor/gossh"
+	"github.com/pkg/errors"
+)
+
+// Condition decides if a rule should be applied on a host
+//
+// Check reports if the condition is met on h. Description describes the outcome of the check, e.g. "OS is debian".
+// It is used as the reason when rules are skipped.
+type Condition interface {
+	Check(ctx context.Context, h *gossh.Host) (ok bool, description string, err error)
+}
+
+// ConditionFunc is an adapter to allow ordinary functions to be used as Conditions
+type ConditionFunc func(ctx context.Context, h *gossh.Host) (bool, string, error)
+
+// Check calls f
+func (f ConditionFunc) Check(ctx context.Context, h *gossh.Host) (bool, string, error) {
+	return f(ctx, h)
+}
+
+// When is a rule that only ensures Rule if Condition is met
+//
+// If the condition is not met, StatusSkipped is returned and the reason is logged on the host.
+type When struct {
+	Condition Condition
+	Rule      gossh.Rule
+}
+
+// Ensure checks Condition and runs Ensure on Rule if it is met
+func (w When) Ensure(ctx context.Context, h *gossh.Host) (gossh.Status, error) {
+
+	ok, description, err := w.Condition.Check(ctx, h)
+	if err != nil {
+		return gossh.StatusFailed, errors.Wrap(err, "could not check condition")
+	}
+
+	if !ok {
+		h.Log("skipped", "reason", description)
+		return gossh.StatusSkipped, nil
+	}
+
+	return w.Rule.Ensure(ctx, h)
+}
+
+// FactIs returns a Condition that is met if fact has one of values on the host
+func FactIs(fact gossh.Fact, values ...string) Condition {
+	return ConditionFunc(func(ctx context.Context, h *gossh.Host) (bool, string, error) {
+		f, err := h.Facts(ctx)
+		if err != nil {
+			return false, "", err
+		}
+
+		value, _ := f.Get(fact)
+		for _, v := range values {
+			if v == value {
+				return true, fmt.Sprintf("%s is %s", fact, value), nil
+			}
+		}
+
+		return false, fmt.Sprintf("%s is %s, not %s", fact, value, strings.Join(values, " or ")), nil
+	})
+}
+
+// OS returns a Condition that is met if the OS is one of values, e.g. "debian" or "centos"
+func OS(values ...string) Condition {
+	return FactIs(gossh.OS, values...)
+}
+
+// OSFamily returns a Condition that is met if the OS family is one of values, e.g. "debian" or "rhel"
+func OSFamily(values ...string) Condition {
+	return FactIs(gossh.OSFamily, values...)
+}
+
+// OSVersion returns a Condition that is met if the major OS version is one of values, e.g. "7"
+func OSVersion(values ...string) Condition {
+	return FactIs(gossh.OSVersion, values...)
+}
+
+// Not returns a Condition that is met if c is not met
+func Not(c Condition) Condition {
+	return ConditionFunc(func(ctx context.Context, h *gossh.Host) (bool, string, error) {
+		ok, description, err := c.Check(ctx, h)
+		return !ok, "not " + description, err
+	})
+}
+
+// And returns a Condition that is met if all of cs are met.
+// The conditions are checked in order, and checking stops at the first condition that is not met.
+func And(cs ...Condition) Condition {
+	return ConditionFunc(func(ctx context.Context, h *gossh.Host) (bool, string, error) {
+		descriptions := []string{}
+		for _, c := range cs {
+			ok, description, err := c.Check(ctx, h)
+			if err != nil || !ok {
+				return false, description, err
+			}
+			descriptions = append(descriptions, description)
+		}
+		return true, strings.Join(descriptions, " and "), nil
+	})
+}
+
+// Or returns a Condition that is met if any of cs are met.
+// The conditions are checked in order, and checking stops at the first condition that is met.
+func Or(cs ...Condition) Condition {
+	return ConditionFunc(func(ctx context.Context, h *gossh.Host) (bool, string, error) {
+		descriptions := []string{}
+		for _, c := range cs {
+			ok, description, err := c.Check(ctx, h)
+			if err != nil || ok {
+				return ok, description, err
+			}
+			descriptions = append(descriptions, description)
+		}
+		return false, strings.Join(descriptions, " and "), nil
+	})
+}
@@ -0,0 +1,88 @@
+package base
+
+import (
+	"context"
+	"testing"
+
+	"github.com/krilor/gossh"
+	"github.com/krilor/gossh/testing/faketarget"
+)
+
+const debian10 = `==gossh:os-release
+ID=debian
+VERSION_ID="10"
+`
+
+func TestWhen(t *testing.T) {
+
+	yes := ConditionFunc(func(ctx context.Context, h *gossh.Host) (bool, string, error) {
+		return true, "yes", nil
+	})
+
+	no := ConditionFunc(func(ctx context.Context, h *gossh.Host) (bool, string, error) {
+		return false, "no", nil
+	})
+
+	var tests = []struct {
+		name        string
+		condition   Condition
+		expect      gossh.Status
+		description string
+	}{
+		{"os", OS("ubuntu", "debian"), gossh.StatusEnforced, "OS is debian"},
+		{"not os", OS("centos", "fedora"), gossh.StatusSkipped, "OS is debian, not centos or fedora"},
+		{"family", OSFamily("debian"), gossh.StatusEnforced, "OSFamily is debian"},
+		{"version", OSVersion("9"), gossh.StatusSkipped, "OSVersion is 10, not 9"},
+		{"not", Not(no), gossh.StatusEnforced, "not no"},
+		{"and", And(yes, OS("debian")), gossh.StatusEnforced, "yes and OS is debian"},
+		{"and not", And(yes, no, yes), gossh.StatusSkipped, "no"},
+		{"or", Or(no, yes), gossh.StatusEnforced, "yes"},
+		{"or not", Or(no, OSFamily("rhel")), gossh.StatusSkipped, "no and OSFamily is debian, not rhel"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			ft := faketarget.New(t, "test")
+			ft.ExpectRegexp(`release`).Returns(debian10, "", 0)
+			ft.ExpectRegexp(`==gossh:`)
+			ft.Expect("false").Returns("", "", 1)
+			ft.Expect("touch")
+			h := gossh.New(ft)
+			h.Reporter = gossh.NopReporter{}
+
+			_, description, err := test.condition.Check(ctx, h)
+			if err != nil {
+				t.Fatalf("check errored: %v", err)
+			}
+
+			if description != test.description {
+				t.Errorf("description: got \"%s\" - expect \"%s\"", description, test.description)
+			}
+
+			s, err := h.Apply(ctx, test.name, When{
+				Condition: test.condition,
+				Rule:      Cmd{CheckCmd: "false", EnsureCmd: "touch"},
+			})
+
+			if err != nil {
+				t.Fatalf("apply errored: %v", err)
+			}
+
+			if s != test.expect {
+				t.Errorf("status: got %v - expect %v", s, test.expect)
+			}
+
+			cmds := []string{}
+			for _, cmd := range ft.Cmds() {
+				if cmd == "false" || cmd == "touch" {
+					cmds = append(cmds, cmd)
+				}
+			}
+
+			if (len(cmds) > 0) != (test.expect == gossh.StatusEnforced) {
+				t.Errorf("commands: got %v", cmds)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package file
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/krilor/gossh"
@@ -14,34 +15,34 @@ type Exists struct {
 }
 
 // check if file exists
-func (e Exists) check(h *gossh.Host) (bool, error) {
+func (e Exists) check(ctx context.Context, h *gossh.Host) (bool, error) {
 
 	cmd := fmt.Sprintf("stat %s", e.Path)
 
-	r, err := h.RunCheck(cmd, "", e.User)
+	r, err := h.RunCheck(ctx, cmd, "", e.User)
 
 	if err != nil {
 		return false, errors.Wrap(err, "stat errored")
 	}
 
-	if r.ExitStatus != 0 {
-		return false, nil
-	}
-
-	return false, nil
+	return r.ExitStatus == 0, nil
 }
 
 // Ensure that file exists
-func (e Exists) Ensure(h *gossh.Host) (gossh.Status, error) {
+func (e Exists) Ensure(ctx context.Context, h *gossh.Host) (gossh.Status, error) {
 
-	ok, err := e.check(h)
+	ok, err := e.check(ctx, h)
+
+	if err != nil {
+		return gossh.StatusFailed, err
+	}
 
 	if ok {
 		return gossh.StatusSatisfied, nil
 	}
 
 	cmd := fmt.Sprintf("touch %s", e.Path)
-	r, err := h.RunChange(cmd, "", e.User)
+	r, err := h.RunChange(ctx, cmd, "", e.User)
 
 	if err != nil {
 		return gossh.StatusFailed, errors.Wrap(err, "could not ensure file")
@@ -50,5 +51,9 @@ func (e Exists) Ensure(h *gossh.Host) (gossh.Status, error) {
 	if !r.Success() {
 		return gossh.StatusFailed, fmt.Errorf("something went wrong with touch %d", r.ExitStatus)
 	}
+
+	if r.Blocked() {
+		return gossh.StatusNotSatisfied, nil
+	}
 	return gossh.StatusEnforced, nil
 }
@@ -0,0 +1,41 @@
+package file
+
+import (
+	"testing"
+
+	"github.com/krilor/gossh"
+	"github.com/krilor/gossh/testing/faketarget"
+	"github.com/krilor/gossh/testing/gosshtest"
+)
+
+func TestExists(t *testing.T) {
+
+	var tests = []struct {
+		name  string
+		setup func(ft *faketarget.Target)
+	}{
+		{"missing", func(ft *faketarget.Target) {
+			ft.Expect("stat /tmp/file").Returns("", "stat: cannot stat '/tmp/file': No such file or directory", 1).Times(1)
+			ft.Expect("touch /tmp/file").Times(1)
+			ft.Expect("stat /tmp/file").Returns("  File: /tmp/file", "", 0)
+		}},
+		{"exists", func(ft *faketarget.Target) {
+			ft.Expect("stat /tmp/file").Returns("  File: /tmp/file", "", 0)
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ft := faketarget.New(t, "gossh")
+			test.setup(ft)
+
+			h := gossh.New(ft)
+			h.Reporter = gossh.NopReporter{}
+
+			gosshtest.AssertIdempotent(t, Exists{Path: "/tmp/file"}, h)
+			gosshtest.AssertCheckMode(t, Exists{Path: "/tmp/file"}, h)
+
+			ft.Verify()
+		})
+	}
+}
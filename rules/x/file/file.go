@@ -1,6 +1,7 @@
 package file
 
 import (
+	"context"
 	"os"
 
 	"github.com/krilor/gossh"
@@ -24,12 +25,12 @@ func (i Info) Dir() bool {
 }
 
 // Stat returns stat info
-func Stat(h *gossh.Host, abspath string, root bool) (Info, error) {
+func Stat(ctx context.Context, h *gossh.Host, abspath string, root bool) (Info, error) {
 	user := ""
 	if root {
 		user = "root"
 	}
-	_, err := h.RunCheck("stat "+abspath, "", user)
+	_, err := h.RunCheck(ctx, "stat "+abspath, "", user)
 
 	if err != nil {
 		return Info{}, errors.Wrapf(err, "stat %s failed", abspath)
@@ -0,0 +1,196 @@
+// Package lazy provides a target.Target that connects to the underlying target on first use.
+//
+// It is used for inventories, where connecting to all hosts up front is slow and fails on hosts that are never used.
+package lazy
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/krilor/gossh/target"
+	"github.com/krilor/gossh/target/sh"
+	"github.com/pkg/errors"
+)
+
+// ConnectFunc connects to a target. Connecting should be aborted when ctx is done.
+type ConnectFunc func(ctx context.Context) (target.Target, error)
+
+// Target is a target.Target that connects on first use.
+//
+// If connecting fails, the error is returned from the method that was called, and connecting is retried on next use.
+// Views returned from As share the connection. Concurrent calls wait for the same connect.
+type Target struct {
+	*conn
+	activeUser string
+}
+
+// conn is the connection shared by all views of a Target
+type conn struct {
+	mu      sync.Mutex
+	name    string
+	user    string
+	connect ConnectFunc
+	t       target.Target
+	// dialing is the connect in progress, if any
+	dialing *dial
+}
+
+// dial is a connect in progress. Done is closed when it is done, and err is set if it failed.
+type dial struct {
+	done chan struct{}
+	err  error
+	// aborted is true if the connect failed because the ctx of the caller that connected was done
+	aborted bool
+	// closed is true if the Target was closed while connecting
+	closed bool
+}
+
+// New returns a Target named name that connects as user using connect.
+// Name is returned from String, and user from User, without connecting.
+func New(name string, user string, connect ConnectFunc) *Target {
+	return &Target{
+		conn: &conn{
+			name:    name,
+			user:    user,
+			connect: connect,
+		},
+		activeUser: user,
+	}
+}
+
+// get returns the underlying target with the active user of t, connecting if not connected.
+// The lock is not held while connecting, callers that are not connecting wait for the connect or for their ctx to be done.
+func (t *Target) get(ctx context.Context) (target.Target, error) {
+	for {
+		if ctx.Err() != nil {
+			return nil, errors.Wrapf(ctx.Err(), "could not connect to %s", t.name)
+		}
+
+		t.mu.Lock()
+		if t.t != nil {
+			c := t.t.As(t.activeUser)
+			t.mu.Unlock()
+			return c, nil
+		}
+
+		d := t.dialing
+		if d == nil {
+			d = &dial{done: make(chan struct{})}
+			t.dialing = d
+			t.mu.Unlock()
+			t.dial(ctx, d)
+		} else {
+			t.mu.Unlock()
+		}
+
+		select {
+		case <-d.done:
+		case <-ctx.Done():
+			return nil, errors.Wrapf(ctx.Err(), "could not connect to %s", t.name)
+		}
+
+		// connected, or the connect was aborted by the ctx of another caller
+		if d.err == nil || d.aborted {
+			continue
+		}
+
+		return nil, d.err
+	}
+}
+
+// dial connects, and stores the result in t and d
+func (t *Target) dial(ctx context.Context, d *dial) {
+	c, err := t.connect(ctx)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch {
+	case err != nil:
+		d.err = errors.Wrapf(err, "could not connect to %s", t.name)
+		d.aborted = ctx.Err() != nil
+	case d.closed:
+		c.Close()
+		d.err = errors.Errorf("%s was closed while connecting", t.name)
+	default:
+		t.t = c
+	}
+
+	t.dialing = nil
+	close(d.done)
+}
+
+// Connected reports if the underlying target is connected
+func (t *Target) Connected() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.t != nil
+}
+
+// String returns the name of t
+func (t *Target) String() string {
+	return t.name
+}
+
+// Close closes the underlying target if it is connected, or when it is connected if a connect is in progress. It is connected again on next use.
+func (t *Target) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.dialing != nil {
+		t.dialing.closed = true
+	}
+
+	if t.t == nil {
+		return nil
+	}
+
+	err := t.t.Close()
+	t.t = nil
+	return err
+}
+
+// As returns a view of t with user as the active user
+func (t *Target) As(user string) target.Target {
+	return &Target{conn: t.conn, activeUser: user}
+}
+
+// User returns the connected user
+func (t *Target) User() string {
+	return t.user
+}
+
+// ActiveUser returns the active user
+func (t *Target) ActiveUser() string {
+	return t.activeUser
+}
+
+// Run runs cmd on the underlying target
+func (t *Target) Run(ctx context.Context, cmd string, stdin io.Reader) (sh.Result, error) {
+	c, err := t.get(ctx)
+	if err != nil {
+		return sh.Result{ExitStatus: -1}, err
+	}
+	return c.Run(ctx, cmd, stdin)
+}
+
+// Put puts the file on the underlying target
+func (t *Target) Put(ctx context.Context, filename string, data []byte, perm os.FileMode) error {
+	c, err := t.get(ctx)
+	if err != nil {
+		return err
+	}
+	return c.Put(ctx, filename, data, perm)
+}
+
+// Get gets the file from the underlying target
+func (t *Target) Get(ctx context.Context, filename string) ([]byte, error) {
+	c, err := t.get(ctx)
+	if err != nil {
+		return []byte{}, err
+	}
+	return c.Get(ctx, filename)
+}
@@ -0,0 +1,198 @@
+package lazy
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/krilor/gossh/target"
+	"github.com/krilor/gossh/testing/faketarget"
+	"github.com/pkg/errors"
+)
+
+func TestLazy(t *testing.T) {
+	ctx := context.Background()
+
+	ft := faketarget.New(t, "gossh")
+	ft.Expect("whoami").As("root").Returns("root", "", 0)
+
+	connects := 0
+	fail := true
+	l := New("web01", "gossh", func(ctx context.Context) (target.Target, error) {
+		connects++
+		if fail {
+			return nil, fmt.Errorf("connection refused")
+		}
+		return ft, nil
+	})
+
+	if l.String() != "web01" || l.User() != "gossh" || l.ActiveUser() != "gossh" || l.Connected() {
+		t.Errorf("wrong target before connect: %s %s %s %v", l, l.User(), l.ActiveUser(), l.Connected())
+	}
+
+	if connects != 0 {
+		t.Errorf("connected before use")
+	}
+
+	root := l.As("root")
+
+	// failed connects are retried
+	_, err := root.Run(ctx, "whoami", nil)
+	if err == nil {
+		t.Errorf("expect connect error")
+	}
+
+	fail = false
+	res, err := root.Run(ctx, "whoami", nil)
+	if err != nil || res.Stdout.String() != "root" {
+		t.Errorf("run: got %s %v", res.Stdout.String(), err)
+	}
+
+	err = l.Put(ctx, "/tmp/file", []byte("data"), 0644)
+	if err != nil {
+		t.Errorf("put: %v", err)
+	}
+
+	b, err := root.Get(ctx, "/tmp/file")
+	if err != nil || string(b) != "data" {
+		t.Errorf("get: got %s %v", b, err)
+	}
+
+	if connects != 2 || !l.Connected() {
+		t.Errorf("connects: got %d - expect 2", connects)
+	}
+
+	if f, _ := ft.File("/tmp/file"); f.Owner != "gossh" {
+		t.Errorf("put as wrong user: %s", f.Owner)
+	}
+
+	err = root.Close()
+	if err != nil || !ft.Closed() || l.Connected() {
+		t.Errorf("close: %v", err)
+	}
+
+	ft.Verify()
+}
+
+func TestLazyConcurrent(t *testing.T) {
+	ft := faketarget.New(t, "gossh")
+	ft.Expect("true")
+
+	var mu sync.Mutex
+	connects := 0
+	dialing := make(chan struct{})
+	connected := make(chan struct{})
+
+	l := New("web01", "gossh", func(ctx context.Context) (target.Target, error) {
+		mu.Lock()
+		connects++
+		mu.Unlock()
+		close(dialing)
+
+		select {
+		case <-connected:
+			return ft, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	})
+
+	n := 5
+	errs := make([]error, n)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, errs[0] = l.Run(context.Background(), "true", nil)
+	}()
+	<-dialing
+
+	// the lock is not held while connecting
+	if l.Connected() {
+		t.Errorf("connected while dialing")
+	}
+
+	for i := 1; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = l.As("root").Run(context.Background(), "true", nil)
+		}(i)
+	}
+
+	// callers waiting for the connect honor their ctx
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := l.Run(ctx, "true", nil)
+	if errors.Cause(err) != context.DeadlineExceeded {
+		t.Errorf("waiting caller: got %v - expect %v", err, context.DeadlineExceeded)
+	}
+
+	close(connected)
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("caller %d errored: %v", i, err)
+		}
+	}
+
+	if connects != 1 {
+		t.Errorf("connects: got %d - expect 1", connects)
+	}
+}
+
+func TestLazyAborted(t *testing.T) {
+	ft := faketarget.New(t, "gossh")
+	ft.Expect("true")
+
+	dialing := make(chan struct{})
+	first := true
+	l := New("web01", "gossh", func(ctx context.Context) (target.Target, error) {
+		if first {
+			// the first connect blocks until the ctx of the caller is done
+			first = false
+			close(dialing)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return ft, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := l.Run(ctx, "true", nil)
+		done <- err
+	}()
+	<-dialing
+
+	// a waiting caller connects again when the ctx of the caller that connected is done
+	waiter := make(chan error)
+	go func() {
+		_, err := l.Run(context.Background(), "true", nil)
+		waiter <- err
+	}()
+	cancel()
+
+	if err := <-done; errors.Cause(err) != context.Canceled {
+		t.Errorf("cancelled: got %v - expect %v", err, context.Canceled)
+	}
+
+	if err := <-waiter; err != nil {
+		t.Errorf("waiter: %v", err)
+	}
+
+	// a connect in progress when closed is closed when done
+	closing := New("web02", "gossh", func(ctx context.Context) (target.Target, error) {
+		return ft, nil
+	})
+	closing.dialing = &dial{done: make(chan struct{})}
+	closing.Close()
+	closing.dial(context.Background(), closing.dialing)
+	if closing.Connected() || !ft.Closed() {
+		t.Errorf("connect in progress not closed")
+	}
+}
@@ -2,13 +2,18 @@ package local
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/krilor/gossh/target"
 	"github.com/krilor/gossh/target/sh"
 	"github.com/krilor/gossh/target/sh/sudo"
 	"github.com/pkg/errors"
@@ -44,9 +49,11 @@ func (l *Local) Close() error {
 	return nil
 }
 
-// As sets the active user for local
-func (l *Local) As(user string) {
-	l.activeUser = user
+// As returns a new Local with user as the active user. L is not modified.
+func (l *Local) As(user string) target.Target {
+	c := *l
+	c.activeUser = user
+	return &c
 }
 
 // User returns the connected user
@@ -70,16 +77,16 @@ func (l *Local) String() string {
 }
 
 // Run runs cmd
-func (l *Local) Run(cmd string, stdin io.Reader) (sh.Result, error) {
+func (l *Local) Run(ctx context.Context, cmd string, stdin io.Reader) (sh.Result, error) {
 	if l.sudo() {
-		return l.runsudo(cmd, stdin)
+		return l.runsudo(ctx, cmd, stdin)
 	}
 
-	return l.run(cmd, stdin)
+	return l.run(ctx, cmd, stdin)
 }
 
 // runsudo runs cmd as activeUser using sudo
-func (l *Local) runsudo(cmd string, stdin io.Reader) (sh.Result, error) {
+func (l *Local) runsudo(ctx context.Context, cmd string, stdin io.Reader) (sh.Result, error) {
 
 	resp := sh.Result{}
 
@@ -87,12 +94,15 @@ func (l *Local) runsudo(cmd string, stdin io.Reader) (sh.Result, error) {
 	command := exec.Command("sudo", sudo.Args()...)
 
 	var err error
-	command.Stdout = &resp.Stdout
-	command.Stderr = sudo
 	sudo.StdinPipe, err = command.StdinPipe()
 	sudo.Stderr = &resp.Stderr
 
-	err = command.Run()
+	err = runGroup(ctx, command, &resp.Stdout, sudo)
+
+	if ctx.Err() != nil {
+		resp.ExitStatus = -1
+		return resp, errors.Wrapf(ctx.Err(), "command \"%s\" aborted", cmd)
+	}
 
 	if err != nil {
 		if exitError, ok := err.(*exec.ExitError); ok {
@@ -106,19 +116,20 @@ func (l *Local) runsudo(cmd string, stdin io.Reader) (sh.Result, error) {
 	return resp, nil
 }
 
-// runsudo runs cmd as activeUser using sudo
-func (l *Local) run(cmd string, stdin io.Reader) (sh.Result, error) {
+// run runs cmd as the connected user
+func (l *Local) run(ctx context.Context, cmd string, stdin io.Reader) (sh.Result, error) {
 
 	resp := sh.Result{}
 
 	command := exec.Command("bash", "-c", cmd)
-
-	var err error
-	command.Stdout = &resp.Stdout
-	command.Stderr = &resp.Stderr
 	command.Stdin = stdin
 
-	err = command.Run()
+	err := runGroup(ctx, command, &resp.Stdout, &resp.Stderr)
+
+	if ctx.Err() != nil {
+		resp.ExitStatus = -1
+		return resp, errors.Wrapf(ctx.Err(), "command \"%s\" aborted", cmd)
+	}
 
 	if err != nil {
 		if exitError, ok := err.(*exec.ExitError); ok {
@@ -132,12 +143,88 @@ func (l *Local) run(cmd string, stdin io.Reader) (sh.Result, error) {
 	return resp, nil
 }
 
+// waitDelay is how long to wait for a killed command to exit and close its output
+const waitDelay = 500 * time.Millisecond
+
+// runGroup runs c in its own process group, with output to stdout and stderr.
+//
+// If ctx is done before c exits, the whole process group is killed, so that commands started by c, e.g. by bash or sudo, are killed too.
+// The group is sent SIGTERM first, so that sudo can relay it to the command it runs as another user, and then SIGKILL.
+// Processes that are still running after waitDelay, e.g. started by sudo as another user, are abandoned and their output is closed.
+func runGroup(ctx context.Context, c *exec.Cmd, stdout io.Writer, stderr io.Writer) error {
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	// the output is copied from pipes that are closed here, so that processes that outlive c can not block
+	outr, outw, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	errr, errw, err := os.Pipe()
+	if err != nil {
+		outr.Close()
+		outw.Close()
+		return err
+	}
+	c.Stdout = outw
+	c.Stderr = errw
+
+	err = c.Start()
+	outw.Close()
+	errw.Close()
+	if err != nil {
+		outr.Close()
+		errr.Close()
+		return err
+	}
+
+	copied := make(chan struct{})
+	go func() {
+		wg := sync.WaitGroup{}
+		wg.Add(2)
+		go func() { io.Copy(stdout, outr); wg.Done() }()
+		go func() { io.Copy(stderr, errr); wg.Done() }()
+		wg.Wait()
+		close(copied)
+	}()
+
+	exited := make(chan error, 1)
+	go func() { exited <- c.Wait() }()
+
+	pgid := -c.Process.Pid
+
+	select {
+	case err = <-exited:
+		<-copied
+	case <-ctx.Done():
+		syscall.Kill(pgid, syscall.SIGTERM)
+		select {
+		case err = <-exited:
+		case <-time.After(waitDelay):
+			syscall.Kill(pgid, syscall.SIGKILL)
+			err = <-exited
+		}
+		// children that c left behind
+		syscall.Kill(pgid, syscall.SIGKILL)
+
+		select {
+		case <-copied:
+		case <-time.After(waitDelay):
+		}
+	}
+
+	outr.Close()
+	errr.Close()
+	<-copied
+
+	return err
+}
+
 // Put implements target.Put
-func (l *Local) Put(filename string, data []byte, perm os.FileMode) error {
+func (l *Local) Put(ctx context.Context, filename string, data []byte, perm os.FileMode) error {
 	if l.sudo() {
 		cmd := fmt.Sprintf("tee > %s", filename)
 		stdin := bytes.NewBuffer(data)
-		res, err := l.runsudo(cmd, stdin)
+		res, err := l.runsudo(ctx, cmd, stdin)
 
 		if err != nil {
 			return errors.Wrap(err, "tee errored")
@@ -147,7 +234,7 @@ func (l *Local) Put(filename string, data []byte, perm os.FileMode) error {
 		}
 
 		cmd = fmt.Sprintf("chmod %04o %s", perm, filename)
-		res, err = l.runsudo(cmd, stdin)
+		res, err = l.runsudo(ctx, cmd, stdin)
 
 		if err != nil {
 			return errors.Wrap(err, "chmod errored")
@@ -160,6 +247,10 @@ func (l *Local) Put(filename string, data []byte, perm os.FileMode) error {
 
 	}
 
+	if ctx.Err() != nil {
+		return errors.Wrap(ctx.Err(), "put aborted")
+	}
+
 	err := ioutil.WriteFile(filename, data, perm)
 	if err != nil {
 		return errors.Wrap(err, "writefile failed")
@@ -174,10 +265,10 @@ func (l *Local) Put(filename string, data []byte, perm os.FileMode) error {
 }
 
 // Get implements target.Get
-func (l *Local) Get(filename string) ([]byte, error) {
+func (l *Local) Get(ctx context.Context, filename string) ([]byte, error) {
 	if l.sudo() {
 		cmd := fmt.Sprintf("cat %s", filename)
-		res, err := l.runsudo(cmd, nil)
+		res, err := l.runsudo(ctx, cmd, nil)
 		if err != nil {
 			return []byte{}, errors.Wrap(err, "cat failed")
 		}
@@ -187,5 +278,9 @@ func (l *Local) Get(filename string) ([]byte, error) {
 		return res.Stdout.Bytes(), nil
 	}
 
+	if ctx.Err() != nil {
+		return []byte{}, errors.Wrap(ctx.Err(), "get aborted")
+	}
+
 	return ioutil.ReadFile(filename)
 }
@@ -1,6 +1,7 @@
 package local
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -8,8 +9,10 @@ import (
 	"os/exec"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/lithammer/shortuuid"
+	"github.com/pkg/errors"
 )
 
 var testsudopass string
@@ -61,7 +64,7 @@ func TestPut(t *testing.T) {
 
 			l.activeUser = test.activeUser
 
-			err := l.Put(test.path, []byte(test.content), 0644)
+			err := l.Put(context.Background(), test.path, []byte(test.content), 0644)
 			if err != nil {
 				t.Fatal("put errored", err)
 			}
@@ -112,9 +115,9 @@ func TestGet(t *testing.T) {
 
 			l.activeUser = test.activeUser
 
-			l.Put(test.path, []byte(test.content), 0644)
+			l.Put(context.Background(), test.path, []byte(test.content), 0644)
 
-			content, err := l.Get(test.path)
+			content, err := l.Get(context.Background(), test.path)
 			if err != nil {
 				t.Fatal("open errored", err)
 			}
@@ -127,3 +130,48 @@ func TestGet(t *testing.T) {
 	}
 
 }
+
+func TestRunContext(t *testing.T) {
+
+	l, err := New(testsudopass)
+	if err != nil {
+		t.Fatal("could not get local:", err)
+	}
+
+	tests := []struct {
+		activeUser string
+		cmd        string
+	}{
+		{l.user, "sleep 10"},
+		{l.user, "sleep 10; echo done"},
+		{l.user, "sleep 10 | cat"},
+		{"root", "sleep 10"},
+		{"root", "sleep 10; echo done"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.activeUser+" "+test.cmd, func(t *testing.T) {
+
+			l.activeUser = test.activeUser
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+
+			start := time.Now()
+			res, err := l.Run(ctx, test.cmd, nil)
+
+			if errors.Cause(err) != context.DeadlineExceeded {
+				t.Errorf("expected deadline exceeded, got %v", err)
+			}
+
+			// commands started by bash or sudo must not keep the command running
+			if time.Since(start) > 2*time.Second {
+				t.Errorf("command was not killed, returned after %s", time.Since(start))
+			}
+
+			if res.Stdout.String() != "" {
+				t.Errorf("command ran to completion: %s", res.Stdout.String())
+			}
+		})
+	}
+}
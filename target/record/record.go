@@ -0,0 +1,223 @@
+// Package record provides a target.Target that records all calls to another target, and a target that replays them.
+//
+// A recording is made once against a real host, e.g. a staging box, and can then be replayed to regression-test rules offline and deterministically.
+// Recordings are JSON lines, one call per line, preceded by a line describing the recorded target.
+package record
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/krilor/gossh/target"
+	"github.com/krilor/gossh/target/sh"
+	"github.com/pkg/errors"
+)
+
+// Method is the recorded method
+type Method string
+
+// The methods that are recorded
+const (
+	MethodTarget Method = "target"
+	MethodRun    Method = "run"
+	MethodPut    Method = "put"
+	MethodGet    Method = "get"
+)
+
+// Call is a recorded call to a target
+type Call struct {
+	Method Method `json:"method"`
+	// User is the active user of the call
+	User string `json:"user,omitempty"`
+
+	// Target and ConnUser describes the recorded target. They are only set for MethodTarget.
+	Target   string `json:"target,omitempty"`
+	ConnUser string `json:"conn_user,omitempty"`
+
+	// Cmd, Stdin, Stdout, Stderr and ExitStatus are set for MethodRun
+	Cmd        string `json:"cmd,omitempty"`
+	Stdin      string `json:"stdin,omitempty"`
+	Stdout     string `json:"stdout,omitempty"`
+	Stderr     string `json:"stderr,omitempty"`
+	ExitStatus int    `json:"exit_status,omitempty"`
+
+	// Filename and Data are set for MethodPut and MethodGet. Perm is set for MethodPut.
+	Filename string      `json:"filename,omitempty"`
+	Data     []byte      `json:"data,omitempty"`
+	Perm     os.FileMode `json:"perm,omitempty"`
+
+	// Err is the error returned, if any. NotExist is true if the error satisfied os.IsNotExist.
+	Err      string `json:"err,omitempty"`
+	NotExist bool   `json:"not_exist,omitempty"`
+}
+
+// error returns the recorded error of c, or nil
+func (c Call) error() error {
+	if c.NotExist {
+		return &os.PathError{Op: "open", Path: c.Filename, Err: os.ErrNotExist}
+	}
+	if c.Err != "" {
+		return errors.New(c.Err)
+	}
+	return nil
+}
+
+// setError records err on c
+func (c *Call) setError(err error) {
+	if err == nil {
+		return
+	}
+	c.Err = err.Error()
+	c.NotExist = os.IsNotExist(errors.Cause(err))
+}
+
+// Recorder is a target.Target that records all calls to an underlying target.
+//
+// Views returned from As share the recording. A Recorder is safe for concurrent use, but the order of concurrent calls in the recording is not defined.
+type Recorder struct {
+	t   target.Target
+	rec *recording
+}
+
+// recording is the destination of a recording, shared by all views of a Recorder
+type recording struct {
+	mu     sync.Mutex
+	enc    *json.Encoder
+	closer io.Closer
+	err    error
+}
+
+// New returns a Recorder that records all calls to t as JSON lines to w
+func New(t target.Target, w io.Writer) *Recorder {
+	r := &Recorder{
+		t: t,
+		rec: &recording{
+			enc: json.NewEncoder(w),
+		},
+	}
+
+	r.record(Call{Method: MethodTarget, Target: t.String(), ConnUser: t.User()})
+
+	return r
+}
+
+// Create returns a Recorder that records all calls to t to the named file. The file is closed when the Recorder is closed.
+func Create(t target.Target, filename string) (*Recorder, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create recording")
+	}
+
+	r := New(t, f)
+	r.rec.closer = f
+
+	return r, nil
+}
+
+// record writes c to the recording. The first error is kept, and returned from Err.
+func (r *Recorder) record(c Call) {
+	r.rec.mu.Lock()
+	defer r.rec.mu.Unlock()
+
+	err := r.rec.enc.Encode(c)
+	if err != nil && r.rec.err == nil {
+		r.rec.err = errors.Wrap(err, "could not write recording")
+	}
+}
+
+// Err returns the first error that occurred when writing the recording, if any
+func (r *Recorder) Err() error {
+	r.rec.mu.Lock()
+	defer r.rec.mu.Unlock()
+
+	return r.rec.err
+}
+
+// String implements fmt.Stringer
+func (r *Recorder) String() string {
+	return r.t.String()
+}
+
+// Close closes the underlying target, and the recording file if created with Create
+func (r *Recorder) Close() error {
+	err := r.t.Close()
+
+	r.rec.mu.Lock()
+	defer r.rec.mu.Unlock()
+
+	if r.rec.closer != nil {
+		cerr := r.rec.closer.Close()
+		r.rec.closer = nil
+		if err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+// As returns a view of r with user as the active user
+func (r *Recorder) As(user string) target.Target {
+	return &Recorder{t: r.t.As(user), rec: r.rec}
+}
+
+// User returns the connected user
+func (r *Recorder) User() string {
+	return r.t.User()
+}
+
+// ActiveUser returns the active user
+func (r *Recorder) ActiveUser() string {
+	return r.t.ActiveUser()
+}
+
+// Run runs cmd on the underlying target and records the result
+func (r *Recorder) Run(ctx context.Context, cmd string, stdin io.Reader) (sh.Result, error) {
+	c := Call{Method: MethodRun, User: r.t.ActiveUser(), Cmd: cmd}
+
+	if stdin != nil {
+		in, err := ioutil.ReadAll(stdin)
+		if err != nil {
+			return sh.Result{ExitStatus: -1}, errors.Wrap(err, "could not read stdin")
+		}
+		c.Stdin = string(in)
+		stdin = bytes.NewReader(in)
+	}
+
+	res, err := r.t.Run(ctx, cmd, stdin)
+
+	c.Stdout = res.Stdout.String()
+	c.Stderr = res.Stderr.String()
+	c.ExitStatus = res.ExitStatus
+	c.setError(err)
+	r.record(c)
+
+	return res, err
+}
+
+// Put puts the file on the underlying target and records the result
+func (r *Recorder) Put(ctx context.Context, filename string, data []byte, perm os.FileMode) error {
+	err := r.t.Put(ctx, filename, data, perm)
+
+	c := Call{Method: MethodPut, User: r.t.ActiveUser(), Filename: filename, Data: data, Perm: perm}
+	c.setError(err)
+	r.record(c)
+
+	return err
+}
+
+// Get gets the file from the underlying target and records the result
+func (r *Recorder) Get(ctx context.Context, filename string) ([]byte, error) {
+	data, err := r.t.Get(ctx, filename)
+
+	c := Call{Method: MethodGet, User: r.t.ActiveUser(), Filename: filename, Data: data}
+	c.setError(err)
+	r.record(c)
+
+	return data, err
+}
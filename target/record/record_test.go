@@ -0,0 +1,280 @@
+package record
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/krilor/gossh/target/sh"
+	"github.com/krilor/gossh/testing/faketarget"
+)
+
+// recordSession records a short session against a fake target
+func recordSession(t *testing.T) *bytes.Buffer {
+	ctx := context.Background()
+
+	fake := faketarget.New(t, "gossh")
+	fake.Expect("whoami").As("root").Returns("root\n", "", 0)
+	fake.Expect("whoami").Returns("gossh\n", "", 0)
+	fake.Expect("cat").Do(func(cmd string, stdin string) (sh.Result, error) {
+		r := sh.Result{}
+		r.Stdout.WriteString(stdin)
+		return r, nil
+	})
+	fake.Expect("false").Returns("", "failed", 1)
+
+	buf := &bytes.Buffer{}
+	r := New(fake, buf)
+
+	r.Run(ctx, "whoami", nil)
+	r.As("root").Run(ctx, "whoami", nil)
+	r.Run(ctx, "cat", strings.NewReader("hello"))
+	r.Run(ctx, "false", nil)
+	r.As("root").Put(ctx, "/etc/motd", []byte("welcome"), 0644)
+	r.As("root").Get(ctx, "/etc/motd")
+	r.Get(ctx, "/nope")
+
+	if err := r.Err(); err != nil {
+		t.Fatalf("recording errored: %v", err)
+	}
+
+	if err := r.Close(); err != nil || !fake.Closed() {
+		t.Fatalf("target not closed: %v", err)
+	}
+
+	return buf
+}
+
+func TestRecordReplayInOrder(t *testing.T) {
+	ctx := context.Background()
+
+	rp, err := Replay(recordSession(t), InOrder)
+	if err != nil {
+		t.Fatalf("replay errored: %v", err)
+	}
+
+	if rp.String() != "gossh@fake" || rp.User() != "gossh" || rp.ActiveUser() != "gossh" {
+		t.Errorf("wrong target: %s %s %s", rp.String(), rp.User(), rp.ActiveUser())
+	}
+
+	res, err := rp.Run(ctx, "whoami", nil)
+	if err != nil || res.TrimOut() != "gossh" {
+		t.Errorf("whoami: got %s %v", res.TrimOut(), err)
+	}
+
+	root := rp.As("root")
+	res, err = root.Run(ctx, "whoami", nil)
+	if err != nil || res.TrimOut() != "root" {
+		t.Errorf("whoami as root: got %s %v", res.TrimOut(), err)
+	}
+
+	res, err = rp.Run(ctx, "cat", strings.NewReader("hello"))
+	if err != nil || res.Stdout.String() != "hello" {
+		t.Errorf("cat: got %s %v", res.Stdout.String(), err)
+	}
+
+	res, err = rp.Run(ctx, "false", nil)
+	if err != nil || res.ExitStatus != 1 || res.TrimErr() != "failed" {
+		t.Errorf("false: got %d %s %v", res.ExitStatus, res.TrimErr(), err)
+	}
+
+	err = root.Put(ctx, "/etc/motd", []byte("welcome"), 0644)
+	if err != nil {
+		t.Errorf("put: %v", err)
+	}
+
+	b, err := root.Get(ctx, "/etc/motd")
+	if err != nil || string(b) != "welcome" {
+		t.Errorf("get: got %s %v", b, err)
+	}
+
+	_, err = rp.Get(ctx, "/nope")
+	if !os.IsNotExist(err) {
+		t.Errorf("get missing: expect not exist error, got %v", err)
+	}
+
+	if len(rp.Unused()) != 0 {
+		t.Errorf("unused calls: %v", rp.Unused())
+	}
+
+	_, err = rp.Run(ctx, "whoami", nil)
+	if err == nil {
+		t.Errorf("expect error when recording is done")
+	}
+}
+
+func TestReplayInOrderMismatch(t *testing.T) {
+	ctx := context.Background()
+
+	rp, err := Replay(recordSession(t), InOrder)
+	if err != nil {
+		t.Fatalf("replay errored: %v", err)
+	}
+
+	var tests = []struct {
+		call func() error
+		err  bool
+	}{
+		{func() error { _, err := rp.As("root").Run(ctx, "whoami", nil); return err }, true}, // wrong user
+		{func() error { _, err := rp.Run(ctx, "false", nil); return err }, true},             // wrong command
+		{func() error { _, err := rp.Run(ctx, "whoami", nil); return err }, false},
+	}
+
+	for i, test := range tests {
+		err := test.call()
+		if (err != nil) != test.err {
+			t.Errorf("%d: expect error %v, got %v", i, test.err, err)
+		}
+	}
+
+	if len(rp.Unused()) != 6 {
+		t.Errorf("expect 6 unused calls, got %d", len(rp.Unused()))
+	}
+}
+
+func TestReplayByMatch(t *testing.T) {
+	ctx := context.Background()
+
+	rp, err := Replay(recordSession(t), ByMatch)
+	if err != nil {
+		t.Fatalf("replay errored: %v", err)
+	}
+
+	res, err := rp.Run(ctx, "false", nil)
+	if err != nil || res.ExitStatus != 1 {
+		t.Errorf("false: got %d %v", res.ExitStatus, err)
+	}
+
+	res, err = rp.As("root").Run(ctx, "whoami", nil)
+	if err != nil || res.TrimOut() != "root" {
+		t.Errorf("whoami as root: got %s %v", res.TrimOut(), err)
+	}
+
+	res, err = rp.Run(ctx, "whoami", nil)
+	if err != nil || res.TrimOut() != "gossh" {
+		t.Errorf("whoami: got %s %v", res.TrimOut(), err)
+	}
+
+	// each recorded call is replayed once
+	_, err = rp.Run(ctx, "whoami", nil)
+	if err == nil {
+		t.Errorf("expect error on second replay of whoami")
+	}
+
+	_, err = rp.Run(ctx, "ls", nil)
+	if err == nil {
+		t.Errorf("expect error on unrecorded command")
+	}
+
+	if len(rp.Unused()) != 4 {
+		t.Errorf("expect 4 unused calls, got %d", len(rp.Unused()))
+	}
+}
+
+func TestReplayDiffers(t *testing.T) {
+	ctx := context.Background()
+
+	var tests = []struct {
+		name   string
+		call   func(rp *Replayer) error
+		expect []string
+	}{
+		{
+			name:   "put data",
+			call:   func(rp *Replayer) error { return rp.As("root").Put(ctx, "/etc/motd", []byte("welcome back"), 0644) },
+			expect: []string{`data: differs at byte 7: recorded "" (7 bytes), got " back" (12 bytes)`},
+		},
+		{
+			name:   "put perm",
+			call:   func(rp *Replayer) error { return rp.As("root").Put(ctx, "/etc/motd", []byte("welcome"), 0600) },
+			expect: []string{"perm: recorded 0644, got 0600"},
+		},
+		{
+			name: "put data and perm",
+			call: func(rp *Replayer) error { return rp.As("root").Put(ctx, "/etc/motd", []byte("goodbye"), 0600) },
+			expect: []string{
+				`data: differs at byte 0: recorded "welcome" (7 bytes), got "goodbye" (7 bytes)`,
+				"perm: recorded 0644, got 0600",
+			},
+		},
+		{
+			name: "run stdin",
+			call: func(rp *Replayer) error {
+				_, err := rp.Run(ctx, "cat", strings.NewReader("help"))
+				return err
+			},
+			expect: []string{`stdin: differs at byte 3: recorded "lo" (5 bytes), got "p" (4 bytes)`},
+		},
+	}
+
+	modes := map[string]Mode{"in order": InOrder, "by match": ByMatch}
+
+	for name, mode := range modes {
+		for _, test := range tests {
+			t.Run(name+" "+test.name, func(t *testing.T) {
+				rp, err := Replay(recordSession(t), mode)
+				if err != nil {
+					t.Fatalf("replay errored: %v", err)
+				}
+
+				if mode == InOrder {
+					// skip to the call under test
+					rp.Run(ctx, "whoami", nil)
+					rp.As("root").Run(ctx, "whoami", nil)
+					if !strings.HasPrefix(test.name, "run") {
+						rp.Run(ctx, "cat", strings.NewReader("hello"))
+						rp.Run(ctx, "false", nil)
+					}
+				}
+
+				err = test.call(rp)
+				if err == nil {
+					t.Fatalf("expect error")
+				}
+
+				for _, e := range test.expect {
+					if !strings.Contains(err.Error(), e) {
+						t.Errorf("expect error to contain %s, got %v", e, err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestCreateOpen(t *testing.T) {
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "gossh-record")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "session.jsonl")
+
+	fake := faketarget.New(t, "gossh")
+	fake.Expect("uptime").Returns("up 3 days", "", 0)
+
+	r, err := Create(fake, filename)
+	if err != nil {
+		t.Fatalf("create errored: %v", err)
+	}
+	r.Run(ctx, "uptime", nil)
+	if err := r.Close(); err != nil {
+		t.Fatalf("close errored: %v", err)
+	}
+
+	rp, err := Open(filename, InOrder)
+	if err != nil {
+		t.Fatalf("open errored: %v", err)
+	}
+
+	res, err := rp.Run(ctx, "uptime", nil)
+	if err != nil || res.Stdout.String() != "up 3 days" {
+		t.Errorf("uptime: got %s %v", res.Stdout.String(), err)
+	}
+}
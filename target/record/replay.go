@@ -0,0 +1,293 @@
+package record
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/krilor/gossh/target"
+	"github.com/krilor/gossh/target/sh"
+	"github.com/pkg/errors"
+)
+
+// Mode controls how a Replayer finds the recorded call to replay
+type Mode int
+
+const (
+	// InOrder replays the calls in the order they were recorded. A call that does not match the next recorded call is an error.
+	InOrder Mode = iota
+	// ByMatch replays the first unused recorded call that matches the method, active user and command or filename.
+	ByMatch
+)
+
+// In both modes, the stdin of commands and the data and permissions of files put must also be as recorded.
+// A call that differs only in those is an error with the differences, so that changes in what rules writes are caught.
+
+// Replayer is a target.Target that replays a recording made by Recorder.
+//
+// Views returned from As share the recording. A Replayer is safe for concurrent use.
+type Replayer struct {
+	*replay
+	activeUser string
+}
+
+// replay is the state shared by all views of a Replayer
+type replay struct {
+	mu       sync.Mutex
+	mode     Mode
+	target   string
+	connUser string
+	calls    []Call
+	used     []bool
+	next     int
+}
+
+// Replay returns a Replayer that replays the recording read from r
+func Replay(r io.Reader, mode Mode) (*Replayer, error) {
+	rp := &replay{mode: mode}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+
+	for n := 1; scanner.Scan(); n++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		c := Call{}
+		err := json.Unmarshal(scanner.Bytes(), &c)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not parse line %d of recording", n)
+		}
+
+		if c.Method == MethodTarget {
+			rp.target = c.Target
+			rp.connUser = c.ConnUser
+			continue
+		}
+
+		rp.calls = append(rp.calls, c)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "could not read recording")
+	}
+
+	rp.used = make([]bool, len(rp.calls))
+
+	return &Replayer{replay: rp, activeUser: rp.connUser}, nil
+}
+
+// Open returns a Replayer that replays the recording in the named file
+func Open(filename string, mode Mode) (*Replayer, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open recording")
+	}
+	defer f.Close()
+
+	return Replay(f, mode)
+}
+
+// find returns the recorded call that matches want, and marks it as used
+func (rp *replay) find(want Call) (Call, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	matches := func(c Call) bool {
+		return c.Method == want.Method && c.User == want.User && c.Cmd == want.Cmd && c.Filename == want.Filename
+	}
+
+	if rp.mode == InOrder {
+		if rp.next >= len(rp.calls) {
+			return Call{}, fmt.Errorf("replay: unexpected %s, recording is done", describe(want))
+		}
+
+		c := rp.calls[rp.next]
+		if !matches(c) {
+			return Call{}, fmt.Errorf("replay: unexpected %s, expected %s", describe(want), describe(c))
+		}
+
+		if d := diff(c, want); d != "" {
+			return Call{}, fmt.Errorf("replay: %s differs from recording:%s", describe(want), d)
+		}
+
+		rp.used[rp.next] = true
+		rp.next++
+		return c, nil
+	}
+
+	// the first recorded call that differs only in payload, for the error if there is no exact match
+	differs := ""
+
+	for i, c := range rp.calls {
+		if rp.used[i] || !matches(c) {
+			continue
+		}
+
+		d := diff(c, want)
+		if d == "" {
+			rp.used[i] = true
+			return c, nil
+		}
+
+		if differs == "" {
+			differs = d
+		}
+	}
+
+	if differs != "" {
+		return Call{}, fmt.Errorf("replay: %s differs from recording:%s", describe(want), differs)
+	}
+
+	return Call{}, fmt.Errorf("replay: no recorded %s", describe(want))
+}
+
+// diff returns the differences in stdin, data and perm between the recorded call and the actual call, one per line. Empty if there are none.
+func diff(recorded Call, actual Call) string {
+	d := ""
+
+	if recorded.Stdin != actual.Stdin {
+		d += "\n\tstdin: " + diffValues(recorded.Stdin, actual.Stdin)
+	}
+
+	if recorded.Method == MethodPut && !bytes.Equal(recorded.Data, actual.Data) {
+		d += "\n\tdata: " + diffValues(string(recorded.Data), string(actual.Data))
+	}
+
+	if recorded.Method == MethodPut && recorded.Perm != actual.Perm {
+		d += fmt.Sprintf("\n\tperm: recorded %#o, got %#o", recorded.Perm, actual.Perm)
+	}
+
+	return d
+}
+
+// maxDiffLen is the maximum length of values shown in diffs
+const maxDiffLen = 40
+
+// diffValues describes the difference between the recorded and the actual value, starting from the first byte that differs
+func diffValues(recorded string, actual string) string {
+	n := 0
+	for n < len(recorded) && n < len(actual) && recorded[n] == actual[n] {
+		n++
+	}
+
+	excerpt := func(s string) string {
+		s = s[n:]
+		if len(s) > maxDiffLen {
+			return fmt.Sprintf("%q...", s[:maxDiffLen])
+		}
+		return fmt.Sprintf("%q", s)
+	}
+
+	return fmt.Sprintf("differs at byte %d: recorded %s (%d bytes), got %s (%d bytes)", n, excerpt(recorded), len(recorded), excerpt(actual), len(actual))
+}
+
+// describe returns a short description of c for errors
+func describe(c Call) string {
+	if c.Method == MethodRun {
+		return fmt.Sprintf("run of \"%s\" as %s", c.Cmd, c.User)
+	}
+	return fmt.Sprintf("%s of %s as %s", c.Method, c.Filename, c.User)
+}
+
+// Unused returns the recorded calls that has not been replayed, in recorded order
+func (r *Replayer) Unused() []Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	unused := []Call{}
+	for i, c := range r.calls {
+		if !r.used[i] {
+			unused = append(unused, c)
+		}
+	}
+	return unused
+}
+
+// String returns the recorded targets String
+func (r *Replayer) String() string {
+	return r.target
+}
+
+// Close does nothing
+func (r *Replayer) Close() error {
+	return nil
+}
+
+// As returns a view of r with user as the active user
+func (r *Replayer) As(user string) target.Target {
+	return &Replayer{replay: r.replay, activeUser: user}
+}
+
+// User returns the recorded connected user
+func (r *Replayer) User() string {
+	return r.connUser
+}
+
+// ActiveUser returns the active user
+func (r *Replayer) ActiveUser() string {
+	return r.activeUser
+}
+
+// Run returns the recorded result of cmd. Stdin must be as recorded.
+func (r *Replayer) Run(ctx context.Context, cmd string, stdin io.Reader) (sh.Result, error) {
+	if ctx.Err() != nil {
+		return sh.Result{ExitStatus: -1}, errors.Wrapf(ctx.Err(), "command \"%s\" aborted", cmd)
+	}
+
+	want := Call{Method: MethodRun, User: r.activeUser, Cmd: cmd}
+
+	if stdin != nil {
+		in, err := ioutil.ReadAll(stdin)
+		if err != nil {
+			return sh.Result{ExitStatus: -1}, errors.Wrap(err, "could not read stdin")
+		}
+		want.Stdin = string(in)
+	}
+
+	c, err := r.find(want)
+	if err != nil {
+		return sh.Result{ExitStatus: -1}, err
+	}
+
+	res := sh.Result{ExitStatus: c.ExitStatus}
+	res.Stdout.WriteString(c.Stdout)
+	res.Stderr.WriteString(c.Stderr)
+
+	return res, c.error()
+}
+
+// Put returns the recorded result of putting filename. Data and perm must be as recorded.
+func (r *Replayer) Put(ctx context.Context, filename string, data []byte, perm os.FileMode) error {
+	if ctx.Err() != nil {
+		return errors.Wrap(ctx.Err(), "put aborted")
+	}
+
+	c, err := r.find(Call{Method: MethodPut, User: r.activeUser, Filename: filename, Data: data, Perm: perm})
+	if err != nil {
+		return err
+	}
+
+	return c.error()
+}
+
+// Get returns the recorded contents of filename
+func (r *Replayer) Get(ctx context.Context, filename string) ([]byte, error) {
+	if ctx.Err() != nil {
+		return []byte{}, errors.Wrap(ctx.Err(), "get aborted")
+	}
+
+	c, err := r.find(Call{Method: MethodGet, User: r.activeUser, Filename: filename})
+	if err != nil {
+		return []byte{}, err
+	}
+
+	return c.Data, c.error()
+}
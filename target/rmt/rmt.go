@@ -2,12 +2,17 @@ package rmt
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
 	"os"
 	"path/filepath"
+	"sync"
 
+	"github.com/krilor/gossh/target"
+	"github.com/krilor/gossh/target/rmt/sshconfig"
 	"github.com/krilor/gossh/target/rmt/suftp"
 	"github.com/krilor/gossh/target/sh"
 	"github.com/krilor/gossh/target/sh/sudo"
@@ -33,67 +38,305 @@ type Remote struct {
 	// the user currently operating as
 	activeUser string
 
-	// sftp holds all sftp connections. key is username. Pointer?
-	sftp map[string]*sftp.Client
+	// sftp holds all sftp connections, shared by all views of the Remote returned from As
+	sftp *sftpClients
+
+	// jumps are the connections to the jump hosts conn is tunneled through, in the order they were connected to
+	jumps []*ssh.Client
+}
+
+// sftpClients is a concurrency safe collection of sftp clients. Key is username.
+type sftpClients struct {
+	mu      sync.Mutex
+	clients map[string]*sftpConn
+}
+
+// sftpConn is a sftp client that is being, or has been, set up.
+// Ready is closed when the setup is done, and c or err is set.
+type sftpConn struct {
+	ready chan struct{}
+	c     *sftp.Client
+	err   error
+	// aborted is true if the setup failed because the ctx of the caller that did the setup was done
+	aborted bool
 }
 
 // New returns a new Remote target from connection details
 func New(addr string, user string, sudopass string, hostkeycallback ssh.HostKeyCallback, auths ...ssh.AuthMethod) (*Remote, error) {
+	return NewViaContext(context.Background(), nil, addr, user, sudopass, hostkeycallback, auths...)
+}
+
+// NewContext is like New, but connecting is aborted when ctx is done
+func NewContext(ctx context.Context, addr string, user string, sudopass string, hostkeycallback ssh.HostKeyCallback, auths ...ssh.AuthMethod) (*Remote, error) {
+	return NewViaContext(ctx, nil, addr, user, sudopass, hostkeycallback, auths...)
+}
+
+// Jump is a jump host, i.e. a host that is connected through to reach another host, like ProxyJump in OpenSSH
+type Jump struct {
+	// Addr is the address of the jump host, i.e. host:port
+	Addr string
+	// User is the user to connect to the jump host as
+	User string
+	// Auths are the auth methods of User on the jump host
+	Auths []ssh.AuthMethod
+}
+
+// NewVia returns a new Remote target from connection details, connected to through jumps in order.
+// The host keys of the jump hosts are also checked using hostkeycallback.
+func NewVia(jumps []Jump, addr string, user string, sudopass string, hostkeycallback ssh.HostKeyCallback, auths ...ssh.AuthMethod) (*Remote, error) {
+	return NewViaContext(context.Background(), jumps, addr, user, sudopass, hostkeycallback, auths...)
+}
+
+// NewViaContext is like NewVia, but connecting is aborted when ctx is done.
+// Ctx is only used while connecting, the Remote is not closed when ctx is done.
+func NewViaContext(ctx context.Context, jumps []Jump, addr string, user string, sudopass string, hostkeycallback ssh.HostKeyCallback, auths ...ssh.AuthMethod) (*Remote, error) {
 
 	r := Remote{
 		addr:       addr,
 		connuser:   user,
 		sudopass:   sudopass,
 		activeUser: user,
-		sftp:       map[string]*sftp.Client{},
+		sftp:       &sftpClients{clients: map[string]*sftpConn{}},
 	}
 
-	cc := ssh.ClientConfig{
-		User:            user,
-		Auth:            auths,
-		HostKeyCallback: hostkeycallback,
+	hops := append(append([]Jump{}, jumps...), Jump{Addr: addr, User: user, Auths: auths})
+
+	for n, hop := range hops {
+		cc := ssh.ClientConfig{
+			User:            hop.User,
+			Auth:            hop.Auths,
+			HostKeyCallback: hostkeycallback,
+		}
+
+		var via *ssh.Client
+		if n > 0 {
+			via = r.jumps[n-1]
+		}
+
+		conn, err := dial(ctx, via, hop.Addr, &cc)
+
+		if err != nil {
+			r.closeJumps()
+			if n < len(jumps) {
+				return &r, errors.Wrapf(err, "unable to establish ssh connection to jump host %s", hop.Addr)
+			}
+			return &r, errors.Wrapf(err, "unable to establish ssh connection to %s", addr)
+		}
+
+		if n < len(jumps) {
+			r.jumps = append(r.jumps, conn)
+		} else {
+			r.conn = conn
+		}
 	}
 
+	return &r, nil
+
+}
+
+// dial returns a ssh connection to addr, tunneled through the connection via if it is not nil.
+// The connection is closed if ctx is done before the ssh handshake is done.
+func dial(ctx context.Context, via *ssh.Client, addr string, cc *ssh.ClientConfig) (*ssh.Client, error) {
+	var nc net.Conn
 	var err error
-	r.conn, err = ssh.Dial("tcp", addr, &cc)
+
+	if via == nil {
+		var d net.Dialer
+		nc, err = d.DialContext(ctx, "tcp", addr)
+	} else {
+		nc, err = via.Dial("tcp", addr)
+	}
 	if err != nil {
-		return &r, errors.Wrapf(err, "unable to establish ssh connection to %s", addr)
+		return nil, err
 	}
 
-	return &r, nil
+	handshaked := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			nc.Close()
+		case <-handshaked:
+		}
+	}()
+
+	conn, chans, reqs, err := ssh.NewClientConn(nc, addr, cc)
+	close(handshaked)
 
+	if err == nil && ctx.Err() != nil {
+		conn.Close()
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+
+	return ssh.NewClient(conn, chans, reqs), nil
+}
+
+// closeJumps closes the connections to the jump hosts, in reverse order
+func (r *Remote) closeJumps() {
+	for n := len(r.jumps) - 1; n >= 0; n-- {
+		r.jumps[n].Close()
+	}
+}
+
+// NewFromSSHConfig returns a new Remote target for the host alias, with the connection settings of alias in c, e.g. ~/.ssh/config, see sshconfig.Default.
+// ProxyJump is followed, and the IdentityFiles of each host are used for authentication before auths, see IdentityAuths.
+func NewFromSSHConfig(c *sshconfig.Config, alias string, sudopass string, hostkeycallback ssh.HostKeyCallback, auths ...ssh.AuthMethod) (*Remote, error) {
+	h, err := c.Get(alias)
+	if err != nil {
+		return nil, err
+	}
+
+	jumps, err := SSHConfigJumps(c, alias, auths...)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewVia(jumps, h.Addr(), h.User, sudopass, hostkeycallback, append(IdentityAuths(h.IdentityFiles...), auths...)...)
+}
+
+// SSHConfigJumps returns the jump hosts of the host alias in c, see sshconfig.Config.Jumps.
+// The IdentityFiles of each jump host are used for authentication before auths, see IdentityAuths.
+func SSHConfigJumps(c *sshconfig.Config, alias string, auths ...ssh.AuthMethod) ([]Jump, error) {
+	hosts, err := c.Jumps(alias)
+	if err != nil {
+		return nil, err
+	}
+
+	jumps := []Jump{}
+	for _, h := range hosts {
+		jumps = append(jumps, Jump{
+			Addr:  h.Addr(),
+			User:  h.User,
+			Auths: append(IdentityAuths(h.IdentityFiles...), auths...),
+		})
+	}
+
+	return jumps, nil
 }
 
 // Close closes all underlying connections
 func (r *Remote) Close() error {
-	for _, c := range r.sftp {
-		c.Close()
+	r.sftp.mu.Lock()
+	for u, s := range r.sftp.clients {
+		select {
+		case <-s.ready:
+			if s.c != nil {
+				s.c.Close()
+			}
+		default:
+			// the setup fails when conn is closed below
+		}
+		delete(r.sftp.clients, u)
 	}
+	r.sftp.mu.Unlock()
+
+	err := r.conn.Close()
+	r.closeJumps()
 
-	return r.conn.Close()
+	return err
 }
 
 // sftpClient returns a sftp client for r.activeUser
 // if client does not exist, it will be created
-func (r *Remote) sftpClient() (*sftp.Client, error) {
+//
+// The lock is only held to look up and store clients. Concurrent callers for the same user wait for a single setup, or until their ctx is done.
+func (r *Remote) sftpClient(ctx context.Context) (*sftp.Client, error) {
+	for {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		r.sftp.mu.Lock()
+		s, ok := r.sftp.clients[r.activeUser]
+		if !ok {
+			s = &sftpConn{ready: make(chan struct{})}
+			r.sftp.clients[r.activeUser] = s
+		}
+		r.sftp.mu.Unlock()
+
+		if !ok {
+			r.setupSftp(ctx, s)
+		}
+
+		select {
+		case <-s.ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		// the setup was aborted by another caller, so it is retried
+		if s.aborted && ctx.Err() == nil {
+			continue
+		}
+
+		return s.c, s.err
+	}
+}
+
+// setupSftp sets up the sftp client s for r.activeUser, and closes s.ready when done.
+// If the setup fails, s is removed from the clients, so that the next caller tries again.
+func (r *Remote) setupSftp(ctx context.Context, s *sftpConn) {
+	defer close(s.ready)
+
 	var c *sftp.Client
 	var err error
-	var ok bool
-	c, ok = r.sftp[r.activeUser]
-	if ok {
-		return c, nil
-	}
-	// need to create a new connection
 	if r.sudo() {
-		c, err = suftp.NewSudoClient(r.conn, r.activeUser, r.sudopass)
+		c, err = suftp.NewSudoClient(ctx, r.conn, r.activeUser, r.sudopass)
 	} else {
 		c, err = sftp.NewClient(r.conn)
 	}
+
+	r.sftp.mu.Lock()
+	defer r.sftp.mu.Unlock()
+
+	if err == nil && r.sftp.clients[r.activeUser] != s {
+		// r was closed during the setup
+		c.Close()
+		err = errors.New("connection closed")
+	}
+
 	if err != nil {
-		return nil, errors.Wrapf(err, "could not start sftp connection for %s", r.activeUser)
+		if r.sftp.clients[r.activeUser] == s {
+			delete(r.sftp.clients, r.activeUser)
+		}
+		s.err = errors.Wrapf(err, "could not start sftp connection for %s", r.activeUser)
+		s.aborted = ctx.Err() != nil
+		return
+	}
+
+	s.c = c
+}
+
+// withSftp runs f with the sftp client for r.activeUser.
+//
+// The client is shared by all views of r with the same active user, so it is left open if ctx is done before f returns.
+// Only this call is aborted: ctx.Err() is returned at once, and f is expected to give up its request on the next check of ctx.
+func (r *Remote) withSftp(ctx context.Context, f func(c *sftp.Client) error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	c, err := r.sftpClient(ctx)
+	if err != nil {
+		return errors.Wrap(err, "could not get sftp client")
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- f(c)
+	}()
+
+	select {
+	case err = <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-	r.sftp[r.activeUser] = c
-	return c, nil
 }
 
 // sudo reports if operations must be done using sudo, i.e. if active user is not the connected user.
@@ -102,11 +345,13 @@ func (r *Remote) sudo() bool {
 }
 
 // As returns a new Remote that will use the same underlying connections, but all operations will be done as user.
+// R is not modified.
 //
 // No tests are done in this method. If user does not exist or does not have sudo rights, that will only be evident when trying to use methods on the returned object.
-func (r *Remote) As(user string) {
-	r.activeUser = user
-	return
+func (r *Remote) As(user string) target.Target {
+	c := *r
+	c.activeUser = user
+	return &c
 }
 
 // User returns the connected user
@@ -126,15 +371,42 @@ func (r *Remote) String() string {
 
 // Run executes cmd on Remote with the currently active user and returns the response.
 // Reader stdin is used to add stdin.
-func (r *Remote) Run(cmd string, stdin io.Reader) (sh.Result, error) {
+//
+// If ctx is done before cmd completes, the remote process is sent SIGKILL and the session is closed.
+func (r *Remote) Run(ctx context.Context, cmd string, stdin io.Reader) (sh.Result, error) {
 	if r.sudo() {
-		return r.runsudo(cmd, stdin)
+		return r.runsudo(ctx, cmd, stdin)
+	}
+	return r.run(ctx, cmd, stdin)
+}
+
+// wait starts cmd on session and waits for it to complete.
+//
+// If ctx is done before cmd completes, the remote process is sent SIGKILL, the session is closed and ctx.Err() is returned.
+// Not all sshd's honor signals, but closing the session will at least hang up the process.
+func wait(ctx context.Context, session *ssh.Session, cmd string) error {
+	err := session.Start(cmd)
+	if err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Wait()
+	}()
+
+	select {
+	case err = <-done:
+		return err
+	case <-ctx.Done():
+		session.Signal(ssh.SIGKILL)
+		session.Close()
+		return ctx.Err()
 	}
-	return r.run(cmd, stdin)
 }
 
 // run run cmd on remote
-func (r *Remote) run(cmd string, stdin io.Reader) (sh.Result, error) {
+func (r *Remote) run(ctx context.Context, cmd string, stdin io.Reader) (sh.Result, error) {
 	session, err := r.conn.NewSession()
 	resp := sh.Result{}
 
@@ -147,7 +419,12 @@ func (r *Remote) run(cmd string, stdin io.Reader) (sh.Result, error) {
 	session.Stderr = &resp.Stderr
 	session.Stdin = stdin
 
-	err = session.Run(cmd)
+	err = wait(ctx, session, cmd)
+
+	if ctx.Err() != nil {
+		// the session might still be writing to resp, so a fresh result is returned
+		return sh.Result{ExitStatus: -1}, errors.Wrapf(ctx.Err(), "command \"%s\" aborted", cmd)
+	}
 
 	if err != nil {
 
@@ -168,7 +445,7 @@ func (r *Remote) run(cmd string, stdin io.Reader) (sh.Result, error) {
 }
 
 // runsudo runs cmd on Remote as sudo / activeUser
-func (r *Remote) runsudo(cmd string, stdin io.Reader) (sh.Result, error) {
+func (r *Remote) runsudo(ctx context.Context, cmd string, stdin io.Reader) (sh.Result, error) {
 
 	session, err := r.conn.NewSession()
 	resp := sh.Result{}
@@ -185,7 +462,12 @@ func (r *Remote) runsudo(cmd string, stdin io.Reader) (sh.Result, error) {
 	sudo.StdinPipe, err = session.StdinPipe()
 	sudo.Stderr = &resp.Stderr
 
-	err = session.Run(sudo.Cmd())
+	err = wait(ctx, session, sudo.Cmd())
+
+	if ctx.Err() != nil {
+		// the session might still be writing to resp, so a fresh result is returned
+		return sh.Result{ExitStatus: -1}, errors.Wrapf(ctx.Err(), "command \"%s\" aborted", cmd)
+	}
 
 	if err != nil {
 
@@ -210,25 +492,32 @@ func (r *Remote) runsudo(cmd string, stdin io.Reader) (sh.Result, error) {
 }
 
 // Put implements target.Put
-func (r *Remote) Put(filename string, data []byte, perm os.FileMode) error {
-	sftp, err := r.sftpClient()
-	if err != nil {
-		return errors.Wrap(err, "could not get sftp client")
+func (r *Remote) Put(ctx context.Context, filename string, data []byte, perm os.FileMode) error {
+	err := r.withSftp(ctx, func(c *sftp.Client) error {
+		return put(ctx, c, filename, data, perm)
+	})
+	if ctx.Err() != nil {
+		return errors.Wrap(ctx.Err(), "put aborted")
 	}
+	return err
+}
 
+// put writes data to filename using sftp client c.
+// Data is written in chunks, and put gives up between chunks if ctx is done.
+func put(ctx context.Context, sftp *sftp.Client, filename string, data []byte, perm os.FileMode) error {
 	f, err := sftp.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
 	if err != nil {
 		return errors.Wrap(err, "unable to open file")
 	}
 	defer f.Close()
 
-	n, err := f.Write(data)
+	n, err := io.Copy(f, ctxReader{ctx: ctx, r: bytes.NewReader(data)})
 
 	if err != nil {
 		return errors.Wrap(err, "write error")
 	}
 
-	if n != len(data) {
+	if n != int64(len(data)) {
 		return fmt.Errorf("wrote %d of %d bytes to file", n, len(data))
 	}
 
@@ -242,12 +531,22 @@ func (r *Remote) Put(filename string, data []byte, perm os.FileMode) error {
 }
 
 // Get retrieves the contents of the named
-func (r *Remote) Get(filename string) ([]byte, error) {
-	sftp, err := r.sftpClient()
-	if err != nil {
-		return nil, errors.Wrap(err, "could not get sftp client")
+func (r *Remote) Get(ctx context.Context, filename string) ([]byte, error) {
+	var b []byte
+	err := r.withSftp(ctx, func(c *sftp.Client) error {
+		var err error
+		b, err = get(ctx, c, filename)
+		return err
+	})
+	if ctx.Err() != nil {
+		return []byte{}, errors.Wrap(ctx.Err(), "get aborted")
 	}
+	return b, err
+}
 
+// get reads filename using sftp client c.
+// The file is read in chunks, and get gives up between chunks if ctx is done.
+func get(ctx context.Context, sftp *sftp.Client, filename string) ([]byte, error) {
 	b := &bytes.Buffer{}
 
 	f, err := sftp.Open(filename)
@@ -256,7 +555,7 @@ func (r *Remote) Get(filename string) ([]byte, error) {
 	}
 	defer f.Close()
 
-	_, err = f.WriteTo(b)
+	_, err = io.Copy(b, ctxReader{ctx: ctx, r: f})
 	if err != nil {
 		return b.Bytes(), errors.Wrap(err, "reading failed")
 	}
@@ -264,6 +563,20 @@ func (r *Remote) Get(filename string) ([]byte, error) {
 	return b.Bytes(), nil
 }
 
+// ctxReader is an io.Reader that fails with ctx.Err() once ctx is done
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+// Read implements io.Reader
+func (c ctxReader) Read(p []byte) (int, error) {
+	if c.ctx.Err() != nil {
+		return 0, c.ctx.Err()
+	}
+	return c.r.Read(p)
+}
+
 // scput puts the contents of a Reader on a path on the Remote machine, using scp
 // TODO - consider (re)moving this
 //
@@ -302,6 +615,48 @@ func (r *Remote) scput(content io.Reader, size int64, path string, mode uint32)
 	return nil
 }
 
+// KeyFileAuth is a helper function to use the private key in filename for authentication.
+// Encrypted keys are not supported; use AgentAuths for those.
+func KeyFileAuth(filename string) (ssh.AuthMethod, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read private key")
+	}
+
+	signer, err := ssh.ParsePrivateKey(b)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not parse private key %s", filename)
+	}
+
+	return ssh.PublicKeys(signer), nil
+}
+
+// IdentityAuths is a helper function to use the private keys in filenames for authentication, e.g. the IdentityFiles of an ssh config.
+// Keys that does not exist, can not be read or are encrypted are skipped, like in OpenSSH when the key is in an ssh agent.
+func IdentityAuths(filenames ...string) []ssh.AuthMethod {
+	signers := []ssh.Signer{}
+
+	for _, f := range filenames {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			continue
+		}
+
+		signer, err := ssh.ParsePrivateKey(b)
+		if err != nil {
+			continue
+		}
+
+		signers = append(signers, signer)
+	}
+
+	if len(signers) == 0 {
+		return nil
+	}
+
+	return []ssh.AuthMethod{ssh.PublicKeys(signers...)}
+}
+
 // AgentAuths is a helper function to get SSH keys from an ssh agent.
 // If any errors occur, an empty PublicKeys ssh.AuthMethod will be returned.
 func AgentAuths() ssh.AuthMethod {
@@ -1,27 +1,59 @@
 package rmt
 
 import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"net"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/krilor/gossh/target/rmt/sshconfig"
 	"github.com/krilor/gossh/testing/docker"
+	"github.com/krilor/gossh/testing/sshtest"
+	"github.com/pkg/errors"
+	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
 )
 
-var containers []*docker.Container
+// testHost is a host the tests are run against, either a docker container or an sshtest server
+type testHost interface {
+	Port() int
+	Image() string
+	Home(user string) string
+	Exec(cmd string) (string, string, int, error)
+}
+
+var containers []testHost
+
+var useDocker = flag.Bool("docker", false, "also run tests against throwaway docker containers")
 
 func TestMain(m *testing.M) {
 
-	// need to parse flags for testing.Short()
+	// need to parse flags for testing.Short() and -docker
 	flag.Parse()
 
 	// setup
+	server, err := sshtest.New(nil)
+	if err != nil {
+		log.Fatal("unable to start ssh test server", err.Error())
+	}
+	containers = append(containers, server)
+
 	var imgs []docker.Image
-	if testing.Short() {
+	if !*useDocker {
+		imgs = nil
+	} else if testing.Short() {
 		imgs = docker.Bench
 	} else {
 		imgs = docker.FullBench
@@ -43,13 +75,28 @@ func TestMain(m *testing.M) {
 	// teardown
 	for _, c := range containers {
 		log.Println("killing container:", c.Image())
-		c.Kill()
+		switch c := c.(type) {
+		case *docker.Container:
+			c.Kill()
+		case *sshtest.Server:
+			c.Close()
+		}
 	}
 
 	os.Exit(code)
 
 }
 
+// owner returns the owner of path on c. The sshtest server emulates the owner of files created over sftp.
+func owner(c testHost, path string) string {
+	if s, ok := c.(*sshtest.Server); ok {
+		o, _ := s.Owner(path)
+		return o
+	}
+	o, _, _, _ := c.Exec("stat --format='%U' " + path)
+	return o
+}
+
 func TestRun(t *testing.T) {
 
 	type resp struct {
@@ -118,7 +165,7 @@ func TestRun(t *testing.T) {
 			},
 		},
 		{
-			cmd:  `ls -l /root | grep total | awk '{print $1}'`,
+			cmd:  `ls -l HOME_ROOT | grep total | awk '{print $1}'`,
 			sudo: true,
 			expect: resp{
 				Stdout:     "total",
@@ -151,7 +198,8 @@ func TestRun(t *testing.T) {
 				if test.user != "" {
 					r.activeUser = test.user
 				}
-				got, err := r.Run(test.cmd, strings.NewReader(test.stdin))
+				cmd := strings.ReplaceAll(test.cmd, "HOME_ROOT", c.Home("root"))
+				got, err := r.Run(context.Background(), cmd, strings.NewReader(test.stdin))
 				if err != nil {
 					t.Errorf("errored: %v", err)
 				}
@@ -159,7 +207,8 @@ func TestRun(t *testing.T) {
 				if got.TrimOut() != test.expect.Stdout {
 					t.Errorf("stdout: got \"%s\" - expect \"%s\"", got.Stdout.String(), test.expect.Stdout)
 				}
-				if got.TrimErr() != test.expect.Stderr {
+				// bash >= 5.1 includes the line number in errors
+				if strings.Replace(got.TrimErr(), "bash: line 1: ", "bash: ", 1) != test.expect.Stderr {
 					t.Errorf("stderr: got \"%s\" - expect \"%s\"", got.Stderr.String(), test.expect.Stderr)
 				}
 				if got.ExitStatus != test.expect.ExitStatus {
@@ -209,25 +258,29 @@ func TestAs(t *testing.T) {
 		connuser:   "jon",
 		activeUser: "jon",
 	}
-	original.As("root")
+	super := original.As("root").(*Remote)
+
+	if super.activeUser != "root" {
+		t.Errorf("super.activeUser error: expect 'root', got '%s'", super.activeUser)
+	}
 
-	if original.activeUser != "root" {
-		t.Errorf("super.activeUser error: expect 'root', got '%s'", original.activeUser)
+	if super.connuser != "jon" {
+		t.Errorf("super.connuser error: expect 'jon', got '%s'", super.connuser)
 	}
 
-	if original.connuser != "jon" {
-		t.Errorf("original.connuser error: expect 'jon', got '%s'", original.activeUser)
+	if original.activeUser != "jon" {
+		t.Errorf("original.activeUser error: expect 'jon', got '%s'", original.activeUser)
 	}
 }
 
 func TestPut(t *testing.T) {
 	var tests = []struct {
-		path    string
+		path    string // relative to the users home
 		user    string
 		content string
 	}{
-		{"/home/gossh/testcreate", "gossh", "filecontent\ntwo lines"},
-		{"/home/stinky/testcreate", "stinky", "filecontent\nthree\n lines"},
+		{"testcreate", "gossh", "filecontent\ntwo lines"},
+		{"testcreate", "stinky", "filecontent\nthree\n lines"},
 	}
 
 	for _, c := range containers {
@@ -237,23 +290,23 @@ func TestPut(t *testing.T) {
 			log.Fatal("could not connect to throwaway container:", err)
 		}
 		for _, test := range tests {
-			t.Run(test.path+c.Image(), func(t *testing.T) {
+			path := c.Home(test.user) + "/" + test.path
+			t.Run(fmt.Sprintf("%s %s %s", c.Image(), test.user, test.path), func(t *testing.T) {
 
 				r.activeUser = test.user
 
-				err := r.Put(test.path, []byte(test.content), 0644)
+				err := r.Put(context.Background(), path, []byte(test.content), 0644)
 				if err != nil {
 					t.Fatal("could not create file", err)
 				}
 
-				out, _, _, _ := c.Exec(fmt.Sprintf("cat %s", test.path))
+				out, _, _, _ := c.Exec(fmt.Sprintf("cat %s", path))
 				if out != test.content {
 					t.Errorf(`wrong content: expect "%s", got "%s"`, test.content, out)
 				}
 
-				out, _, _, _ = c.Exec("stat --format='%U' " + test.path)
-				if out != test.user {
-					t.Errorf("wrong ownership. got %s", out)
+				if o := owner(c, path); o != test.user {
+					t.Errorf("wrong ownership. got %s", o)
 				}
 
 			})
@@ -263,12 +316,12 @@ func TestPut(t *testing.T) {
 
 func TestGet(t *testing.T) {
 	var tests = []struct {
-		path    string
+		path    string // relative to the users home
 		user    string
 		content string
 	}{
-		{"/home/gossh/testopen", "gossh", "filecontent"},
-		{"/home/stinky/testopen", "stinky", "filecontent"},
+		{"testopen", "gossh", "filecontent"},
+		{"testopen", "stinky", "filecontent"},
 	}
 
 	for _, c := range containers {
@@ -278,13 +331,14 @@ func TestGet(t *testing.T) {
 			log.Fatal("could not connect to throwaway container:", err)
 		}
 		for _, test := range tests {
-			t.Run(test.path+c.Image(), func(t *testing.T) {
+			path := c.Home(test.user) + "/" + test.path
+			t.Run(fmt.Sprintf("%s %s %s", c.Image(), test.user, test.path), func(t *testing.T) {
 
 				r.activeUser = test.user
 
-				c.Exec(fmt.Sprintf("echo -n \"%s\" > %s && chown %s:%s %s %% && chmod 600 %s", test.content, test.path, test.user, test.user, test.path, test.path))
+				c.Exec(fmt.Sprintf("echo -n \"%s\" > %s && chown %s:%s %s %% && chmod 600 %s", test.content, path, test.user, test.user, path, path))
 
-				b, err := r.Get(test.path)
+				b, err := r.Get(context.Background(), path)
 				if err != nil {
 					t.Fatal("could not open file", err)
 				}
@@ -298,3 +352,261 @@ func TestGet(t *testing.T) {
 		}
 	}
 }
+
+// writeKey writes a new private key to a file in dir, and returns the path and the public key
+func writeKey(t *testing.T, dir string) (string, ssh.PublicKey) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "id_test")
+	err = ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path, signer.PublicKey()
+}
+
+func TestNewFromSSHConfig(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "rmt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyfile, pub := writeKey(t, dir)
+
+	jump, err := sshtest.New(map[string]sshtest.User{"jumper": {Keys: []ssh.PublicKey{pub}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jump.Close()
+
+	server, err := sshtest.New(map[string]sshtest.User{"gossh": {Keys: []ssh.PublicKey{pub}, Password: "gosshpwd", Sudo: sshtest.SudoPassword}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	config := fmt.Sprintf(`
+Host target
+    HostName 127.0.0.1
+    Port %d
+    ProxyJump jumphost
+
+Host direct
+    HostName 127.0.0.1
+    Port %d
+    IdentityFile /nonexistent/id_missing
+
+Host badjump
+    HostName 127.0.0.1
+    Port %d
+    ProxyJump jumper@127.0.0.1:1
+
+Host jumphost
+    HostName 127.0.0.1
+    Port %d
+    User jumper
+
+Host *
+    User gossh
+    IdentityFile %s
+`, server.Port(), server.Port(), server.Port(), jump.Port(), keyfile)
+
+	c, err := sshconfig.Parse(strings.NewReader(config))
+	if err != nil {
+		t.Fatalf("parse errored: %v", err)
+	}
+
+	var tests = []struct {
+		alias   string
+		jumped  bool
+		connect bool
+	}{
+		{"target", true, true},
+		{"direct", false, true},
+		{"badjump", false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.alias, func(t *testing.T) {
+			before := len(jump.Forwarded())
+
+			r, err := NewFromSSHConfig(c, test.alias, "gosshpwd", ssh.InsecureIgnoreHostKey())
+			if !test.connect {
+				if err == nil {
+					t.Errorf("expect error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("connect errored: %v", err)
+			}
+			defer r.Close()
+
+			if r.User() != "gossh" {
+				t.Errorf("user: got %s - expect gossh", r.User())
+			}
+
+			res, err := r.As("root").Run(context.Background(), "printf hello", nil)
+			if err != nil || res.TrimOut() != "hello" {
+				t.Errorf("run: got %s %v", res.Stdout.String(), err)
+			}
+
+			forwarded := jump.Forwarded()[before:]
+			if test.jumped && (len(forwarded) != 1 || forwarded[0] != server.Addr()) {
+				t.Errorf("expect connection through jump host, got forwards %v", forwarded)
+			}
+			if !test.jumped && len(forwarded) != 0 {
+				t.Errorf("expect direct connection, got forwards %v", forwarded)
+			}
+		})
+	}
+}
+
+func TestWithSftpCancel(t *testing.T) {
+	for _, c := range containers {
+		t.Run(c.Image(), func(t *testing.T) {
+			r, err := New(fmt.Sprintf("localhost:%d", c.Port()), "gossh", "gosshpwd", ssh.InsecureIgnoreHostKey(), ssh.Password("gosshpwd"))
+			if err != nil {
+				t.Fatal("could not connect:", err)
+			}
+			defer r.Close()
+
+			path := c.Home("gossh") + "/testcancel"
+			other := r.As("gossh")
+
+			ctx, cancel := context.WithCancel(context.Background())
+			block := make(chan struct{})
+			defer close(block)
+
+			var shared *sftp.Client
+			err = r.withSftp(ctx, func(c *sftp.Client) error {
+				shared = c
+				cancel()
+				<-block
+				return nil
+			})
+			if err != context.Canceled {
+				t.Errorf("cancelled call: got %v - expect %v", err, context.Canceled)
+			}
+
+			// the shared client must still be open and used by other calls
+			if _, err := shared.Getwd(); err != nil {
+				t.Errorf("shared client closed: %v", err)
+			}
+
+			err = other.Put(context.Background(), path, []byte("content"), 0644)
+			if err != nil {
+				t.Fatalf("put after cancel errored: %v", err)
+			}
+
+			b, err := other.Get(context.Background(), path)
+			if err != nil || string(b) != "content" {
+				t.Errorf("get after cancel: got %q, %v - expect %q", b, err, "content")
+			}
+		})
+	}
+}
+
+func TestSftpClientConcurrent(t *testing.T) {
+	for _, c := range containers {
+		t.Run(c.Image(), func(t *testing.T) {
+			r, err := New(fmt.Sprintf("localhost:%d", c.Port()), "gossh", "gosshpwd", ssh.InsecureIgnoreHostKey(), ssh.Password("gosshpwd"))
+			if err != nil {
+				t.Fatal("could not connect:", err)
+			}
+			defer r.Close()
+
+			// a caller whose ctx is done gets ctx.Err(), without disturbing the setup for the others
+			cancelled, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			n := 5
+			clients := make([]*sftp.Client, n)
+			errs := make([]error, n)
+			wg := sync.WaitGroup{}
+			for i := 0; i < n; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					clients[i], errs[i] = r.As("stinky").(*Remote).sftpClient(context.Background())
+				}(i)
+			}
+			_, err = r.As("stinky").(*Remote).sftpClient(cancelled)
+			if err != context.Canceled {
+				t.Errorf("cancelled caller: got %v - expect %v", err, context.Canceled)
+			}
+			wg.Wait()
+
+			for i := 0; i < n; i++ {
+				if errs[i] != nil {
+					t.Fatalf("caller %d errored: %v", i, errs[i])
+				}
+				if clients[i] != clients[0] {
+					t.Errorf("caller %d got another client", i)
+				}
+			}
+		})
+	}
+}
+
+func TestNewContext(t *testing.T) {
+	// a server that accepts connections, but never does the ssh handshake
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal("could not listen:", err)
+	}
+	defer l.Close()
+
+	go func() {
+		conns := []net.Conn{}
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				break
+			}
+			conns = append(conns, c)
+		}
+		for _, c := range conns {
+			c.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err = NewContext(ctx, l.Addr().String(), "gossh", "", ssh.InsecureIgnoreHostKey(), ssh.Password("gosshpwd"))
+	if errors.Cause(err) != context.DeadlineExceeded {
+		t.Errorf("got %v - expect %v", err, context.DeadlineExceeded)
+	}
+
+	for _, c := range containers {
+		t.Run(c.Image(), func(t *testing.T) {
+			r, err := NewContext(context.Background(), fmt.Sprintf("localhost:%d", c.Port()), "gossh", "gosshpwd", ssh.InsecureIgnoreHostKey(), ssh.Password("gosshpwd"))
+			if err != nil {
+				t.Fatal("could not connect:", err)
+			}
+			defer r.Close()
+
+			res, err := r.Run(context.Background(), "echo connected", nil)
+			if err != nil || res.Stdout.String() != "connected\n" {
+				t.Errorf("run: got %q %v", res.Stdout.String(), err)
+			}
+		})
+	}
+}
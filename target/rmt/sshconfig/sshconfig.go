@@ -0,0 +1,505 @@
+// Package sshconfig parses OpenSSH client config files, e.g. ~/.ssh/config, for the connection settings of hosts.
+//
+// The supported keywords are Host, Include, HostName, Port, User, IdentityFile and ProxyJump. Other keywords are ignored, as are Match blocks.
+// Like OpenSSH, the first value obtained for a keyword is used, so host specific blocks should come before wildcard blocks, e.g. Host *.
+package sshconfig
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// maxDepth is the maximum depth of nested Include and ProxyJump, to guard against loops
+const maxDepth = 16
+
+// Config is a parsed OpenSSH client config
+type Config struct {
+	blocks []block
+}
+
+// block is a Host block, or the lines before the first Host line, which applies to all hosts
+type block struct {
+	// patterns are the host patterns of the block. Nil means all hosts.
+	patterns []string
+	// match reports if the block is a Match block, which is ignored
+	match  bool
+	params []param
+}
+
+// param is a keyword and its arguments
+type param struct {
+	key  string
+	args []string
+}
+
+// Host is the connection settings of a host
+type Host struct {
+	// Alias is the name the host was looked up with
+	Alias string
+	// HostName is the real hostname or IP address. Defaults to Alias.
+	HostName string
+	// Port is the SSH port. Defaults to 22.
+	Port int
+	// User is the user to connect as. Defaults to the current user.
+	User string
+	// IdentityFiles are the paths to the private keys to authenticate with, in order, with ~ and tokens expanded
+	IdentityFiles []string
+	// ProxyJump are the jump hosts to connect through, as [user@]host[:port] separated by commas, or none. See Config.Jumps.
+	ProxyJump string
+}
+
+// Addr returns the address of h, i.e. host:port
+func (h Host) Addr() string {
+	return net.JoinHostPort(h.HostName, strconv.Itoa(h.Port))
+}
+
+// Default returns the config in ~/.ssh/config, or an empty config if it does not exist
+func Default() (*Config, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get home dir")
+	}
+
+	c, err := Load(filepath.Join(home, ".ssh", "config"))
+	if os.IsNotExist(errors.Cause(err)) {
+		return &Config{}, nil
+	}
+
+	return c, err
+}
+
+// Load parses the config file filename. Relative paths in Include are relative to the directory of filename.
+func Load(filename string) (*Config, error) {
+	c := &Config{}
+
+	err := c.load(filename, filepath.Dir(filename), nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Parse parses a config. Relative paths in Include are relative to ~/.ssh, like for the user config in OpenSSH.
+func Parse(r io.Reader) (*Config, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get home dir")
+	}
+
+	c := &Config{}
+
+	err = c.parse(r, "config", filepath.Join(home, ".ssh"), nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// load parses the file filename, see parse
+func (c *Config) load(filename string, dir string, patterns []string, depth int) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return errors.Wrap(err, "could not open ssh config")
+	}
+	defer f.Close()
+
+	return c.parse(f, filename, dir, patterns, depth)
+}
+
+// parse adds the blocks in r to c. Lines before the first Host line gets patterns, i.e. those of the block with the Include, if any.
+func (c *Config) parse(r io.Reader, name string, dir string, patterns []string, depth int) error {
+	if depth > maxDepth {
+		return fmt.Errorf("%s: too deeply nested includes", name)
+	}
+
+	c.blocks = append(c.blocks, block{patterns: patterns})
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		key, args, err := splitLine(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("%s line %d: %v", name, n, err)
+		}
+
+		if key == "" {
+			continue
+		}
+
+		if len(args) == 0 {
+			return fmt.Errorf("%s line %d: missing argument to %s", name, n, key)
+		}
+
+		switch key {
+		case "host":
+			c.blocks = append(c.blocks, block{patterns: args})
+		case "match":
+			c.blocks = append(c.blocks, block{match: true})
+		case "include":
+			cur := c.blocks[len(c.blocks)-1]
+			if cur.match {
+				continue
+			}
+
+			for _, arg := range args {
+				arg = ExpandHome(arg)
+				if !filepath.IsAbs(arg) {
+					arg = filepath.Join(dir, arg)
+				}
+
+				files, err := filepath.Glob(arg)
+				if err != nil {
+					return fmt.Errorf("%s line %d: invalid include %s: %v", name, n, arg, err)
+				}
+
+				for _, f := range files {
+					err = c.load(f, dir, cur.patterns, depth+1)
+					if err != nil {
+						return err
+					}
+				}
+			}
+
+			// lines after the include are in the same block as before it
+			c.blocks = append(c.blocks, block{patterns: cur.patterns})
+		default:
+			cur := &c.blocks[len(c.blocks)-1]
+			cur.params = append(cur.params, param{key: key, args: args})
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return errors.Wrapf(err, "could not read %s", name)
+	}
+
+	return nil
+}
+
+// splitLine splits a config line into its lowercased keyword and arguments. Arguments can be quoted with double quotes.
+// The keyword can be separated from the arguments by whitespace or =. Empty lines and comments returns an empty keyword.
+func splitLine(line string) (string, []string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' {
+		return "", nil, nil
+	}
+
+	end := strings.IndexAny(line, " \t=")
+	if end == -1 {
+		return strings.ToLower(line), nil, nil
+	}
+
+	key := strings.ToLower(line[:end])
+	rest := strings.TrimSpace(line[end:])
+	rest = strings.TrimSpace(strings.TrimPrefix(rest, "="))
+
+	args := []string{}
+	arg := strings.Builder{}
+	quoted := false
+	inArg := false
+
+	for _, r := range rest {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			inArg = true
+		case !quoted && (r == ' ' || r == '\t'):
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if quoted {
+		return "", nil, fmt.Errorf("unterminated quote in %s", line)
+	}
+
+	if inArg {
+		args = append(args, arg.String())
+	}
+
+	return key, args, nil
+}
+
+// Get returns the settings of the host alias. Settings that are not in the config gets default values.
+func (c *Config) Get(alias string) (Host, error) {
+	h := Host{Alias: alias}
+
+	set := map[string]bool{}
+
+	for _, b := range c.blocks {
+		if b.match || !matches(b.patterns, alias) {
+			continue
+		}
+
+		for _, p := range b.params {
+			if p.key == "identityfile" {
+				h.IdentityFiles = append(h.IdentityFiles, p.args[0])
+				continue
+			}
+
+			if set[p.key] {
+				continue
+			}
+
+			switch p.key {
+			case "hostname":
+				h.HostName = p.args[0]
+			case "port":
+				port, err := strconv.Atoi(p.args[0])
+				if err != nil || port <= 0 || port > 65535 {
+					return h, fmt.Errorf("invalid port %s for %s", p.args[0], alias)
+				}
+				h.Port = port
+			case "user":
+				h.User = p.args[0]
+			case "proxyjump":
+				h.ProxyJump = p.args[0]
+			default:
+				continue
+			}
+
+			set[p.key] = true
+		}
+	}
+
+	if h.HostName == "" {
+		h.HostName = alias
+	}
+	h.HostName = expandTokens(h.HostName, map[byte]string{'h': alias})
+
+	if h.Port == 0 {
+		h.Port = 22
+	}
+
+	local := ""
+	if u, err := user.Current(); err == nil {
+		local = u.Username
+	}
+
+	if h.User == "" {
+		h.User = local
+	}
+
+	home, _ := os.UserHomeDir()
+
+	for i, f := range h.IdentityFiles {
+		h.IdentityFiles[i] = ExpandHome(expandTokens(f, map[byte]string{
+			'd': home,
+			'h': h.HostName,
+			'n': alias,
+			'p': strconv.Itoa(h.Port),
+			'r': h.User,
+			'u': local,
+		}))
+	}
+
+	return h, nil
+}
+
+// Jumps returns the jump hosts to connect through to reach alias, in the order they are connected to. It is empty if alias is connected to directly.
+//
+// The jump hosts are looked up in c, and the user and port in the ProxyJump of alias takes precedence.
+// The ProxyJump of the first jump host is followed, like in OpenSSH.
+func (c *Config) Jumps(alias string) ([]Host, error) {
+	return c.jumps(alias, 0)
+}
+
+// jumps returns the jump hosts of alias, see Jumps
+func (c *Config) jumps(alias string, depth int) ([]Host, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("too many jump hosts for %s, is there a ProxyJump loop?", alias)
+	}
+
+	h, err := c.Get(alias)
+	if err != nil {
+		return nil, err
+	}
+
+	if h.ProxyJump == "" || strings.ToLower(h.ProxyJump) == "none" {
+		return nil, nil
+	}
+
+	jumps := []Host{}
+
+	for n, spec := range strings.Split(h.ProxyJump, ",") {
+		name, u, port, err := splitJump(spec)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid ProxyJump for %s", alias)
+		}
+
+		if n == 0 {
+			before, err := c.jumps(name, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			jumps = append(jumps, before...)
+		}
+
+		j, err := c.Get(name)
+		if err != nil {
+			return nil, err
+		}
+
+		if u != "" {
+			j.User = u
+		}
+		if port != 0 {
+			j.Port = port
+		}
+
+		jumps = append(jumps, j)
+	}
+
+	return jumps, nil
+}
+
+// splitJump splits a jump host on the form [user@]host[:port]
+func splitJump(spec string) (host string, user string, port int, err error) {
+	spec = strings.TrimSpace(strings.TrimPrefix(spec, "ssh://"))
+
+	if i := strings.LastIndex(spec, "@"); i != -1 {
+		user = spec[:i]
+		spec = spec[i+1:]
+	}
+
+	host = spec
+	if h, p, err := net.SplitHostPort(spec); err == nil {
+		port, err = strconv.Atoi(p)
+		if err != nil {
+			return "", "", 0, fmt.Errorf("invalid port in %s", spec)
+		}
+		host = h
+	}
+
+	if host == "" {
+		return "", "", 0, fmt.Errorf("missing host in %s", spec)
+	}
+
+	return host, user, port, nil
+}
+
+// Aliases returns the host aliases in c that are not patterns, i.e. without wildcards or negation, in the order they first appear
+func (c *Config) Aliases() []string {
+	aliases := []string{}
+	seen := map[string]bool{}
+
+	for _, b := range c.blocks {
+		for _, p := range b.patterns {
+			if strings.ContainsAny(p, "*?!") || seen[p] {
+				continue
+			}
+			seen[p] = true
+			aliases = append(aliases, p)
+		}
+	}
+
+	return aliases
+}
+
+// matches reports if alias matches the host patterns, ignoring case. It does not match if any negated pattern matches. Nil patterns matches all.
+func matches(patterns []string, alias string) bool {
+	if patterns == nil {
+		return true
+	}
+
+	alias = strings.ToLower(alias)
+
+	matched := false
+	for _, p := range patterns {
+		p = strings.ToLower(p)
+		if strings.HasPrefix(p, "!") {
+			if match(p[1:], alias) {
+				return false
+			}
+			continue
+		}
+		if match(p, alias) {
+			matched = true
+		}
+	}
+
+	return matched
+}
+
+// match reports if s matches the wildcard pattern, where * matches any sequence of characters and ? matches a single character
+func match(pattern string, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if match(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+		}
+		pattern = pattern[1:]
+		s = s[1:]
+	}
+
+	return len(s) == 0
+}
+
+// expandTokens expands the % tokens in s, e.g. %h, and %% to %. Unknown tokens are kept as is.
+func expandTokens(s string, tokens map[byte]string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+
+	out := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' || i == len(s)-1 {
+			out.WriteByte(s[i])
+			continue
+		}
+
+		i++
+		if s[i] == '%' {
+			out.WriteByte('%')
+		} else if v, ok := tokens[s[i]]; ok {
+			out.WriteString(v)
+		} else {
+			out.WriteByte('%')
+			out.WriteByte(s[i])
+		}
+	}
+
+	return out.String()
+}
+
+// ExpandHome expands a leading ~/ in path to the home directory of the current user, like ssh does for paths in the config
+func ExpandHome(path string) string {
+	if !strings.HasPrefix(path, "~/") {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+
+	return filepath.Join(home, path[2:])
+}
@@ -0,0 +1,342 @@
+package sshconfig
+
+import (
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testConfig is a config with host specific settings, wildcards, negation, jump hosts and a Match block
+const testConfig = `
+# defaults for everything
+IdentityFile ~/.ssh/id_global
+
+Host web01 web02
+    HostName %h.example.com
+    User deploy
+
+Host db01
+    HostName=10.0.0.5
+    Port 2222
+    ProxyJump bastion
+
+Host bastion
+    HostName bastion.example.com
+    User "jump user"
+    IdentityFile %d/.ssh/%r_key
+
+Host inner
+    ProxyJump admin@db01:2200,web01
+
+Host *.example.com !web03.example.com
+    User example
+
+Match host web01
+    User matched
+
+Host *
+    User default
+    Port 22
+    IdentityFile ~/.ssh/id_%n
+`
+
+func TestGet(t *testing.T) {
+
+	c, err := Parse(strings.NewReader(testConfig))
+	if err != nil {
+		t.Fatalf("parse errored: %v", err)
+	}
+
+	home, _ := os.UserHomeDir()
+
+	var tests = []struct {
+		alias  string
+		expect Host
+	}{
+		{
+			alias: "web01",
+			expect: Host{
+				HostName:      "web01.example.com",
+				Port:          22,
+				User:          "deploy",
+				IdentityFiles: []string{home + "/.ssh/id_global", home + "/.ssh/id_web01"},
+			},
+		},
+		{
+			alias: "db01",
+			expect: Host{
+				HostName:      "10.0.0.5",
+				Port:          2222,
+				User:          "default",
+				IdentityFiles: []string{home + "/.ssh/id_global", home + "/.ssh/id_db01"},
+				ProxyJump:     "bastion",
+			},
+		},
+		{
+			alias: "bastion",
+			expect: Host{
+				HostName:      "bastion.example.com",
+				Port:          22,
+				User:          "jump user",
+				IdentityFiles: []string{home + "/.ssh/id_global", home + "/.ssh/jump user_key", home + "/.ssh/id_bastion"},
+			},
+		},
+		{
+			alias: "www.example.com",
+			expect: Host{
+				HostName:      "www.example.com",
+				Port:          22,
+				User:          "example",
+				IdentityFiles: []string{home + "/.ssh/id_global", home + "/.ssh/id_www.example.com"},
+			},
+		},
+		{
+			alias: "web03.example.com",
+			expect: Host{
+				HostName:      "web03.example.com",
+				Port:          22,
+				User:          "default",
+				IdentityFiles: []string{home + "/.ssh/id_global", home + "/.ssh/id_web03.example.com"},
+			},
+		},
+		{
+			// patterns are case-insensitive
+			alias: "WEB02",
+			expect: Host{
+				HostName:      "WEB02.example.com",
+				Port:          22,
+				User:          "deploy",
+				IdentityFiles: []string{home + "/.ssh/id_global", home + "/.ssh/id_WEB02"},
+			},
+		},
+		{
+			alias: "Web03.Example.com",
+			expect: Host{
+				HostName:      "Web03.Example.com",
+				Port:          22,
+				User:          "default",
+				IdentityFiles: []string{home + "/.ssh/id_global", home + "/.ssh/id_Web03.Example.com"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.alias, func(t *testing.T) {
+			h, err := c.Get(test.alias)
+			if err != nil {
+				t.Fatalf("get errored: %v", err)
+			}
+
+			test.expect.Alias = test.alias
+			if !reflect.DeepEqual(h, test.expect) {
+				t.Errorf("\n got %+v\n expect %+v", h, test.expect)
+			}
+		})
+	}
+}
+
+func TestGetDefaults(t *testing.T) {
+	c, err := Parse(strings.NewReader(""))
+	if err != nil {
+		t.Fatalf("parse errored: %v", err)
+	}
+
+	h, err := c.Get("somehost")
+	if err != nil {
+		t.Fatalf("get errored: %v", err)
+	}
+
+	u, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := Host{Alias: "somehost", HostName: "somehost", Port: 22, User: u.Username}
+	if !reflect.DeepEqual(h, expect) {
+		t.Errorf("\n got %+v\n expect %+v", h, expect)
+	}
+
+	if h.Addr() != "somehost:22" {
+		t.Errorf("addr: got %s", h.Addr())
+	}
+}
+
+func TestJumps(t *testing.T) {
+
+	c, err := Parse(strings.NewReader(testConfig))
+	if err != nil {
+		t.Fatalf("parse errored: %v", err)
+	}
+
+	var tests = []struct {
+		alias  string
+		expect []string
+	}{
+		{"web01", []string{}},
+		{"db01", []string{"jump user@bastion.example.com:22"}},
+		{"inner", []string{"jump user@bastion.example.com:22", "admin@10.0.0.5:2200", "deploy@web01.example.com:22"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.alias, func(t *testing.T) {
+			jumps, err := c.Jumps(test.alias)
+			if err != nil {
+				t.Fatalf("jumps errored: %v", err)
+			}
+
+			got := []string{}
+			for _, j := range jumps {
+				got = append(got, j.User+"@"+j.Addr())
+			}
+
+			if !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("got %v - expect %v", got, test.expect)
+			}
+		})
+	}
+
+	loop, err := Parse(strings.NewReader("Host a\n ProxyJump b\nHost b\n ProxyJump a\n"))
+	if err != nil {
+		t.Fatalf("parse errored: %v", err)
+	}
+
+	_, err = loop.Jumps("a")
+	if err == nil {
+		t.Errorf("expect error on ProxyJump loop")
+	}
+}
+
+func TestInclude(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "sshconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"config":            "Include conf.d/*.conf\n\nHost web01\n    Include web.inc\n    Port 2222\n\nHost *\n    User fallback\n",
+		"conf.d/10-db.conf": "Host db01\n    HostName 10.0.0.5\n",
+		"conf.d/20-ex.conf": "Host *.example.com\n    User example\n",
+		"web.inc":           "User web\nHost other\n    User other\n",
+	}
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c, err := Load(filepath.Join(dir, "config"))
+	if err != nil {
+		t.Fatalf("load errored: %v", err)
+	}
+
+	var tests = []struct {
+		alias    string
+		hostname string
+		user     string
+		port     int
+	}{
+		{"db01", "10.0.0.5", "fallback", 22},
+		{"www.example.com", "www.example.com", "example", 22},
+		{"web01", "web01", "web", 2222},
+		{"other", "other", "other", 22},
+	}
+
+	for _, test := range tests {
+		t.Run(test.alias, func(t *testing.T) {
+			h, err := c.Get(test.alias)
+			if err != nil {
+				t.Fatalf("get errored: %v", err)
+			}
+
+			if h.HostName != test.hostname || h.User != test.user || h.Port != test.port {
+				t.Errorf("got %s@%s:%d - expect %s@%s:%d", h.User, h.HostName, h.Port, test.user, test.hostname, test.port)
+			}
+		})
+	}
+
+	expect := []string{"db01", "web01", "other"}
+	if got := c.Aliases(); !reflect.DeepEqual(got, expect) {
+		t.Errorf("aliases: got %v - expect %v", got, expect)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+
+	var tests = []struct {
+		name   string
+		config string
+	}{
+		{"missing argument", "Host web01\n    User\n"},
+		{"unterminated quote", "Host web01\n    User \"deploy\n"},
+		{"include loop", "Include ~/.ssh/config\n"},
+	}
+
+	dir, err := ioutil.TempDir("", "sshconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(dir, "config")
+			config := strings.Replace(test.config, "~/.ssh/config", path, 1)
+
+			err := ioutil.WriteFile(path, []byte(config), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = Load(path)
+			if err == nil {
+				t.Errorf("expect error")
+			}
+		})
+	}
+
+	c, err := Parse(strings.NewReader("Host web01\n    Port twentytwo\n"))
+	if err != nil {
+		t.Fatalf("parse errored: %v", err)
+	}
+
+	_, err = c.Get("web01")
+	if err == nil {
+		t.Errorf("expect error on invalid port")
+	}
+}
+
+func TestExpandHome(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skip("no home dir:", err)
+	}
+
+	var tests = []struct {
+		path   string
+		expect string
+	}{
+		{"~/.ssh/config", filepath.Join(home, ".ssh/config")},
+		{"/etc/ssh/ssh_config", "/etc/ssh/ssh_config"},
+		{"config", "config"},
+		{"~other/config", "~other/config"},
+	}
+
+	for _, test := range tests {
+		if got := ExpandHome(test.path); got != test.expect {
+			t.Errorf("%s: got %s - expect %s", test.path, got, test.expect)
+		}
+	}
+}
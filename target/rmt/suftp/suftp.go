@@ -1,6 +1,7 @@
 package suftp
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"strings"
@@ -83,13 +84,26 @@ const promptlength int = 15
 // functions.
 //
 // The user is the user to get an sftp client for. Sudopwd is the password for the user on conn.
-func NewSudoClient(conn *ssh.Client, user, sudopwd string, opts ...sftp.ClientOption) (*sftp.Client, error) {
+//
+// Ctx only applies to setting up the client. If ctx is done before the client is ready, the session is closed and an error wrapping ctx.Err() is returned.
+func NewSudoClient(ctx context.Context, conn *ssh.Client, user, sudopwd string, opts ...sftp.ClientOption) (*sftp.Client, error) {
 
 	s, err := conn.NewSession()
 	if err != nil {
 		return nil, err
 	}
 
+	// close the session if ctx is done while waiting for sudo
+	ready := make(chan struct{})
+	defer close(ready)
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Close()
+		case <-ready:
+		}
+	}()
+
 	// serverpaths are the most likely paths to the sftp-server binary, ordered from most likely less likely
 	// paths are from https://winscp.net/eng/docs/faq_su#fn2
 	serverpaths := []string{
@@ -148,6 +162,9 @@ func NewSudoClient(conn *ssh.Client, user, sudopwd string, opts ...sftp.ClientOp
 
 	// Sudo might output a lecture, so looping for either password, error or success prompt
 	prompt, err := getPrompt(stderr)
+	if ctx.Err() != nil {
+		return nil, errors.Wrap(ctx.Err(), "sudo aborted")
+	}
 	if err != nil {
 		return nil, errors.Wrap(err, "could not get err prompt")
 	}
@@ -165,6 +182,9 @@ func NewSudoClient(conn *ssh.Client, user, sudopwd string, opts ...sftp.ClientOp
 	// If it returns pwd prompt again, it means that it was the wrong password.
 	// Error means the user is not allowed to sudo or something else went wrong.
 	prompt, err = getPrompt(stderr)
+	if ctx.Err() != nil {
+		return nil, errors.Wrap(ctx.Err(), "sudo aborted")
+	}
 	if err != nil {
 		return nil, errors.Wrap(err, "second stderr read failed")
 	}
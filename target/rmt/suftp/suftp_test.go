@@ -1,6 +1,7 @@
 package suftp
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
@@ -9,18 +10,38 @@ import (
 	"testing"
 
 	"github.com/krilor/gossh/testing/docker"
+	"github.com/krilor/gossh/testing/sshtest"
+	"golang.org/x/crypto/ssh"
 )
 
-var containers []*docker.Container
+// testHost is a host the tests are run against, either a docker container or an sshtest server
+type testHost interface {
+	Image() string
+	Home(user string) string
+	Exec(cmd string) (string, string, int, error)
+	NewSSHClient(user string) (*ssh.Client, error)
+}
+
+var containers []testHost
+
+var useDocker = flag.Bool("docker", false, "also run tests against throwaway docker containers")
 
 func TestMain(m *testing.M) {
 
-	// need to parse flags for testing.Short()
+	// need to parse flags for testing.Short() and -docker
 	flag.Parse()
 
 	// setup
+	server, err := sshtest.New(nil)
+	if err != nil {
+		log.Fatalf("unable to start ssh test server: %v", err)
+	}
+	containers = append(containers, server)
+
 	var imgs []docker.Image
-	if testing.Short() {
+	if !*useDocker {
+		imgs = nil
+	} else if testing.Short() {
 		imgs = docker.Bench
 	} else {
 		imgs = docker.FullBench
@@ -41,31 +62,55 @@ func TestMain(m *testing.M) {
 	// teardown
 	for _, c := range containers {
 		log.Println("killing container:", c.Image())
-		c.Kill()
+		switch c := c.(type) {
+		case *docker.Container:
+			c.Kill()
+		case *sshtest.Server:
+			c.Close()
+		}
 	}
 
 	os.Exit(code)
 
 }
 
+// home expands a leading ~user in path to the home of user on c
+func home(c testHost, path string) string {
+	if !strings.HasPrefix(path, "~") {
+		return path
+	}
+	parts := strings.SplitN(path[1:], "/", 2)
+	return c.Home(parts[0]) + "/" + parts[1]
+}
+
+// owner returns the owner of path on c. The sshtest server emulates the owner of files created over sftp.
+func owner(c testHost, path string) string {
+	if s, ok := c.(*sshtest.Server); ok {
+		o, _ := s.Owner(path)
+		return o
+	}
+	o, _, _, _ := c.Exec("stat --format='%U' " + path)
+	return o
+}
+
 func TestSudoSftp(t *testing.T) {
 
 	tests := []struct {
 		user    string // ssh user
 		sudo    string // the user to sudo to
 		sudopwd string // users sudopassword
-		file    string // file path to create
+		file    string // file path to create, relative to a users home
 		errend  string // the end of a error string. empty if no error.
 	}{
-		{"gossh", "hobgob", "gosshpwd", "/home/hobgob/somefile", ""},
-		{"gossh", "hobgob", "incorrectpassword", "/home/hobgob/somefile", "wrong sudo password"},
-		{"gossh", "root", "gosshpwd", "/root/somefile", ""},
-		{"hobgob", "gossh", "", "/home/gossh/somefile", ""},
-		{"hobgob", "gossh", "hobgobpwd", "/home/gossh/somefile2", ""},
-		{"hobgob", "root", "", "/root/anotherfile", ""},
-		{"hobgob", "", "", "/root/anotherfile2", ""},
-		{"joxter", "stinky", "joxterpwd", "/home/stinky/joxterfile", ""},
-		{"stinky", "gossh", "stinkypwd", "/home/gossh/stinkyfile", "sudo failed or no sudo rights"}, // stinky does not have sudo rights
+		{"gossh", "hobgob", "gosshpwd", "~hobgob/somefile", ""},
+		{"gossh", "hobgob", "incorrectpassword", "~hobgob/somefile", "wrong sudo password"},
+		{"gossh", "root", "gosshpwd", "~root/somefile", ""},
+		{"hobgob", "gossh", "", "~gossh/somefile", ""},
+		{"hobgob", "gossh", "hobgobpwd", "~gossh/somefile2", ""},
+		{"hobgob", "root", "", "~root/anotherfile", ""},
+		{"hobgob", "", "", "~root/anotherfile2", ""},
+		{"joxter", "stinky", "joxterpwd", "~stinky/joxterfile", ""},
+		{"stinky", "gossh", "stinkypwd", "~gossh/stinkyfile", "sudo failed or no sudo rights"}, // stinky does not have sudo rights
 	}
 
 	for _, c := range containers {
@@ -78,7 +123,7 @@ func TestSudoSftp(t *testing.T) {
 				}
 				defer conn.Close()
 
-				sftp, err := NewSudoClient(conn, test.sudo, test.sudopwd)
+				sftp, err := NewSudoClient(context.Background(), conn, test.sudo, test.sudopwd)
 				if err != nil {
 					if test.errend != "" {
 						// error was expected
@@ -95,20 +140,19 @@ func TestSudoSftp(t *testing.T) {
 				}
 				defer sftp.Close()
 
-				err = sftp.Mkdir(test.file)
+				file := home(c, test.file)
+				err = sftp.Mkdir(file)
 				if err != nil {
 					t.Fatal("could not create dir in hobgob home", err)
 				}
 
-				o, _, s, err := c.Exec("stat --format='%U' " + test.file)
-
 				sudo := test.sudo
 				if sudo == "" || sudo == "-" {
 					sudo = "root"
 				}
 
-				if o != sudo || s != 0 {
-					t.Errorf("owner: expect %s:%d, got %s:%d", test.sudo, 0, o, s)
+				if o := owner(c, file); o != sudo {
+					t.Errorf("owner: expect %s, got %s", sudo, o)
 				}
 			})
 		}
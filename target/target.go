@@ -3,6 +3,7 @@ package target
 // Package target
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
@@ -19,7 +20,10 @@ type Target interface {
 	Close() error
 
 	// As returns a new target with user as the active user.
-	As(user string)
+	//
+	// The returned target shares connections with the original target, which is not modified.
+	// Closing any of them closes the underlying connections for all of them.
+	As(user string) Target
 
 	// User returns the connected user
 	User() string
@@ -31,14 +35,20 @@ type Target interface {
 	//
 	// Stdin can be used to add stdin to the commmand.
 	// Targets should handle that stdin is nil, i.e. no stdin.
-	Run(cmd string, stdin io.Reader) (sh.Result, error)
+	//
+	// If ctx is done before cmd completes, the process is killed and an error wrapping ctx.Err() is returned.
+	Run(ctx context.Context, cmd string, stdin io.Reader) (sh.Result, error)
 
 	// Put creates the named file in path with perm (before umask), truncating it if it already exists.
 	// Data is written to the file. Modelled after ioutil.Writefile
-	Put(filename string, data []byte, perm os.FileMode) error
+	//
+	// If ctx is done before the file is written, an error wrapping ctx.Err() is returned.
+	Put(ctx context.Context, filename string, data []byte, perm os.FileMode) error
 
 	// Get reads the file named by path.
 	// A successful call returns err == nil, not err == EOF. Because ReadFile reads the whole file, it does not treat an EOF from Read as an error to be reported.
 	// Modelled after ioutil.Readfile
-	Get(filename string) ([]byte, error)
+	//
+	// If ctx is done before the file is read, an error wrapping ctx.Err() is returned.
+	Get(ctx context.Context, filename string) ([]byte, error)
 }
@@ -1,9 +1,15 @@
-package target
+package target_test
 
 import (
+	"github.com/krilor/gossh/target"
+	"github.com/krilor/gossh/target/lazy"
 	"github.com/krilor/gossh/target/local"
+	"github.com/krilor/gossh/target/record"
 	"github.com/krilor/gossh/target/rmt"
 )
 
-var _ Target = &rmt.Remote{}
-var _ Target = &local.Local{}
+var _ target.Target = &rmt.Remote{}
+var _ target.Target = &local.Local{}
+var _ target.Target = &record.Recorder{}
+var _ target.Target = &record.Replayer{}
+var _ target.Target = &lazy.Target{}
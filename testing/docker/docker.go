@@ -98,6 +98,14 @@ func (c *Container) Exec(cmd string) (string, string, int, error) {
 	return strings.Trim(o.String(), " \n"), strings.Trim(e.String(), " \n"), s, err
 }
 
+// Home returns the home directory of user in the container
+func (c *Container) Home(user string) string {
+	if user == "root" {
+		return "/root"
+	}
+	return "/home/" + user
+}
+
 // Port gets the port that SSH is listening on
 func (c *Container) Port() int {
 	return c.port
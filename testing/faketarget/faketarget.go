@@ -0,0 +1,370 @@
+// Package faketarget provides a scriptable target.Target for unit-testing rules without docker or network.
+//
+// Tests register the commands they expect, exact or by regexp, together with the result they should return.
+// Put and Get work on an in-memory filesystem, and every call is recorded.
+// Commands that are not expected fail the test.
+//
+//	ft := faketarget.New(t, "gossh")
+//	ft.Expect("stat /tmp/file").Returns("", "no such file", 1).Times(1)
+//	ft.Expect("stat /tmp/file").Returns("", "", 0)
+//	ft.ExpectRegexp(`^touch `)
+//
+//	h := gossh.New(ft)
+package faketarget
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sync"
+	"testing"
+
+	"github.com/krilor/gossh/target"
+	"github.com/krilor/gossh/target/sh"
+	"github.com/pkg/errors"
+)
+
+// UnexpectedExitStatus is the exit status of commands that are not expected
+const UnexpectedExitStatus int = 127
+
+// Target is a fake target.Target.
+//
+// Views of the target returned from As share all state except the active user.
+// A Target is safe for concurrent use.
+type Target struct {
+	*state
+	activeUser string
+}
+
+// state is the state shared by all views of a Target
+type state struct {
+	t            testing.TB
+	mu           sync.Mutex
+	host         string
+	user         string
+	expectations []*Expectation
+	files        map[string]File
+	calls        []Call
+	closed       bool
+}
+
+// New returns a fake target connected to host fake as user. Unexpected commands fail t.
+func New(t testing.TB, user string) *Target {
+	return NewHost(t, user, "fake")
+}
+
+// NewHost returns a fake target connected to host as user, e.g. to have several targets with different names. See New.
+func NewHost(t testing.TB, user string, host string) *Target {
+	return &Target{
+		state: &state{
+			t:     t,
+			host:  host,
+			user:  user,
+			files: map[string]File{},
+		},
+		activeUser: user,
+	}
+}
+
+// File is a file in the in-memory filesystem of a Target
+type File struct {
+	Data []byte
+	Perm os.FileMode
+	// Owner is the active user that put the file
+	Owner string
+}
+
+// Call is a recorded call to Run, Put or Get
+type Call struct {
+	// Method is Run, Put or Get
+	Method string
+	// User is the active user of the call
+	User string
+	// Cmd and Stdin are set for Run
+	Cmd   string
+	Stdin string
+	// Filename is set for Put and Get
+	Filename string
+	// Data and Perm are set for Put
+	Data []byte
+	Perm os.FileMode
+}
+
+// Expectation is an expected command and the result it returns
+type Expectation struct {
+	cmd     string
+	pattern *regexp.Regexp
+	user    string
+	times   int
+	matched int
+
+	stdout     string
+	stderr     string
+	exitStatus int
+	do         func(cmd string, stdin string) (sh.Result, error)
+	blocks     bool
+}
+
+// Expect registers cmd as an expected command. By default, it succeeds with no output, any number of times.
+//
+// Expectations are matched in the order they are registered. Expectations that are used up by Times are skipped.
+func (f *Target) Expect(cmd string) *Expectation {
+	return f.expect(&Expectation{cmd: cmd})
+}
+
+// ExpectRegexp registers commands that match pattern as expected. See Expect.
+func (f *Target) ExpectRegexp(pattern string) *Expectation {
+	return f.expect(&Expectation{pattern: regexp.MustCompile(pattern)})
+}
+
+// expect adds e to the expectations of f
+func (f *Target) expect(e *Expectation) *Expectation {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.expectations = append(f.expectations, e)
+	return e
+}
+
+// Returns sets the output and exit status of the command
+func (e *Expectation) Returns(stdout string, stderr string, exitStatus int) *Expectation {
+	e.stdout = stdout
+	e.stderr = stderr
+	e.exitStatus = exitStatus
+	return e
+}
+
+// Do sets a function that returns the result of the command, e.g. to simulate changes. It overrides Returns.
+func (e *Expectation) Do(f func(cmd string, stdin string) (sh.Result, error)) *Expectation {
+	e.do = f
+	return e
+}
+
+// Blocks makes the command block until ctx is done, e.g. to test timeouts. It then fails with an error wrapping ctx.Err().
+func (e *Expectation) Blocks() *Expectation {
+	e.blocks = true
+	return e
+}
+
+// As limits the expectation to commands run with user as the active user
+func (e *Expectation) As(user string) *Expectation {
+	e.user = user
+	return e
+}
+
+// Times limits the number of times the expectation is matched. Zero means any number of times.
+func (e *Expectation) Times(n int) *Expectation {
+	e.times = n
+	return e
+}
+
+// String implements fmt.Stringer for an Expectation
+func (e *Expectation) String() string {
+	s := fmt.Sprintf("%q", e.cmd)
+	if e.pattern != nil {
+		s = fmt.Sprintf("/%s/", e.pattern)
+	}
+	if e.user != "" {
+		s += " as " + e.user
+	}
+	return s
+}
+
+// matches reports if e matches cmd run as user, and is not used up
+func (e *Expectation) matches(cmd string, user string) bool {
+	if e.times > 0 && e.matched >= e.times {
+		return false
+	}
+
+	if e.user != "" && e.user != user {
+		return false
+	}
+
+	if e.pattern != nil {
+		return e.pattern.MatchString(cmd)
+	}
+
+	return e.cmd == cmd
+}
+
+// Verify fails the test for all expectations that were not matched.
+// Expectations limited by Times must be matched exactly that many times, other expectations at least once.
+func (f *Target) Verify() {
+	f.t.Helper()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, e := range f.expectations {
+		switch {
+		case e.times > 0 && e.matched != e.times:
+			f.t.Errorf("expected command %s %d times - got %d", e, e.times, e.matched)
+		case e.times == 0 && e.matched == 0:
+			f.t.Errorf("expected command %s was not run", e)
+		}
+	}
+}
+
+// String implements fmt.Stringer
+func (f *Target) String() string {
+	return fmt.Sprintf("%s@%s", f.user, f.host)
+}
+
+// Close marks f as closed
+func (f *Target) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.closed = true
+	return nil
+}
+
+// Closed reports if Close has been called on f or any of its views
+func (f *Target) Closed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.closed
+}
+
+// As returns a view of f with user as the active user
+func (f *Target) As(user string) target.Target {
+	return &Target{state: f.state, activeUser: user}
+}
+
+// User returns the connected user
+func (f *Target) User() string {
+	return f.user
+}
+
+// ActiveUser returns the active user
+func (f *Target) ActiveUser() string {
+	return f.activeUser
+}
+
+// Run returns the result of the first matching expectation. If no expectations matches, the test is failed.
+func (f *Target) Run(ctx context.Context, cmd string, stdin io.Reader) (sh.Result, error) {
+	in := []byte{}
+	if stdin != nil {
+		var err error
+		in, err = ioutil.ReadAll(stdin)
+		if err != nil {
+			return sh.Result{ExitStatus: -1}, errors.Wrap(err, "could not read stdin")
+		}
+	}
+
+	f.mu.Lock()
+	f.calls = append(f.calls, Call{Method: "Run", User: f.activeUser, Cmd: cmd, Stdin: string(in)})
+
+	var match *Expectation
+	for _, e := range f.expectations {
+		if e.matches(cmd, f.activeUser) {
+			match = e
+			match.matched++
+			break
+		}
+	}
+	f.mu.Unlock()
+
+	if ctx.Err() != nil {
+		return sh.Result{ExitStatus: -1}, errors.Wrapf(ctx.Err(), "command \"%s\" aborted", cmd)
+	}
+
+	if match == nil {
+		f.t.Errorf("unexpected command \"%s\" as %s", cmd, f.activeUser)
+		return sh.Result{ExitStatus: UnexpectedExitStatus}, errors.Errorf("unexpected command \"%s\"", cmd)
+	}
+
+	if match.blocks {
+		<-ctx.Done()
+		return sh.Result{ExitStatus: -1}, errors.Wrapf(ctx.Err(), "command \"%s\" aborted", cmd)
+	}
+
+	if match.do != nil {
+		return match.do(cmd, string(in))
+	}
+
+	r := sh.Result{ExitStatus: match.exitStatus}
+	r.Stdout.WriteString(match.stdout)
+	r.Stderr.WriteString(match.stderr)
+
+	return r, nil
+}
+
+// Put writes data to filename in the in-memory filesystem
+func (f *Target) Put(ctx context.Context, filename string, data []byte, perm os.FileMode) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls = append(f.calls, Call{Method: "Put", User: f.activeUser, Filename: filename, Data: append([]byte{}, data...), Perm: perm})
+
+	if ctx.Err() != nil {
+		return errors.Wrapf(ctx.Err(), "put %s aborted", filename)
+	}
+
+	f.files[filename] = File{
+		Data:  append([]byte{}, data...),
+		Perm:  perm,
+		Owner: f.activeUser,
+	}
+
+	return nil
+}
+
+// Get reads filename from the in-memory filesystem. If it does not exist, an error satisfying os.IsNotExist is returned.
+func (f *Target) Get(ctx context.Context, filename string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls = append(f.calls, Call{Method: "Get", User: f.activeUser, Filename: filename})
+
+	if ctx.Err() != nil {
+		return nil, errors.Wrapf(ctx.Err(), "get %s aborted", filename)
+	}
+
+	file, ok := f.files[filename]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrNotExist}
+	}
+
+	return append([]byte{}, file.Data...), nil
+}
+
+// SetFile adds a file to the in-memory filesystem, owned by the connected user
+func (f *Target) SetFile(filename string, data []byte, perm os.FileMode) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.files[filename] = File{Data: append([]byte{}, data...), Perm: perm, Owner: f.user}
+}
+
+// File returns the file filename from the in-memory filesystem. Ok is false if it does not exist.
+func (f *Target) File(filename string) (file File, ok bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	file, ok = f.files[filename]
+	return file, ok
+}
+
+// Calls returns all recorded calls, in order
+func (f *Target) Calls() []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]Call{}, f.calls...)
+}
+
+// Cmds returns the commands of all recorded Run calls, in order
+func (f *Target) Cmds() []string {
+	cmds := []string{}
+	for _, c := range f.Calls() {
+		if c.Method == "Run" {
+			cmds = append(cmds, c.Cmd)
+		}
+	}
+	return cmds
+}
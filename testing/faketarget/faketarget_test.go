@@ -0,0 +1,165 @@
+package faketarget
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/krilor/gossh/target"
+	"github.com/krilor/gossh/target/sh"
+	"github.com/pkg/errors"
+)
+
+var _ target.Target = &Target{}
+
+// recorder is a testing.TB that records errors instead of failing
+type recorder struct {
+	testing.TB
+	errs []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errs = append(r.errs, fmt.Sprintf(format, args...))
+}
+
+func TestRun(t *testing.T) {
+
+	ctx := context.Background()
+	rec := &recorder{TB: t}
+	ft := New(rec, "gossh")
+
+	ft.Expect("stat /tmp/file").Returns("", "no such file", 1).Times(1)
+	ft.Expect("stat /tmp/file").Returns("file", "", 0)
+	ft.ExpectRegexp(`^touch `).As("root")
+	ft.Expect("cat").Do(func(cmd string, stdin string) (sh.Result, error) {
+		r := sh.Result{}
+		r.Stdout.WriteString(stdin)
+		return r, nil
+	})
+
+	var tests = []struct {
+		cmd    string
+		user   string
+		stdin  string
+		stdout string
+		exit   int
+		err    bool
+	}{
+		{"stat /tmp/file", "gossh", "", "", 1, false},
+		{"stat /tmp/file", "gossh", "", "file", 0, false},
+		{"stat /tmp/file", "gossh", "", "file", 0, false},
+		{"touch /tmp/file", "root", "", "", 0, false},
+		{"touch /tmp/file", "gossh", "", "", UnexpectedExitStatus, true},
+		{"cat", "gossh", "hello", "hello", 0, false},
+	}
+
+	for _, test := range tests {
+		var stdin io.Reader
+		if test.stdin != "" {
+			stdin = bytes.NewBufferString(test.stdin)
+		}
+
+		r, err := ft.As(test.user).Run(ctx, test.cmd, stdin)
+
+		if (err != nil) != test.err {
+			t.Errorf("%s as %s: got err %v - expect err %t", test.cmd, test.user, err, test.err)
+		}
+		if r.ExitStatus != test.exit || r.Stdout.String() != test.stdout {
+			t.Errorf("%s as %s: got %d \"%s\" - expect %d \"%s\"", test.cmd, test.user, r.ExitStatus, r.Stdout.String(), test.exit, test.stdout)
+		}
+	}
+
+	if len(rec.errs) != 1 {
+		t.Errorf("unexpected command should fail the test once: got %v", rec.errs)
+	}
+
+	calls := ft.Calls()
+	if len(calls) != len(tests) {
+		t.Fatalf("got %d calls - expect %d", len(calls), len(tests))
+	}
+	if calls[5].Stdin != "hello" || calls[3].User != "root" {
+		t.Errorf("wrong calls recorded: %+v", calls)
+	}
+
+	rec.errs = nil
+	ft.Expect("never run")
+	ft.Expect("once").Times(2)
+	ft.Run(ctx, "once", nil)
+	ft.Verify()
+
+	if len(rec.errs) != 2 {
+		t.Errorf("verify: got %v - expect 2 errors", rec.errs)
+	}
+}
+
+func TestRunContext(t *testing.T) {
+	ft := New(t, "gossh")
+	ft.Expect("sleep 10")
+	ft.Expect("block").Blocks()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := ft.Run(ctx, "sleep 10", nil)
+	if err == nil {
+		t.Errorf("expected error when ctx is done")
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err = ft.Run(ctx, "block", nil)
+	if errors.Cause(err) != context.DeadlineExceeded {
+		t.Errorf("block: got %v - expect %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestFiles(t *testing.T) {
+
+	ctx := context.Background()
+	ft := New(t, "gossh")
+
+	_, err := ft.Get(ctx, "/etc/missing")
+	if !os.IsNotExist(err) {
+		t.Errorf("missing file: got %v - expect not exist", err)
+	}
+
+	ft.SetFile("/etc/hosts", []byte("127.0.0.1 localhost"), 0644)
+
+	data, err := ft.As("root").Get(ctx, "/etc/hosts")
+	if err != nil || string(data) != "127.0.0.1 localhost" {
+		t.Errorf("get: got \"%s\" %v", data, err)
+	}
+
+	err = ft.As("root").Put(ctx, "/etc/motd", []byte("hello"), 0600)
+	if err != nil {
+		t.Fatalf("put errored: %v", err)
+	}
+
+	file, ok := ft.File("/etc/motd")
+	expect := File{Data: []byte("hello"), Perm: 0600, Owner: "root"}
+	if !ok || !reflect.DeepEqual(file, expect) {
+		t.Errorf("put: got %+v - expect %+v", file, expect)
+	}
+
+	if len(ft.Cmds()) != 0 || len(ft.Calls()) != 3 {
+		t.Errorf("wrong calls recorded: %+v", ft.Calls())
+	}
+}
+
+func TestString(t *testing.T) {
+	if got := New(t, "gossh").String(); got != "gossh@fake" {
+		t.Errorf("new: got %s - expect gossh@fake", got)
+	}
+
+	if got := NewHost(t, "gossh", "web1").As("root").String(); got != "gossh@web1" {
+		t.Errorf("new host: got %s - expect gossh@web1", got)
+	}
+}
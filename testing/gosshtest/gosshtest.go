@@ -0,0 +1,137 @@
+// Package gosshtest provides assertions for testing rules.
+//
+// The assertions work on any host, e.g. one backed by faketarget, sshtest or a docker container.
+//
+//	ft := faketarget.New(t, "gossh")
+//	ft.Expect("stat /tmp/file").Returns("", "no such file", 1).Times(1)
+//	ft.Expect("stat /tmp/file").Returns("", "", 0)
+//	ft.Expect("touch /tmp/file")
+//
+//	gosshtest.AssertIdempotent(t, file.Exists{Path: "/tmp/file"}, gossh.New(ft))
+package gosshtest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/krilor/gossh"
+)
+
+// changes is a gossh.Reporter that records the changes made on a host
+type changes struct {
+	mu      sync.Mutex
+	changes []gossh.Event
+}
+
+// Report implements gossh.Reporter
+func (c *changes) Report(e gossh.Event) {
+	if e.Kind != gossh.EventEnforceEnd && e.Kind != gossh.EventPut {
+		return
+	}
+
+	if e.Blocked {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.changes = append(c.changes, e)
+}
+
+// get returns the recorded changes
+func (c *changes) get() []gossh.Event {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]gossh.Event{}, c.changes...)
+}
+
+// describe returns a short description of the change e
+func describe(e gossh.Event) string {
+	if e.Kind == gossh.EventPut {
+		return fmt.Sprintf("put %s as %s", e.Path, e.User)
+	}
+	return fmt.Sprintf("run \"%s\" as %s", e.Cmd, e.User)
+}
+
+// apply applies r on a view of h that records all changes made
+func apply(h *gossh.Host, name string, r gossh.Rule, allowChange bool) (gossh.Status, []gossh.Event, error) {
+	c := &changes{}
+
+	view := *h
+	view.AllowChange = allowChange
+	if h.Reporter != nil {
+		view.Reporter = gossh.MultiReporter(h.Reporter, c)
+	} else {
+		view.Reporter = c
+	}
+
+	s, err := view.Apply(context.Background(), name, r)
+
+	return s, c.get(), err
+}
+
+// AssertIdempotent applies r on h twice.
+// The first apply must succeed with gossh.StatusEnforced or gossh.StatusSatisfied.
+// The second apply must return gossh.StatusSatisfied, without running any commands with RunChange or putting any files.
+//
+// It reports if the assertion holds. Failures are reported with t.Errorf.
+func AssertIdempotent(t testing.TB, r gossh.Rule, h *gossh.Host) bool {
+	t.Helper()
+
+	s, _, err := apply(h, "first apply", r, true)
+	if err != nil {
+		t.Errorf("idempotent: first apply of %T errored: %v", r, err)
+		return false
+	}
+	if s != gossh.StatusEnforced && s != gossh.StatusSatisfied {
+		t.Errorf("idempotent: first apply of %T: got %s - expect %s or %s", r, s, gossh.StatusEnforced, gossh.StatusSatisfied)
+		return false
+	}
+
+	ok := true
+
+	s, changes, err := apply(h, "second apply", r, true)
+	if err != nil {
+		t.Errorf("idempotent: second apply of %T errored: %v", r, err)
+		ok = false
+	} else if s != gossh.StatusSatisfied {
+		t.Errorf("idempotent: second apply of %T: got %s - expect %s", r, s, gossh.StatusSatisfied)
+		ok = false
+	}
+
+	for _, c := range changes {
+		t.Errorf("idempotent: second apply of %T changed the host: %s", r, describe(c))
+		ok = false
+	}
+
+	return ok
+}
+
+// AssertCheckMode applies r on h in check-only mode, i.e. with AllowChange false.
+// The apply must succeed with gossh.StatusSatisfied, gossh.StatusNotSatisfied or gossh.StatusSkipped, without running any commands with RunChange or putting any files.
+//
+// The AllowChange of h is not modified.
+// It reports if the assertion holds. Failures are reported with t.Errorf.
+func AssertCheckMode(t testing.TB, r gossh.Rule, h *gossh.Host) bool {
+	t.Helper()
+
+	ok := true
+
+	s, changes, err := apply(h, "check", r, false)
+	if err != nil {
+		t.Errorf("check mode: apply of %T errored: %v", r, err)
+		ok = false
+	} else if s != gossh.StatusSatisfied && s != gossh.StatusNotSatisfied && s != gossh.StatusSkipped {
+		t.Errorf("check mode: apply of %T: got %s - expect %s, %s or %s", r, s, gossh.StatusSatisfied, gossh.StatusNotSatisfied, gossh.StatusSkipped)
+		ok = false
+	}
+
+	for _, c := range changes {
+		t.Errorf("check mode: apply of %T changed the host: %s", r, describe(c))
+		ok = false
+	}
+
+	return ok
+}
@@ -0,0 +1,136 @@
+package gosshtest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/krilor/gossh"
+	"github.com/krilor/gossh/testing/faketarget"
+	"github.com/pkg/errors"
+)
+
+// recorder is a testing.TB that records errors instead of failing
+type recorder struct {
+	testing.TB
+	errs []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errs = append(r.errs, fmt.Sprintf(format, args...))
+}
+
+// marker is a rule that ensures /tmp/marker exists, checking with test -f
+type marker struct {
+	// always puts the file, even if it exists
+	always bool
+}
+
+func (m marker) Ensure(ctx context.Context, h *gossh.Host) (gossh.Status, error) {
+	r, err := h.RunCheck(ctx, "test -f /tmp/marker", "", "")
+	if err != nil {
+		return gossh.StatusFailed, err
+	}
+
+	if r.ExitStatus == 0 && !m.always {
+		return gossh.StatusSatisfied, nil
+	}
+
+	err = h.Put(ctx, "/tmp/marker", []byte("marked"), 0644, "")
+	if errors.Cause(err) == gossh.ErrChangeBlocked {
+		return gossh.StatusNotSatisfied, nil
+	}
+	if err != nil {
+		return gossh.StatusFailed, err
+	}
+
+	return gossh.StatusEnforced, nil
+}
+
+// touch is a rule that always runs touch, and claims to be satisfied
+type touch struct{}
+
+func (touch) Ensure(ctx context.Context, h *gossh.Host) (gossh.Status, error) {
+	_, err := h.RunChange(ctx, "touch /tmp/marker", "", "")
+	return gossh.StatusSatisfied, err
+}
+
+// failing is a rule that always fails
+type failing struct{}
+
+func (failing) Ensure(ctx context.Context, h *gossh.Host) (gossh.Status, error) {
+	return gossh.StatusFailed, fmt.Errorf("failing")
+}
+
+func TestAssertIdempotent(t *testing.T) {
+
+	var tests = []struct {
+		name string
+		rule gossh.Rule
+		errs int
+	}{
+		{"idempotent", marker{}, 0},
+		{"puts every time", marker{always: true}, 2}, // enforced and the put
+		{"runs change every time", touch{}, 1},
+		{"fails", failing{}, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ft := faketarget.New(t, "gossh")
+			ft.Expect("test -f /tmp/marker").Returns("", "", 1).Times(1)
+			ft.Expect("test -f /tmp/marker")
+			ft.Expect("touch /tmp/marker")
+
+			h := gossh.New(ft)
+			h.Reporter = gossh.NopReporter{}
+
+			rec := &recorder{TB: t}
+			ok := AssertIdempotent(rec, test.rule, h)
+
+			if len(rec.errs) != test.errs || ok != (test.errs == 0) {
+				t.Errorf("got %v %d errors %v - expect %d errors", ok, len(rec.errs), rec.errs, test.errs)
+			}
+		})
+	}
+}
+
+func TestAssertCheckMode(t *testing.T) {
+
+	var tests = []struct {
+		name string
+		rule gossh.Rule
+		errs int
+	}{
+		{"marker", marker{}, 0},
+		{"touch", touch{}, 0},
+		{"fails", failing{}, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ft := faketarget.New(t, "gossh")
+			ft.Expect("test -f /tmp/marker").Returns("", "", 1)
+
+			h := gossh.New(ft)
+			h.Reporter = gossh.NopReporter{}
+
+			rec := &recorder{TB: t}
+			ok := AssertCheckMode(rec, test.rule, h)
+
+			if len(rec.errs) != test.errs || ok != (test.errs == 0) {
+				t.Errorf("got %v %d errors %v - expect %d errors", ok, len(rec.errs), rec.errs, test.errs)
+			}
+
+			if !h.AllowChange {
+				t.Errorf("AllowChange of host modified")
+			}
+
+			if _, ok := ft.File("/tmp/marker"); ok {
+				t.Errorf("file put in check mode")
+			}
+		})
+	}
+}
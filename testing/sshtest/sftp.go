@@ -0,0 +1,205 @@
+package sshtest
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// Ownership is emulated for files created over sftp, as the fake sudo does not switch user.
+// The sftp sessions are served in-process on the local filesystem, and record the user of the session as the owner of the files and directories it creates.
+
+// serveSftp serves sftp for user on rw until the client closes it
+func (s *Server) serveSftp(user string, rw io.ReadWriteCloser) {
+	h := &sftpHandlers{s: s, user: user}
+
+	server := sftp.NewRequestServer(rw, sftp.Handlers{
+		FileGet:  h,
+		FilePut:  h,
+		FileCmd:  h,
+		FileList: h,
+	})
+
+	server.Serve()
+	server.Close()
+}
+
+// created records user as the owner of path, if it is created by f
+func (s *Server) created(user string, path string, f func() error) error {
+	_, err := os.Lstat(path)
+	existed := err == nil
+
+	err = f()
+	if err != nil || existed {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.owners[path] = user
+
+	return nil
+}
+
+// Owner returns the user that created the file or directory path over sftp. Sudo based sftp creates files as the user sudo was run as.
+// Ok is false if path does not exist, or was not created over sftp.
+func (s *Server) Owner(path string) (user string, ok bool) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", false
+	}
+
+	if _, err := os.Lstat(path); err != nil {
+		return "", false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok = s.owners[path]
+	return user, ok
+}
+
+// sftpHandlers serves the sftp requests of user on the local filesystem
+type sftpHandlers struct {
+	s    *Server
+	user string
+}
+
+// Fileread implements sftp.FileReader
+func (h *sftpHandlers) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	return os.Open(r.Filepath)
+}
+
+// Filewrite implements sftp.FileWriter
+func (h *sftpHandlers) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	flags := r.Pflags()
+
+	mode := os.O_WRONLY
+	if flags.Read {
+		mode = os.O_RDWR
+	}
+	if flags.Creat {
+		mode |= os.O_CREATE
+	}
+	if flags.Trunc {
+		mode |= os.O_TRUNC
+	}
+	if flags.Excl {
+		mode |= os.O_EXCL
+	}
+
+	var f *os.File
+	err := h.s.created(h.user, r.Filepath, func() error {
+		var err error
+		f, err = os.OpenFile(r.Filepath, mode, 0644)
+		return err
+	})
+
+	return f, err
+}
+
+// Filecmd implements sftp.FileCmder
+func (h *sftpHandlers) Filecmd(r *sftp.Request) error {
+	switch r.Method {
+	case "Setstat":
+		return setstat(r)
+	case "Rename":
+		return os.Rename(r.Filepath, r.Target)
+	case "Rmdir", "Remove":
+		return os.Remove(r.Filepath)
+	case "Mkdir":
+		return h.s.created(h.user, r.Filepath, func() error {
+			return os.Mkdir(r.Filepath, 0755)
+		})
+	case "Link":
+		return os.Link(r.Filepath, r.Target)
+	case "Symlink":
+		return os.Symlink(r.Filepath, r.Target)
+	}
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+// setstat sets the attributes of r on its file. Owners can not be changed.
+func setstat(r *sftp.Request) error {
+	flags := r.AttrFlags()
+	attrs := r.Attributes()
+
+	if flags.Permissions {
+		err := os.Chmod(r.Filepath, attrs.FileMode().Perm())
+		if err != nil {
+			return err
+		}
+	}
+
+	if flags.Size {
+		err := os.Truncate(r.Filepath, int64(attrs.Size))
+		if err != nil {
+			return err
+		}
+	}
+
+	if flags.Acmodtime {
+		return os.Chtimes(r.Filepath, time.Unix(int64(attrs.Atime), 0), time.Unix(int64(attrs.Mtime), 0))
+	}
+
+	return nil
+}
+
+// Filelist implements sftp.FileLister
+func (h *sftpHandlers) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	switch r.Method {
+	case "List":
+		f, err := os.Open(r.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		infos, err := f.Readdir(-1)
+		return listerAt(infos), err
+	case "Stat":
+		info, err := os.Stat(r.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		return listerAt{info}, nil
+	case "Readlink":
+		target, err := os.Readlink(r.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		return listerAt{linkInfo{name: target}}, nil
+	}
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+// listerAt is a sftp.ListerAt of a fixed list of files
+type listerAt []os.FileInfo
+
+// ListAt implements sftp.ListerAt
+func (l listerAt) ListAt(ls []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+
+	n := copy(ls, l[offset:])
+	if n < len(ls) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// linkInfo is the os.FileInfo of a symlink target, as returned for Readlink
+type linkInfo struct {
+	os.FileInfo
+	name string
+}
+
+// Name implements os.FileInfo
+func (l linkInfo) Name() string {
+	return l.name
+}
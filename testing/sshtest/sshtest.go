@@ -0,0 +1,614 @@
+// Package sshtest provides an in-process SSH server for tests, so that remote targets can be tested with go test alone.
+//
+// The server listens on a random localhost port, and supports password and public key authentication.
+// Tcp connections can be forwarded through the server, so that it can be used as a jump host.
+// Commands are run locally using bash, and the sftp subsystem is served in-process.
+//
+// Sudo is emulated by a fake sudo command on PATH, that prompts for passwords like the real one.
+// It does not switch user, so all commands and files are run and owned by the user running the tests.
+// The owner of files created over sftp is emulated, also with sudo, see Server.Owner.
+// The users HOME is set to a per-user directory below the servers temporary directory, see Server.Home.
+//
+// Sudo based sftp, which runs sftp-server as a command, is served in-process as well: a fake sftp-server on PATH hands the session over to the server.
+// Machines with a real sftp-server in one of the usual paths will run that instead, and then ownership is not emulated.
+package sshtest
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// Sudo is the sudo rights of a User
+type Sudo int
+
+const (
+	// NoSudo means that the user can not use sudo
+	NoSudo Sudo = iota
+	// SudoPassword means that sudo prompts for the users password
+	SudoPassword
+	// SudoNoPassword means that sudo does not prompt for password, i.e. NOPASSWD:ALL
+	SudoNoPassword
+)
+
+// User is a user that can log in to a Server
+type User struct {
+	// Password is the users password. Password authentication is disabled for the user if it is empty.
+	Password string
+	// Keys are the authorized public keys of the user
+	Keys []ssh.PublicKey
+	// Sudo is the users sudo rights
+	Sudo Sudo
+	// Lecture makes sudo print a lecture before the password prompt
+	Lecture bool
+}
+
+// DefaultUsers returns the same users as in the docker test containers
+//
+//	| User   | Password  | Sudo rights  | Sudo lecture |
+//	|--------|-----------|------------- |--------------|
+//	| root   | rootpwd   | ALL          | N/A          |
+//	| gossh  | gosshpwd  | ALL          | never        |
+//	| hobgob | hobgobpwd | NOPASSWD:ALL | never        |
+//	| joxter | joxterpwd | ALL          | allways      |
+//	| groke  | grokepwd  | NOPASSWD:ALL | allways      |
+//	| stinky | stinkypwd | NO           | N/A          |
+func DefaultUsers() map[string]User {
+	return map[string]User{
+		"root":   {Password: "rootpwd", Sudo: SudoNoPassword},
+		"gossh":  {Password: "gosshpwd", Sudo: SudoPassword},
+		"hobgob": {Password: "hobgobpwd", Sudo: SudoNoPassword},
+		"joxter": {Password: "joxterpwd", Sudo: SudoPassword, Lecture: true},
+		"groke":  {Password: "grokepwd", Sudo: SudoNoPassword, Lecture: true},
+		"stinky": {Password: "stinkypwd", Sudo: NoSudo},
+	}
+}
+
+// Server is an in-process SSH server
+type Server struct {
+	users    map[string]User
+	dir      string
+	listener net.Listener
+	config   *ssh.ServerConfig
+	hostKey  ssh.PublicKey
+
+	mu        sync.Mutex
+	conns     map[*ssh.ServerConn]bool
+	closed    bool
+	forwarded []string
+	// owners are the users that created files over sftp, by absolute path
+	owners map[string]string
+	wg     sync.WaitGroup
+}
+
+// New starts a Server with users on a random localhost port. If users is nil, DefaultUsers is used.
+//
+// The server must be closed with Close, which also removes its temporary directory.
+func New(users map[string]User) (*Server, error) {
+
+	if users == nil {
+		users = DefaultUsers()
+	}
+
+	s := &Server{
+		users:  users,
+		conns:  map[*ssh.ServerConn]bool{},
+		owners: map[string]string{},
+	}
+
+	var err error
+	s.dir, err = ioutil.TempDir("", "sshtest")
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create temporary dir")
+	}
+
+	err = s.setup()
+	if err != nil {
+		os.RemoveAll(s.dir)
+		return nil, err
+	}
+
+	s.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		os.RemoveAll(s.dir)
+		return nil, errors.Wrap(err, "could not listen")
+	}
+
+	s.wg.Add(1)
+	go s.serve()
+
+	return s, nil
+}
+
+// setup creates the host key, the ssh server config, the user homes and the fake commands
+func (s *Server) setup() error {
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return errors.Wrap(err, "could not generate host key")
+	}
+
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return errors.Wrap(err, "could not create host key signer")
+	}
+	s.hostKey = signer.PublicKey()
+
+	s.config = &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			u, ok := s.users[c.User()]
+			if ok && u.Password != "" && u.Password == string(pass) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("password rejected for %s", c.User())
+		},
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			for _, k := range s.users[c.User()].Keys {
+				if string(k.Marshal()) == string(key.Marshal()) {
+					return nil, nil
+				}
+			}
+			return nil, fmt.Errorf("unknown public key for %s", c.User())
+		},
+	}
+	s.config.AddHostKey(signer)
+
+	for user := range s.users {
+		err = os.MkdirAll(s.Home(user), 0755)
+		if err != nil {
+			return errors.Wrapf(err, "could not create home for %s", user)
+		}
+	}
+
+	return s.writeBin()
+}
+
+// Addr returns the address of s, e.g. 127.0.0.1:34567
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Port returns the port s listens on
+func (s *Server) Port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+// HostKey returns the public host key of s, e.g. for use with ssh.FixedHostKey
+func (s *Server) HostKey() ssh.PublicKey {
+	return s.hostKey
+}
+
+// Home returns the home directory of user. Commands run with HOME set to this directory, also when using sudo.
+func (s *Server) Home(user string) string {
+	return filepath.Join(s.dir, "home", user)
+}
+
+// Image returns a name of the server, for use in test names alongside docker containers
+func (s *Server) Image() string {
+	return "sshtest"
+}
+
+// NewSSHClient returns a client connected to s as user, using the users password
+func (s *Server) NewSSHClient(user string) (*ssh.Client, error) {
+	return ssh.Dial("tcp", s.Addr(), &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.Password(s.users[user].Password)},
+		HostKeyCallback: ssh.FixedHostKey(s.hostKey),
+	})
+}
+
+// Exec runs cmd locally as it would be run on s by the user running the tests, and returns stdout and stderr with trimmed ends.
+func (s *Server) Exec(cmd string) (stdout string, stderr string, exitStatus int, err error) {
+	c := s.command(cmd, os.Getenv("USER"))
+
+	o, e := &strings.Builder{}, &strings.Builder{}
+	c.Stdout = o
+	c.Stderr = e
+
+	err = c.Run()
+	if exit, ok := err.(*exec.ExitError); ok {
+		return strings.TrimSpace(o.String()), strings.TrimSpace(e.String()), exit.ExitCode(), nil
+	}
+
+	return strings.TrimSpace(o.String()), strings.TrimSpace(e.String()), 0, err
+}
+
+// Close stops s, closes all connections and removes the temporary directory
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+
+	err := s.listener.Close()
+	s.wg.Wait()
+
+	os.RemoveAll(s.dir)
+	return err
+}
+
+// serve accepts connections until s is closed
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		nc, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handleConn(nc)
+		}()
+	}
+}
+
+// handleConn handles a single client connection
+func (s *Server) handleConn(nc net.Conn) {
+	conn, chans, reqs, err := ssh.NewServerConn(nc, s.config)
+	if err != nil {
+		nc.Close()
+		return
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		conn.Close()
+		return
+	}
+	s.conns[conn] = true
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	go ssh.DiscardRequests(reqs)
+
+	wg := sync.WaitGroup{}
+	for nch := range chans {
+		if nch.ChannelType() == "direct-tcpip" {
+			wg.Add(1)
+			go func(nch ssh.NewChannel) {
+				defer wg.Done()
+				s.handleForward(nch)
+			}(nch)
+			continue
+		}
+
+		if nch.ChannelType() != "session" {
+			nch.Reject(ssh.UnknownChannelType, "only session and direct-tcpip channels are supported")
+			continue
+		}
+
+		ch, requests, err := nch.Accept()
+		if err != nil {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.handleSession(conn.User(), ch, requests)
+		}()
+	}
+	wg.Wait()
+}
+
+// handleForward handles a direct-tcpip channel, i.e. a tcp connection forwarded to another address, e.g. when the server is a jump host
+func (s *Server) handleForward(nch ssh.NewChannel) {
+	var payload struct {
+		Host     string
+		Port     uint32
+		OrigHost string
+		OrigPort uint32
+	}
+	if ssh.Unmarshal(nch.ExtraData(), &payload) != nil {
+		nch.Reject(ssh.ConnectionFailed, "invalid direct-tcpip payload")
+		return
+	}
+
+	addr := net.JoinHostPort(payload.Host, strconv.Itoa(int(payload.Port)))
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		nch.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	defer conn.Close()
+
+	ch, requests, err := nch.Accept()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+	go ssh.DiscardRequests(requests)
+
+	s.mu.Lock()
+	s.forwarded = append(s.forwarded, addr)
+	s.mu.Unlock()
+
+	done := make(chan bool, 2)
+	go func() {
+		io.Copy(ch, conn)
+		ch.CloseWrite()
+		done <- true
+	}()
+	go func() {
+		io.Copy(conn, ch)
+		done <- true
+	}()
+
+	// when one direction is done, close both, so that the other is done as well
+	<-done
+	conn.Close()
+	ch.Close()
+	<-done
+}
+
+// Forwarded returns the addresses of the tcp connections that has been forwarded through s, e.g. as a jump host, in order
+func (s *Server) Forwarded() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.forwarded...)
+}
+
+// session is the state of a single session channel
+type session struct {
+	mu   sync.Mutex
+	cmd  *exec.Cmd
+	env  []string
+	done bool
+}
+
+// handleSession handles the requests on a session channel
+func (s *Server) handleSession(user string, ch ssh.Channel, requests <-chan *ssh.Request) {
+	sess := &session{}
+
+	for req := range requests {
+		switch req.Type {
+		case "env":
+			var kv struct{ Name, Value string }
+			if ssh.Unmarshal(req.Payload, &kv) == nil {
+				sess.env = append(sess.env, kv.Name+"="+kv.Value)
+			}
+			req.Reply(true, nil)
+
+		case "exec":
+			var payload struct{ Command string }
+			if ssh.Unmarshal(req.Payload, &payload) != nil || !sess.start() {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			go s.exec(user, sess, ch, payload.Command)
+
+		case "subsystem":
+			var payload struct{ Name string }
+			if ssh.Unmarshal(req.Payload, &payload) != nil || payload.Name != "sftp" || !sess.start() {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			go func() {
+				s.serveSftp(user, ch)
+				ch.Close()
+			}()
+
+		case "signal":
+			sess.mu.Lock()
+			if sess.cmd != nil && sess.cmd.Process != nil {
+				sess.cmd.Process.Signal(syscall.SIGKILL)
+			}
+			sess.mu.Unlock()
+			if req.WantReply {
+				req.Reply(true, nil)
+			}
+
+		default:
+			// pty-req, shell etc. are not supported
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+		}
+	}
+}
+
+// start marks sess as started. It reports false if it is already started.
+func (sess *session) start() bool {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	if sess.done {
+		return false
+	}
+	sess.done = true
+	return true
+}
+
+// exec runs cmd as user on ch, and sends the exit status when it is done.
+//
+// If the stdout of cmd starts with sftpHandoff, e.g. when sudo has run the fake sftp-server, the stdin of cmd is closed and sftp is served on ch instead.
+func (s *Server) exec(user string, sess *session, ch ssh.Channel, cmd string) {
+	defer ch.Close()
+
+	c := s.command(cmd, user)
+	c.Env = append(c.Env, sess.env...)
+	c.Stderr = ch.Stderr()
+
+	stdin, err := c.StdinPipe()
+	if err != nil {
+		return
+	}
+
+	stdout, err := c.StdoutPipe()
+	if err != nil {
+		return
+	}
+
+	sess.mu.Lock()
+	err = c.Start()
+	sess.cmd = c
+	sess.mu.Unlock()
+
+	if err != nil {
+		fmt.Fprintf(ch.Stderr(), "sshtest: %v\n", err)
+		ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{127}))
+		return
+	}
+
+	// stdin is not waited for, as the client might never close it
+	in := newInput(ch)
+	handoff := make(chan struct{})
+	go in.forward(stdin, handoff)
+
+	out := bufio.NewReader(stdout)
+	sftpUser, ok := readHandoff(out)
+	if ok {
+		// the fake sftp-server echoes the requests it got before the handoff, and exits when its stdin is closed
+		close(handoff)
+		pending, _ := ioutil.ReadAll(out)
+		c.Wait()
+
+		s.serveSftp(sftpUser, struct {
+			io.Reader
+			io.WriteCloser
+		}{io.MultiReader(bytes.NewReader(pending), in), ch})
+		return
+	}
+
+	io.Copy(ch, out)
+	err = c.Wait()
+
+	if _, exit := err.(*exec.ExitError); err != nil && !exit {
+		fmt.Fprintf(ch.Stderr(), "sshtest: %v\n", err)
+	}
+
+	if c.ProcessState == nil || !c.ProcessState.Exited() {
+		// killed by a signal, so no exit status is sent
+		return
+	}
+
+	ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(c.ProcessState.ExitCode())}))
+}
+
+// readHandoff reads sftpHandoff and the user following it from the start of r.
+// Ok is false if r does not start with sftpHandoff. Nothing is then read from r.
+func readHandoff(r *bufio.Reader) (user string, ok bool) {
+	// peek one byte at a time, so that output from other commands is not held back
+	for n := 1; n <= len(sftpHandoff); n++ {
+		b, err := r.Peek(n)
+		if err != nil || string(b) != sftpHandoff[:n] {
+			return "", false
+		}
+	}
+
+	r.Discard(len(sftpHandoff))
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", false
+	}
+
+	return strings.TrimSuffix(line, "\n"), true
+}
+
+// input is the stdin of a session. It is read in the background, so that it can be handed over from a command to an sftp server without losing any of it.
+type input struct {
+	chunks chan []byte
+	buf    []byte
+}
+
+// newInput returns the input read from r
+func newInput(r io.Reader) *input {
+	in := &input{chunks: make(chan []byte)}
+
+	go func() {
+		defer close(in.chunks)
+		for {
+			b := make([]byte, 32*1024)
+			n, err := r.Read(b)
+			if n > 0 {
+				in.chunks <- b[:n]
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return in
+}
+
+// forward writes the input to w until the input ends or handoff is closed, and then closes w.
+// Input that can not be written, e.g. because the command has exited, is discarded.
+func (in *input) forward(w io.WriteCloser, handoff <-chan struct{}) {
+	defer w.Close()
+
+	for {
+		select {
+		case b, ok := <-in.chunks:
+			if !ok {
+				return
+			}
+			w.Write(b)
+		case <-handoff:
+			return
+		}
+	}
+}
+
+// Read implements io.Reader for the input that is not forwarded
+func (in *input) Read(p []byte) (int, error) {
+	if len(in.buf) == 0 {
+		b, ok := <-in.chunks
+		if !ok {
+			return 0, io.EOF
+		}
+		in.buf = b
+	}
+
+	n := copy(p, in.buf)
+	in.buf = in.buf[n:]
+	return n, nil
+}
+
+// command returns a local command that runs cmd as user would on s
+func (s *Server) command(cmd string, user string) *exec.Cmd {
+	c := exec.Command("bash", "-c", cmd)
+	c.Dir = s.Home(user)
+	if _, err := os.Stat(c.Dir); err != nil {
+		c.Dir = s.dir
+	}
+	c.Env = append(os.Environ(),
+		"PATH="+s.bin()+string(os.PathListSeparator)+os.Getenv("PATH"),
+		"HOME="+s.Home(user),
+		"USER="+user,
+		"LOGNAME="+user,
+		"SSHTEST_USER="+user,
+		"SSHTEST_DIR="+s.dir,
+	)
+	return c
+}
@@ -0,0 +1,250 @@
+package sshtest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/krilor/gossh/target/rmt/suftp"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestAuth(t *testing.T) {
+
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+	signer, _ := ssh.NewSignerFromKey(key)
+	sshpub, _ := ssh.NewPublicKey(pub)
+
+	s, err := New(map[string]User{
+		"pwd": {Password: "secret"},
+		"key": {Keys: []ssh.PublicKey{sshpub}},
+	})
+	if err != nil {
+		t.Fatalf("could not start server: %v", err)
+	}
+	defer s.Close()
+
+	var tests = []struct {
+		name string
+		user string
+		auth ssh.AuthMethod
+		ok   bool
+	}{
+		{"password", "pwd", ssh.Password("secret"), true},
+		{"wrong password", "pwd", ssh.Password("wrong"), false},
+		{"key", "key", ssh.PublicKeys(signer), true},
+		{"key for other user", "pwd", ssh.PublicKeys(signer), false},
+		{"empty password", "key", ssh.Password(""), false},
+		{"unknown user", "nobody", ssh.Password("secret"), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, err := ssh.Dial("tcp", s.Addr(), &ssh.ClientConfig{
+				User:            test.user,
+				Auth:            []ssh.AuthMethod{test.auth},
+				HostKeyCallback: ssh.FixedHostKey(s.HostKey()),
+			})
+			if (err == nil) != test.ok {
+				t.Errorf("got err %v - expect ok %t", err, test.ok)
+			}
+			if err == nil {
+				c.Close()
+			}
+		})
+	}
+}
+
+// run runs cmd with stdin on c and returns stdout, stderr and exit status
+func run(t *testing.T, c *ssh.Client, cmd string, stdin string) (string, string, int) {
+	t.Helper()
+
+	session, err := c.NewSession()
+	if err != nil {
+		t.Fatalf("could not create session: %v", err)
+	}
+	defer session.Close()
+
+	o, e := &bytes.Buffer{}, &bytes.Buffer{}
+	session.Stdout = o
+	session.Stderr = e
+	session.Stdin = strings.NewReader(stdin)
+
+	err = session.Run(cmd)
+	status := 0
+	if exit, ok := err.(*ssh.ExitError); ok {
+		status = exit.ExitStatus()
+	} else if err != nil {
+		t.Fatalf("run errored: %v", err)
+	}
+
+	return strings.TrimSpace(o.String()), strings.TrimSpace(e.String()), status
+}
+
+func TestExec(t *testing.T) {
+
+	s, err := New(nil)
+	if err != nil {
+		t.Fatalf("could not start server: %v", err)
+	}
+	defer s.Close()
+
+	var tests = []struct {
+		user   string
+		cmd    string
+		stdin  string
+		stdout string
+		stderr string
+		exit   int
+	}{
+		{"gossh", `printf hello`, "", "hello", "", 0},
+		{"gossh", `echo $HOME`, "", s.Home("gossh"), "", 0},
+		{"gossh", `sed s/a/X/`, "abc", "Xbc", "", 0},
+		{"gossh", `exit 3`, "", "", "", 3},
+		{"gossh", `cat filethatdoesntexist`, "", "", "cat: filethatdoesntexist: No such file or directory", 1},
+		{"gossh", `sudo -p PROMPT -S -u root sh -c 'echo $USER'`, "gosshpwd\n", "root", "PROMPT", 0},
+		{"gossh", `sudo -p PROMPT -S -u root sh -c 'echo $USER'`, "wrong\nwrong\nwrong\n", "", "PROMPTSorry, try again.\nPROMPTSorry, try again.\nPROMPTsudo: 3 incorrect password attempts", 1},
+		{"hobgob", `sudo -u gossh sh -c 'echo $HOME'`, "", s.Home("gossh"), "", 0},
+		{"stinky", `sudo -p PROMPT -S -u root true`, "stinkypwd\n", "", "PROMPTstinky is not in the sudoers file.  This incident will be reported.", 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.user+" "+test.cmd, func(t *testing.T) {
+			c, err := s.NewSSHClient(test.user)
+			if err != nil {
+				t.Fatalf("could not connect: %v", err)
+			}
+			defer c.Close()
+
+			stdout, stderr, exit := run(t, c, test.cmd, test.stdin)
+			if stdout != test.stdout || stderr != test.stderr || exit != test.exit {
+				t.Errorf("got \"%s\" \"%s\" %d - expect \"%s\" \"%s\" %d", stdout, stderr, exit, test.stdout, test.stderr, test.exit)
+			}
+		})
+	}
+}
+
+func TestSftp(t *testing.T) {
+
+	s, err := New(nil)
+	if err != nil {
+		t.Fatalf("could not start server: %v", err)
+	}
+	defer s.Close()
+
+	c, err := s.NewSSHClient("gossh")
+	if err != nil {
+		t.Fatalf("could not connect: %v", err)
+	}
+	defer c.Close()
+
+	client, err := sftp.NewClient(c)
+	if err != nil {
+		t.Fatalf("could not start sftp: %v", err)
+	}
+	defer client.Close()
+
+	path := s.Home("gossh") + "/file"
+	f, err := client.Create(path)
+	if err != nil {
+		t.Fatalf("could not create file: %v", err)
+	}
+	f.Write([]byte("content"))
+	f.Close()
+
+	out, _, _, err := s.Exec("cat " + path)
+	if err != nil || out != "content" {
+		t.Errorf("got \"%s\" %v - expect content", out, err)
+	}
+
+	err = client.Mkdir(path + "dir")
+	if err != nil {
+		t.Fatalf("could not create dir: %v", err)
+	}
+
+	for _, p := range []string{path, path + "dir"} {
+		if o, ok := s.Owner(p); o != "gossh" || !ok {
+			t.Errorf("owner of %s: got %s %v - expect gossh", p, o, ok)
+		}
+	}
+
+	if _, ok := s.Owner(s.Home("gossh")); ok {
+		t.Errorf("expect no owner of directory not created over sftp")
+	}
+}
+
+func TestSudoSftp(t *testing.T) {
+
+	s, err := New(nil)
+	if err != nil {
+		t.Fatalf("could not start server: %v", err)
+	}
+	defer s.Close()
+
+	c, err := s.NewSSHClient("gossh")
+	if err != nil {
+		t.Fatalf("could not connect: %v", err)
+	}
+	defer c.Close()
+
+	// the sudo password is sent before the sftp session is handed over to the server
+	client, err := suftp.NewSudoClient(context.Background(), c, "stinky", "gosshpwd")
+	if err != nil {
+		t.Fatalf("could not start sudo sftp: %v", err)
+	}
+	defer client.Close()
+
+	path := s.Home("stinky") + "/file"
+	f, err := client.Create(path)
+	if err != nil {
+		t.Fatalf("could not create file: %v", err)
+	}
+	f.Write([]byte("content"))
+	f.Close()
+
+	out, _, _, err := s.Exec("cat " + path)
+	if err != nil || out != "content" {
+		t.Errorf("got \"%s\" %v - expect content", out, err)
+	}
+
+	if o, ok := s.Owner(path); o != "stinky" || !ok {
+		t.Errorf("owner: got %s %v - expect stinky", o, ok)
+	}
+}
+
+func TestReadHandoff(t *testing.T) {
+
+	var tests = []struct {
+		in   string
+		user string
+		ok   bool
+		rest string
+	}{
+		{sftpHandoff + "stinky\nrequests", "stinky", true, "requests"},
+		{"hello", "", false, "hello"},
+		{sftpHandoff[:4], "", false, sftpHandoff[:4]},
+		{"", "", false, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(strings.TrimPrefix(test.in, "\x00"), func(t *testing.T) {
+			r := bufio.NewReader(strings.NewReader(test.in))
+
+			user, ok := readHandoff(r)
+			rest, _ := ioutil.ReadAll(r)
+
+			if user != test.user || ok != test.ok || string(rest) != test.rest {
+				t.Errorf("got %q %v %q - expect %q %v %q", user, ok, rest, test.user, test.ok, test.rest)
+			}
+		})
+	}
+}
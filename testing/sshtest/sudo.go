@@ -0,0 +1,132 @@
+package sshtest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// sftpHandoff starts the stdout of the fake sftp-server command, followed by the user and a newline.
+// When a command starts its stdout with it, the server stops running the command and serves sftp on the session itself, see Server.exec.
+const sftpHandoff = "\x00sshtest-sftp "
+
+// sftpServerScript is the fake sftp-server command. It hands the session over to the server, and echoes any sftp requests it got before that back to it.
+const sftpServerScript = `#!/bin/sh
+printf '\000sshtest-sftp %s\n' "$SSHTEST_USER"
+exec cat
+`
+
+// sudoScript is the fake sudo command. USERS is replaced with a case per user.
+//
+// It prompts for passwords like sudo, also the prompt given with -p, and then runs the command with USER and HOME of the target user.
+const sudoScript = `#!/bin/sh
+# sudo emulation by sshtest. It prompts for passwords like sudo, but does not switch user.
+prompt="[sudo] password for $SSHTEST_USER: "
+user=root
+while [ $# -gt 0 ]; do
+	case "$1" in
+	-p) prompt="$2"; shift 2 ;;
+	-u) user="$2"; shift 2 ;;
+	-S|-n|-H|-E) shift ;;
+	--) shift; break ;;
+	*) break ;;
+	esac
+done
+
+mode=none
+pwd=
+lecture=0
+case "$SSHTEST_USER" in
+USERS
+esac
+
+# like sudo, users without sudo rights are prompted for password before they are rejected
+if [ "$mode" != nopassword ] && [ -n "$pwd" ]; then
+	if [ "$lecture" = 1 ]; then
+		printf '%s\n\n' "We trust you have received the usual lecture from the local System Administrator." >&2
+	fi
+	tries=0
+	while :; do
+		printf '%s' "$prompt" >&2
+		IFS= read -r input || exit 1
+		[ "$input" = "$pwd" ] && break
+		tries=$((tries+1))
+		if [ $tries -ge 3 ]; then
+			echo "sudo: 3 incorrect password attempts" >&2
+			exit 1
+		fi
+		echo "Sorry, try again." >&2
+	done
+fi
+
+if [ "$mode" = none ]; then
+	echo "$SSHTEST_USER is not in the sudoers file.  This incident will be reported." >&2
+	exit 1
+fi
+
+export USER="$user" LOGNAME="$user" HOME="$SSHTEST_DIR/home/$user" SSHTEST_USER="$user"
+exec "$@"
+`
+
+// quote single quotes s for sh
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, `'`, `'\''`) + "'"
+}
+
+// bin returns the directory of the fake commands
+func (s *Server) bin() string {
+	return filepath.Join(s.dir, "bin")
+}
+
+// writeBin writes the fake sudo and sftp-server commands to the bin dir
+func (s *Server) writeBin() error {
+
+	err := os.MkdirAll(s.bin(), 0755)
+	if err != nil {
+		return errors.Wrap(err, "could not create bin dir")
+	}
+
+	names := []string{}
+	for name := range s.users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	cases := strings.Builder{}
+	for _, name := range names {
+		u := s.users[name]
+
+		mode := "none"
+		switch u.Sudo {
+		case SudoPassword:
+			mode = "password"
+		case SudoNoPassword:
+			mode = "nopassword"
+		}
+
+		lecture := 0
+		if u.Lecture {
+			lecture = 1
+		}
+
+		fmt.Fprintf(&cases, "%s) mode=%s; pwd=%s; lecture=%d ;;\n", quote(name), mode, quote(u.Password), lecture)
+	}
+
+	sudo := strings.Replace(sudoScript, "USERS\n", cases.String(), 1)
+	err = ioutil.WriteFile(filepath.Join(s.bin(), "sudo"), []byte(sudo), 0755)
+	if err != nil {
+		return errors.Wrap(err, "could not write sudo")
+	}
+
+	err = ioutil.WriteFile(filepath.Join(s.bin(), "sftp-server"), []byte(sftpServerScript), 0755)
+	if err != nil {
+		return errors.Wrap(err, "could not write sftp-server")
+	}
+
+	return nil
+}
@@ -0,0 +1,373 @@
+package gossh
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ErrVarNotSet is returned, wrapped, from the typed variable getters of Host when the variable is not set
+var ErrVarNotSet = errors.New("variable not set")
+
+// vars holds the variables of a host
+type vars struct {
+	mu sync.RWMutex
+	kv map[string]interface{}
+}
+
+// newVars returns an empty set of variables
+func newVars() *vars {
+	return &vars{
+		kv: map[string]interface{}{},
+	}
+}
+
+// inventoryVars holds the variables of an inventory. It is shared by the inventories returned from Limit.
+type inventoryVars struct {
+	mu sync.RWMutex
+	// all are the variables of all hosts
+	all map[string]interface{}
+	// groups are the variables of groups, keyed by group name
+	groups map[string]map[string]interface{}
+	// extra are the extra variables, that takes precedence over all other variables
+	extra map[string]interface{}
+}
+
+// newInventoryVars returns an empty set of inventory variables
+func newInventoryVars() *inventoryVars {
+	return &inventoryVars{
+		all:    map[string]interface{}{},
+		groups: map[string]map[string]interface{}{},
+		extra:  map[string]interface{}{},
+	}
+}
+
+// variables returns the variables of i, creating them if i is the zero value
+func (i *Inventory) variables() *inventoryVars {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.vars == nil {
+		i.vars = newInventoryVars()
+	}
+	return i.vars
+}
+
+// SetVar sets the variable key to value on all hosts in i. It is the same as a variable of AllGroup.
+// See Host.Vars for the precedence of variables.
+func (i *Inventory) SetVar(key string, value interface{}) {
+	v := i.variables()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.all[key] = value
+}
+
+// SetGroupVar sets the variable key to value on the hosts in the group named group, including the hosts in child groups.
+// See Host.Vars for the precedence of variables.
+func (i *Inventory) SetGroupVar(group string, key string, value interface{}) {
+	if group == AllGroup {
+		i.SetVar(key, value)
+		return
+	}
+
+	v := i.variables()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.groups[group] == nil {
+		v.groups[group] = map[string]interface{}{}
+	}
+	v.groups[group][key] = value
+}
+
+// SetExtraVar sets the variable key to value on all hosts in i, overriding any other variable with the same key.
+// Use it for run-level overrides, e.g. given on the command line.
+// See Host.Vars for the precedence of variables.
+func (i *Inventory) SetExtraVar(key string, value interface{}) {
+	v := i.variables()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.extra[key] = value
+}
+
+// layers returns copies of the variables in i of h, in order of increasing precedence, and the extra variables of i.
+func (i *Inventory) layers(h *Host) (layers []map[string]interface{}, extra map[string]interface{}) {
+	i.mu.RLock()
+	groups := i.groupsOf(h)
+	v := i.vars
+	i.mu.RUnlock()
+
+	if v == nil {
+		return nil, nil
+	}
+
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	layers = append(layers, copyVars(v.all))
+	for _, g := range groups {
+		layers = append(layers, copyVars(v.groups[g]))
+	}
+
+	return layers, copyVars(v.extra)
+}
+
+// SetVar sets the variable key to value on h.
+// See Host.Vars for the precedence of variables.
+func (h *Host) SetVar(key string, value interface{}) {
+	h.vars.mu.Lock()
+	defer h.vars.mu.Unlock()
+
+	h.vars.kv[key] = value
+}
+
+// Var returns the value of the variable key on h, see Vars. Ok is false if the variable is not set.
+func (h *Host) Var(key string) (value interface{}, ok bool) {
+	value, ok = h.Vars()[key]
+	return value, ok
+}
+
+// Vars returns a copy of all variables of h.
+//
+// The variables of a host are resolved from the following, in order of increasing precedence:
+//
+//  1. the variables of all hosts in the inventory of h, set with Inventory.SetVar
+//  2. the variables of the groups of h, set with Inventory.SetGroupVar.
+//     Parent groups comes before their children, and groups at the same level are ordered by name.
+//  3. the variables of h, set with Host.SetVar
+//  4. the extra variables of the inventory of h, set with Inventory.SetExtraVar
+//
+// A variable with higher precedence replaces the ones with lower, except that maps (map[string]interface{}) are merged, recursively.
+// E.g. a host variable {"ntp": {"servers": [...]}} keeps the group variable {"ntp": {"enabled": true}}.
+//
+// Only the variables of h are used if h is not in an inventory.
+func (h *Host) Vars() map[string]interface{} {
+	var layers []map[string]interface{}
+	var extra map[string]interface{}
+
+	if h.inventory != nil {
+		layers, extra = h.inventory.layers(h)
+	}
+
+	h.vars.mu.RLock()
+	layers = append(layers, copyVars(h.vars.kv), extra)
+	h.vars.mu.RUnlock()
+
+	kv := map[string]interface{}{}
+	for _, layer := range layers {
+		kv = mergeVars(kv, layer)
+	}
+
+	return kv
+}
+
+// VarString returns the variable key as a string. Numbers and booleans are formatted, e.g. 8080 is "8080".
+func (h *Host) VarString(key string) (string, error) {
+	v, err := h.requireVar(key)
+	if err != nil {
+		return "", err
+	}
+
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, json.Number:
+		return fmt.Sprint(v), nil
+	}
+
+	return "", fmt.Errorf("variable %s is a %T, not a string", key, v)
+}
+
+// VarInt returns the variable key as an int. Whole floats, e.g. from JSON, and strings, e.g. from INI, are converted.
+func (h *Host) VarInt(key string) (int, error) {
+	v, err := h.requireVar(key)
+	if err != nil {
+		return 0, err
+	}
+
+	switch v := v.(type) {
+	case int:
+		return v, nil
+	case int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		n, err := strconv.Atoi(fmt.Sprint(v))
+		if err == nil {
+			return n, nil
+		}
+	case float32:
+		return wholeInt(key, float64(v))
+	case float64:
+		return wholeInt(key, v)
+	case json.Number:
+		n, err := strconv.Atoi(v.String())
+		if err == nil {
+			return n, nil
+		}
+	case string:
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err == nil {
+			return n, nil
+		}
+	}
+
+	return 0, fmt.Errorf("variable %s is not an int: %v", key, v)
+}
+
+// wholeInt returns f as an int, if it is a whole number
+func wholeInt(key string, f float64) (int, error) {
+	if f != math.Trunc(f) || f > math.MaxInt64 || f < math.MinInt64 {
+		return 0, fmt.Errorf("variable %s is not an int: %v", key, f)
+	}
+	return int(f), nil
+}
+
+// VarBool returns the variable key as a bool. The strings true, false, yes, no, on, off, 1 and 0 are converted, regardless of case.
+func (h *Host) VarBool(key string) (bool, error) {
+	v, err := h.requireVar(key)
+	if err != nil {
+		return false, err
+	}
+
+	switch v := v.(type) {
+	case bool:
+		return v, nil
+	case string:
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "true", "yes", "on", "1":
+			return true, nil
+		case "false", "no", "off", "0":
+			return false, nil
+		}
+	}
+
+	return false, fmt.Errorf("variable %s is not a bool: %v", key, v)
+}
+
+// VarList returns the variable key as a list.
+// A string is parsed as a JSON list if it starts with [, e.g. from INI, and else split on commas.
+func (h *Host) VarList(key string) ([]interface{}, error) {
+	v, err := h.requireVar(key)
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := v.(type) {
+	case []interface{}:
+		return append([]interface{}{}, v...), nil
+	case []string:
+		l := []interface{}{}
+		for _, s := range v {
+			l = append(l, s)
+		}
+		return l, nil
+	case string:
+		s := strings.TrimSpace(v)
+		if strings.HasPrefix(s, "[") {
+			l := []interface{}{}
+			err := json.Unmarshal([]byte(s), &l)
+			if err != nil {
+				return nil, fmt.Errorf("variable %s is not a list: %v", key, err)
+			}
+			return l, nil
+		}
+
+		l := []interface{}{}
+		for _, e := range strings.Split(s, ",") {
+			if e = strings.TrimSpace(e); e != "" {
+				l = append(l, e)
+			}
+		}
+		return l, nil
+	}
+
+	return nil, fmt.Errorf("variable %s is a %T, not a list", key, v)
+}
+
+// VarStrings returns the variable key as a list of strings, see VarList. Numbers and booleans in the list are formatted.
+func (h *Host) VarStrings(key string) ([]string, error) {
+	l, err := h.VarList(key)
+	if err != nil {
+		return nil, err
+	}
+
+	out := []string{}
+	for _, e := range l {
+		switch e.(type) {
+		case []interface{}, map[string]interface{}, map[interface{}]interface{}, nil:
+			return nil, fmt.Errorf("variable %s is not a list of strings: %v", key, l)
+		}
+		out = append(out, fmt.Sprint(e))
+	}
+
+	return out, nil
+}
+
+// VarMap returns the variable key as a map. A string is parsed as a JSON object if it starts with {, e.g. from INI.
+func (h *Host) VarMap(key string) (map[string]interface{}, error) {
+	v, err := h.requireVar(key)
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return copyVars(v), nil
+	case map[interface{}]interface{}:
+		return yamlValue(v).(map[string]interface{}), nil
+	case string:
+		if s := strings.TrimSpace(v); strings.HasPrefix(s, "{") {
+			kv := map[string]interface{}{}
+			err := json.Unmarshal([]byte(s), &kv)
+			if err != nil {
+				return nil, fmt.Errorf("variable %s is not a map: %v", key, err)
+			}
+			return kv, nil
+		}
+	}
+
+	return nil, fmt.Errorf("variable %s is a %T, not a map", key, v)
+}
+
+// requireVar returns the variable key, or an error wrapping ErrVarNotSet if it is not set
+func (h *Host) requireVar(key string) (interface{}, error) {
+	v, ok := h.Var(key)
+	if !ok {
+		return nil, errors.Wrap(ErrVarNotSet, key)
+	}
+	return v, nil
+}
+
+// copyVars returns a shallow copy of kv
+func copyVars(kv map[string]interface{}) map[string]interface{} {
+	out := map[string]interface{}{}
+	for k, v := range kv {
+		out[k] = v
+	}
+	return out
+}
+
+// mergeVars returns a copy of a with the variables in b added. Variables in b takes precedence, except that maps in both a and b are merged.
+// Neither a nor b is modified.
+func mergeVars(a map[string]interface{}, b map[string]interface{}) map[string]interface{} {
+	if a == nil && b == nil {
+		return nil
+	}
+
+	out := copyVars(a)
+	for k, v := range b {
+		am, aok := out[k].(map[string]interface{})
+		bm, bok := v.(map[string]interface{})
+		if aok && bok {
+			out[k] = mergeVars(am, bm)
+			continue
+		}
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,196 @@
+package gossh
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/krilor/gossh/testing/faketarget"
+	"github.com/pkg/errors"
+)
+
+func TestVarsPrecedence(t *testing.T) {
+
+	i := newTestInventory(t, 3)
+	h := i.Hosts()
+
+	// eu is the parent of web, and web and db are at the same level for host0
+	i.AddChildren("eu", "web")
+	i.AddToGroup("web", h[0], h[1])
+	i.AddToGroup("db", h[0])
+
+	i.SetVar("ntp", "pool.ntp.org")
+	i.SetVar("level", "all")
+	i.SetVar("app", map[string]interface{}{"port": 80, "tls": map[string]interface{}{"enabled": false}})
+
+	i.SetGroupVar("eu", "level", "eu")
+	i.SetGroupVar("eu", "ntp", "eu.pool.ntp.org")
+	i.SetGroupVar("web", "level", "web")
+	i.SetGroupVar("db", "level", "db")
+	i.SetGroupVar("web", "app", map[string]interface{}{"tls": map[string]interface{}{"enabled": true}})
+	i.SetGroupVar(AllGroup, "from_all", true)
+
+	h[0].SetVar("app", map[string]interface{}{"port": 8080})
+	h[1].SetVar("level", "host")
+
+	var tests = []struct {
+		host   *Host
+		expect map[string]interface{}
+	}{
+		{
+			host: h[0],
+			expect: map[string]interface{}{
+				"ntp":      "eu.pool.ntp.org",
+				"level":    "web",
+				"from_all": true,
+				"app":      map[string]interface{}{"port": 8080, "tls": map[string]interface{}{"enabled": true}},
+			},
+		},
+		{
+			host: h[1],
+			expect: map[string]interface{}{
+				"ntp":      "eu.pool.ntp.org",
+				"level":    "host",
+				"from_all": true,
+				"app":      map[string]interface{}{"port": 80, "tls": map[string]interface{}{"enabled": true}},
+			},
+		},
+		{
+			host: h[2],
+			expect: map[string]interface{}{
+				"ntp":      "pool.ntp.org",
+				"level":    "all",
+				"from_all": true,
+				"app":      map[string]interface{}{"port": 80, "tls": map[string]interface{}{"enabled": false}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.host.String(), func(t *testing.T) {
+			if got := test.host.Vars(); !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("\n got %v\n expect %v", got, test.expect)
+			}
+
+			// rules see the same variables
+			var got map[string]interface{}
+			test.host.Apply(context.Background(), "vars", ruleFunc(func(ctx context.Context, h *Host) (Status, error) {
+				got = h.Vars()
+				return StatusSatisfied, nil
+			}))
+			if !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("in rule:\n got %v\n expect %v", got, test.expect)
+			}
+		})
+	}
+
+	// the variables are shared with limited inventories, and extra variables overrides all others
+	l, err := i.Limit("web")
+	if err != nil {
+		t.Fatalf("limit errored: %v", err)
+	}
+	l.SetExtraVar("level", "extra")
+
+	for _, m := range i.Hosts() {
+		if v, _ := m.Var("level"); v != "extra" {
+			t.Errorf("%s: got level %v - expect extra", m, v)
+		}
+	}
+
+	// the values set are not modified by merging
+	if app, _ := i.Host("gossh@host2").Var("app"); !reflect.DeepEqual(app, map[string]interface{}{"port": 80, "tls": map[string]interface{}{"enabled": false}}) {
+		t.Errorf("inventory app modified: %v", app)
+	}
+
+	// hosts that are not in an inventory only have their own variables
+	s := New(faketarget.New(t, "gossh"))
+	s.SetVar("ntp", "time.example.com")
+	if got := s.Vars(); !reflect.DeepEqual(got, map[string]interface{}{"ntp": "time.example.com"}) {
+		t.Errorf("standalone host: got %v", got)
+	}
+}
+
+func TestTypedVars(t *testing.T) {
+
+	h := New(faketarget.New(t, "gossh"))
+
+	values := map[string]interface{}{
+		"str":        "hello",
+		"json_int":   float64(8080),
+		"json_float": 1.5,
+		"yaml_int":   8080,
+		"ini_int":    "8080",
+		"bool":       true,
+		"ini_bool":   "yes",
+		"list":       []interface{}{"a", 1, true},
+		"strings":    []string{"a", "b"},
+		"ini_list":   `["a", "b"]`,
+		"csv":        "a, b,c",
+		"map":        map[string]interface{}{"a": 1},
+		"yaml_map":   map[interface{}]interface{}{"a": 1, 2: []interface{}{map[interface{}]interface{}{"b": "c"}}},
+		"ini_map":    `{"a": 1}`,
+		"nested":     []interface{}{[]interface{}{"a"}},
+	}
+	for k, v := range values {
+		h.SetVar(k, v)
+	}
+
+	get := map[string]func(key string) (interface{}, error){
+		"string":  func(key string) (interface{}, error) { return h.VarString(key) },
+		"int":     func(key string) (interface{}, error) { return h.VarInt(key) },
+		"bool":    func(key string) (interface{}, error) { return h.VarBool(key) },
+		"list":    func(key string) (interface{}, error) { return h.VarList(key) },
+		"strings": func(key string) (interface{}, error) { return h.VarStrings(key) },
+		"map":     func(key string) (interface{}, error) { return h.VarMap(key) },
+	}
+
+	var tests = []struct {
+		getter string
+		key    string
+		expect interface{}
+		err    bool
+	}{
+		{"string", "str", "hello", false},
+		{"string", "json_int", "8080", false},
+		{"string", "bool", "true", false},
+		{"string", "list", nil, true},
+		{"int", "json_int", 8080, false},
+		{"int", "yaml_int", 8080, false},
+		{"int", "ini_int", 8080, false},
+		{"int", "json_float", nil, true},
+		{"int", "str", nil, true},
+		{"bool", "bool", true, false},
+		{"bool", "ini_bool", true, false},
+		{"bool", "str", nil, true},
+		{"list", "list", []interface{}{"a", 1, true}, false},
+		{"list", "strings", []interface{}{"a", "b"}, false},
+		{"list", "ini_list", []interface{}{"a", "b"}, false},
+		{"list", "map", nil, true},
+		{"strings", "list", []string{"a", "1", "true"}, false},
+		{"strings", "csv", []string{"a", "b", "c"}, false},
+		{"strings", "nested", nil, true},
+		{"map", "map", map[string]interface{}{"a": 1}, false},
+		{"map", "yaml_map", map[string]interface{}{"a": 1, "2": []interface{}{map[string]interface{}{"b": "c"}}}, false},
+		{"map", "ini_map", map[string]interface{}{"a": float64(1)}, false},
+		{"map", "str", nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.getter+" "+test.key, func(t *testing.T) {
+			got, err := get[test.getter](test.key)
+			if (err != nil) != test.err {
+				t.Fatalf("error: got %v - expect error %v", err, test.err)
+			}
+			if !test.err && !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("got %#v - expect %#v", got, test.expect)
+			}
+		})
+	}
+
+	for name, getter := range get {
+		_, err := getter("notset")
+		if errors.Cause(err) != ErrVarNotSet {
+			t.Errorf("%s: got %v - expect ErrVarNotSet", name, err)
+		}
+	}
+}